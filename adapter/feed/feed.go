@@ -0,0 +1,128 @@
+package feed
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Line-delimited TCP quote feed.
+//
+// Client sends:  SUBSCRIBE AAPL,GOOG\n
+// Server sends:  <utc_timestamp>,s,<encoded stock>\n  or  <utc_timestamp>,o,<encoded option>\n
+//
+// Same encoding as lines in live/quotes so feeds can be faked by replaying those files.
+
+const (
+	DIAL_TIMEOUT = 10 * time.Second
+)
+
+type Feed struct {
+	addr string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+func New(addr string) *Feed {
+	return &Feed{addr: addr}
+}
+
+func (f *Feed) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+func (f *Feed) Stream(symbols []string, pipe chan structs.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.conn != nil {
+		return errors.New("already streaming")
+	}
+	conn, err := net.DialTimeout("tcp", f.addr, DIAL_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(conn, "SUBSCRIBE %s\n", strings.Join(symbols, ","))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	f.conn = conn
+	f.closed = false
+
+	wanted := map[string]bool{}
+	for _, symbol := range symbols {
+		wanted[symbol] = true
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			m, underlying, err := ParseLine(scanner.Text())
+			if err != nil {
+				fmt.Printf("[feed] Skipping line: %s\n", err)
+				continue
+			}
+			// Servers are free to ignore SUBSCRIBE.
+			if !wanted[underlying] {
+				continue
+			}
+			pipe <- m
+		}
+		f.mu.Lock()
+		closed := f.closed
+		f.mu.Unlock()
+		if err := scanner.Err(); err != nil && !closed {
+			fmt.Printf("[feed] Read Err: %s\n", err)
+		}
+		// Signal done.
+		pipe <- structs.Message{}
+	}()
+
+	return nil
+}
+
+// Turn feed line into Message and return the underlying it belongs to.
+func ParseLine(line string) (structs.Message, string, error) {
+	m := structs.Message{}
+	columns := strings.SplitN(line, ",", 3)
+	if len(columns) < 3 {
+		return m, "", fmt.Errorf("expected at least 3 columns: '%s'", line)
+	}
+	timestamp, err := strconv.ParseInt(columns[0], 10, 64)
+	if err != nil {
+		return m, "", err
+	}
+	m.Timestamp = timestamp
+
+	switch columns[1] {
+	case "s":
+		s := structs.Stock{}
+		err = funcs.Decode(columns[2], &s, funcs.StockEncodingOrder)
+		m.Data = s
+		return m, s.Symbol, err
+	case "o":
+		o := structs.Option{}
+		err = funcs.Decode(columns[2], &o, funcs.OptionEncodingOrder)
+		m.Data = o
+		return m, o.Underlying, err
+	}
+	return m, "", fmt.Errorf("bad type: '%s'", columns[1])
+}
@@ -0,0 +1,100 @@
+package feed
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testLines() []string {
+	lines := []string{}
+	for i, symbol := range []string{"AAPL", "GOOG"} {
+		s := structs.Stock{Symbol: symbol, Bid: 10000 * (i + 1), Time: 34260}
+		es, _ := funcs.Encode(&s, funcs.StockEncodingOrder)
+		o := structs.Option{Symbol: symbol + "_013015C100", Underlying: symbol, Expiration: "20150130", Bid: 100, Time: 34260, Type: "c"}
+		eo, _ := funcs.Encode(&o, funcs.OptionEncodingOrder)
+		lines = append(lines, fmt.Sprintf("%d,s,%s", 1422455460+i, es), fmt.Sprintf("%d,o,%s", 1422455460+i, eo))
+	}
+	return append(lines, "garbage", "1422455460,x,AAPL")
+}
+
+func Test_Feed_Stream(t *testing.T) {
+	server, err := NewServer(testLines())
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	defer server.Close()
+
+	f := New(server.Addr())
+	pipe := make(chan structs.Message, 100)
+	err = f.Stream([]string{"AAPL"}, pipe)
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	defer f.Close()
+
+	err = f.Stream([]string{"AAPL"}, pipe)
+	if err == nil {
+		t.Errorf("Expected err for second Stream() call.")
+	}
+
+	messages := []structs.Message{}
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case m := <-pipe:
+			if m.Data == nil {
+				done = true
+				continue
+			}
+			messages = append(messages, m)
+		case <-timeout:
+			t.Fatalf("Timed out waiting for feed.")
+		}
+	}
+
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 AAPL messages, Got: %+v", messages)
+	}
+	s, ok := messages[0].Data.(structs.Stock)
+	if !ok || s.Symbol != "AAPL" || s.Bid != 10000 || messages[0].Timestamp != 1422455460 {
+		t.Errorf("Expected AAPL stock, Got: %+v", messages[0])
+	}
+	o, ok := messages[1].Data.(structs.Option)
+	if !ok || o.Symbol != "AAPL_013015C100" {
+		t.Errorf("Expected AAPL option, Got: %+v", messages[1])
+	}
+	if !reflect.DeepEqual(server.Subscribed(), [][]string{{"AAPL"}}) {
+		t.Errorf("Expected subscription to AAPL, Got: %v", server.Subscribed())
+	}
+}
+
+func Test_Feed_Stream_noServer(t *testing.T) {
+	server, _ := NewServer(nil)
+	addr := server.Addr()
+	server.Close()
+
+	err := New(addr).Stream([]string{"AAPL"}, make(chan structs.Message, 1))
+	if err == nil {
+		t.Errorf("Expected err when nothing is listening.")
+	}
+}
+
+func Test_ParseLine(t *testing.T) {
+	lines := testLines()
+
+	m, underlying, err := ParseLine(lines[3])
+	if err != nil || underlying != "GOOG" || m.Timestamp != 1422455461 {
+		t.Errorf("Expected GOOG option, Got: %+v, %s, %v", m, underlying, err)
+	}
+	for _, line := range lines[4:] {
+		_, _, err := ParseLine(line)
+		if err == nil {
+			t.Errorf("Expected err for: %s", line)
+		}
+	}
+}
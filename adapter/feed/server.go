@@ -0,0 +1,82 @@
+package feed
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Local fake feed for tests.  Every connection is sent all lines and then closed.
+// SUBSCRIBE is only recorded so tests can check it.  Feed does its own filtering.
+type Server struct {
+	lines    []string
+	listener net.Listener
+
+	mu         sync.Mutex
+	subscribed [][]string // Symbols from each SUBSCRIBE, in order received.
+	wg         sync.WaitGroup
+}
+
+func NewServer(lines []string) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{lines: lines, listener: listener}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) Subscribed() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.subscribed
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	request, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	symbols := strings.Split(strings.TrimSpace(strings.TrimPrefix(request, "SUBSCRIBE ")), ",")
+	s.mu.Lock()
+	s.subscribed = append(s.subscribed, symbols)
+	s.mu.Unlock()
+
+	w := bufio.NewWriter(conn)
+	for _, line := range s.lines {
+		fmt.Fprintln(w, line)
+	}
+	w.Flush()
+}
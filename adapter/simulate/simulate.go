@@ -2,15 +2,16 @@ package simulate
 
 import (
 	"github.com/eliwjones/thebox/util"
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/interfaces"
 	"github.com/eliwjones/thebox/util/structs"
 
-	"errors"
 	"fmt"
-	"math/rand"
 	"time"
 )
 
 const (
+	NAME  = "simulate" // Key for credentials and token in interfaces.Secrets.
 	TOKEN = "thisisanaccesstoken"
 )
 
@@ -21,6 +22,7 @@ type Simulate struct {
 	contractMultiplier map[util.ContractType]int            // How many contracts trade per unit of volume.  Generally 1 for stocks and 100 for options.
 	Token              string                               // account access token. (most likely oauth.)
 	Tables             map[string]int                       // "position", "order", "cash", "value" ... "margin"?
+	Rand               *funcs.Rand                          // For order ids.  Swap in a seeded one for replayable runs.
 
 	// Mocks.
 	Positions map[string]structs.Position // most likely just util.Positions.
@@ -39,6 +41,7 @@ func New(id string, auth string, cash int) *Simulate {
 
 	s.Token, _ = s.Connect(s.Id, s.Auth, "")
 	s.Tables = map[string]int{"position": 1, "order": 1, "cash": 1, "value": 1}
+	s.Rand = funcs.NewRand(funcs.RandomSeed())
 
 	// Mocked data.  Not about to make actual http api to simulate external resource.
 	s.Cash = cash
@@ -54,6 +57,21 @@ func New(id string, auth string, cash int) *Simulate {
 	return s
 }
 
+func NewFromSecrets(secrets interfaces.Secrets, cash int) (*Simulate, error) {
+	creds, err := secrets.Credentials(NAME)
+	if err != nil {
+		return nil, err
+	}
+	s := New(creds.Id, creds.Auth, cash)
+	if s.Token != TOKEN {
+		return s, util.NewAdapterError(NAME, util.AUTH, "auth failed for user: %s", creds.Id)
+	}
+	if secrets.Token(NAME) != s.Token {
+		err = secrets.SaveToken(NAME, s.Token)
+	}
+	return s, err
+}
+
 func (s *Simulate) Reset() {
 	// Called when crossing week boundaries.
 	s.Value = s.Cash
@@ -91,7 +109,7 @@ func (s *Simulate) Commission() map[util.ContractType]map[string]int {
 
 func (s *Simulate) Connect(id string, auth string, token string) (string, error) {
 	if id != "simulate" || auth != "simulation" {
-		return "", fmt.Errorf("auth failed for user: %s, auth: %s", id, auth)
+		return "", util.NewAdapterError(NAME, util.AUTH, "auth failed for user: %s, auth: %s", id, auth)
 	}
 	return TOKEN, nil
 }
@@ -102,7 +120,7 @@ func (s *Simulate) ContractMultiplier() map[util.ContractType]int {
 
 func (s *Simulate) Get(table string, key string) (any, error) {
 	if s.Token != TOKEN {
-		return nil, errors.New("bad auth token")
+		return nil, util.NewAdapterError(NAME, util.AUTH, "bad auth token")
 	}
 	if s.Tables[table] != 1 {
 		return nil, fmt.Errorf("invalid table: %s choose from: %+v", table, s.Tables)
@@ -131,7 +149,7 @@ func (s *Simulate) Get(table string, key string) (any, error) {
 
 func (s *Simulate) GetBalances() (map[string]int, error) {
 	if s.Token != TOKEN {
-		return nil, errors.New("bad auth token")
+		return nil, util.NewAdapterError(NAME, util.AUTH, "bad auth token")
 	}
 	// More complex api call and munging goes here.
 	return map[string]int{"cash": s.Cash, "value": s.Value}, nil
@@ -173,7 +191,7 @@ func (s *Simulate) GetOptions(symbol string, month string) ([]structs.Option, st
 
 func (s *Simulate) GetOrders(filter string) (map[string]structs.Order, error) {
 	if s.Token != TOKEN {
-		return nil, errors.New("bad auth token")
+		return nil, util.NewAdapterError(NAME, util.AUTH, "bad auth token")
 	}
 	// More complex api call and munging goes here.
 	return s.Orders, nil
@@ -181,7 +199,7 @@ func (s *Simulate) GetOrders(filter string) (map[string]structs.Order, error) {
 
 func (s *Simulate) GetPositions() (map[string]structs.Position, error) {
 	if s.Token != TOKEN {
-		return nil, errors.New("bad auth token")
+		return nil, util.NewAdapterError(NAME, util.AUTH, "bad auth token")
 	}
 	// More complex api call and munging goes here.
 	return s.Positions, nil
@@ -193,9 +211,9 @@ func (s *Simulate) orderCommission(o structs.Order) int {
 
 func (s *Simulate) SubmitOrder(order structs.Order) (string, error) {
 	if s.Token != TOKEN {
-		return "", errors.New("bad auth token")
+		return "", util.NewAdapterError(NAME, util.AUTH, "bad auth token")
 	}
-	orderid := fmt.Sprintf("order-%d", rand.Intn(1000000))
+	orderid := fmt.Sprintf("order-%d", s.Rand.IntN(1000000))
 	order.Id = orderid
 	s.Orders[orderid] = order
 
@@ -2,6 +2,7 @@ package simulate
 
 import (
 	"github.com/eliwjones/thebox/util"
+	"github.com/eliwjones/thebox/util/funcs"
 	"github.com/eliwjones/thebox/util/structs"
 
 	"testing"
@@ -155,4 +156,17 @@ func Test_Simulate_SubmitOrder(t *testing.T) {
 	if s.Positions[orderkey1].Order != o {
 		t.Errorf("Expected order to turn into Position!\n%v\n%v", o, s.Positions[orderkey1].Order)
 	}
+
+	// Same seed, same order ids.
+	s1 := New("simulate", "simulation", 300000*100)
+	s2 := New("simulate", "simulation", 300000*100)
+	s1.Rand = funcs.NewRand(42)
+	s2.Rand = funcs.NewRand(42)
+	for range 3 {
+		id1, _ := s1.SubmitOrder(o)
+		id2, _ := s2.SubmitOrder(o)
+		if id1 != id2 {
+			t.Errorf("Expected same order ids for same seed, Got: %s, %s", id1, id2)
+		}
+	}
 }
@@ -2,12 +2,11 @@ package tdameritrade
 
 import (
 	"github.com/eliwjones/thebox/util"
+	"github.com/eliwjones/thebox/util/interfaces"
 	"github.com/eliwjones/thebox/util/structs"
 
 	"bytes"
 	"encoding/xml"
-	"errors"
-	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -18,6 +17,7 @@ import (
 
 const (
 	BASEURL = "https://apis.tdameritrade.com"
+	NAME    = "tdameritrade" // Key for credentials and token in interfaces.Secrets.
 )
 
 // Lazy Kitchen Sink Struct.
@@ -94,6 +94,7 @@ type TDAmeritrade struct {
 
 	commission         map[util.ContractType]map[string]int // Commission information.
 	contractMultiplier map[util.ContractType]int            // How many contracts trade per unit of volume.  Generally 1 for stocks and 100 for options.
+	secrets            interfaces.Secrets                   // Where to save refreshed JsessionID.  May be nil.
 }
 
 func New(id string, auth string, source string, jsessionid string) *TDAmeritrade {
@@ -116,6 +117,24 @@ func New(id string, auth string, source string, jsessionid string) *TDAmeritrade
 	return s
 }
 
+// Login with stored credentials, reusing stored session if still good.
+func NewFromSecrets(secrets interfaces.Secrets) (*TDAmeritrade, error) {
+	creds, err := secrets.Credentials(NAME)
+	if err != nil {
+		return nil, err
+	}
+	token := secrets.Token(NAME)
+	s := New(creds.Id, creds.Auth, creds.Source, token)
+	s.secrets = secrets
+	if s.JsessionID == "" {
+		return s, util.NewAdapterError(NAME, util.AUTH, "could not connect as: %s", creds.Id)
+	}
+	if s.JsessionID != token {
+		err = secrets.SaveToken(NAME, s.JsessionID)
+	}
+	return s, err
+}
+
 func (s *TDAmeritrade) Reset() {
 	s.Positions = map[string]structs.Position{}
 	s.Orders = map[string]structs.Order{}
@@ -154,13 +173,16 @@ func (s *TDAmeritrade) Connect(id string, auth string, jsessionid string) (strin
 		return "", err
 	}
 	if result.Error != "" {
-		return "", fmt.Errorf(result.Error)
+		return "", util.NewAdapterError(NAME, util.AUTH, "%s", result.Error)
 	}
 	sessionID := result.SessionId
 	if sessionID == "" {
-		return "", errors.New(string(body))
+		return "", util.NewAdapterError(NAME, util.AUTH, "no session in: %s", string(body))
+	}
+	if s.secrets != nil && sessionID != jsessionid {
+		err = s.secrets.SaveToken(NAME, sessionID)
 	}
-	return sessionID, nil
+	return sessionID, err
 }
 
 func (s *TDAmeritrade) GetBalances() (map[string]int, error) {
@@ -172,18 +194,18 @@ func (s *TDAmeritrade) GetBalances() (map[string]int, error) {
 	result := TDAResponse{}
 	err = xml.Unmarshal(body, &result)
 	if err != nil {
-		return map[string]int{"cash": s.Cash, "value": s.Value}, err
+		return map[string]int{"cash": s.Cash, "value": s.Value}, util.NewAdapterError(NAME, util.BADDATA, "%w", err)
 	}
 	if result.Error != "" {
-		return map[string]int{"cash": s.Cash, "value": s.Value}, fmt.Errorf(result.Error)
+		return map[string]int{"cash": s.Cash, "value": s.Value}, util.NewAdapterError(NAME, util.UNKNOWN, "%s", result.Error)
 	}
 	cash, err := strconv.ParseFloat(result.AvailableFunds, 64)
 	if err != nil {
-		return map[string]int{"cash": s.Cash, "value": s.Value}, err
+		return map[string]int{"cash": s.Cash, "value": s.Value}, util.NewAdapterError(NAME, util.BADDATA, "%w", err)
 	}
 	value, err := strconv.ParseFloat(result.AccountValue, 64)
 	if err != nil {
-		return map[string]int{"cash": s.Cash, "value": s.Value}, err
+		return map[string]int{"cash": s.Cash, "value": s.Value}, util.NewAdapterError(NAME, util.BADDATA, "%w", err)
 	}
 	// Convert to cents and return int.
 	return map[string]int{"cash": int(cash * 100), "value": int(value * 100)}, nil
@@ -201,15 +223,15 @@ func (s *TDAmeritrade) GetOptions(symbol string, expire string) ([]structs.Optio
 	result := TDAResponse{}
 	err = xml.Unmarshal(body, &result)
 	if err != nil {
-		return options, stock, fmt.Errorf("body: %s, err: %s", string(body), err.Error())
+		return options, stock, util.NewAdapterError(NAME, util.BADDATA, "body: %s, err: %s", string(body), err.Error())
 	}
 	if result.Error != "" {
-		return options, stock, fmt.Errorf("api response error: %s", result.Error)
+		return options, stock, util.NewAdapterError(NAME, util.UNKNOWN, "api response error: %s", result.Error)
 	}
 
 	stock = underlyingToStock(result.Underlying)
 	if stock.Symbol != symbol {
-		return options, stock, fmt.Errorf("stock.symbol: '%s' != '%s'", stock.Symbol, symbol)
+		return options, stock, util.NewAdapterError(NAME, util.BADDATA, "stock.symbol: '%s' != '%s'", stock.Symbol, symbol)
 	}
 
 	for _, optionchain := range result.Underlying.OptionChains {
@@ -247,7 +269,7 @@ func (s *TDAmeritrade) GetOptions(symbol string, expire string) ([]structs.Optio
 	}
 
 	if len(options) < 6 {
-		return options, stock, fmt.Errorf("received less than 6 options")
+		return options, stock, util.NewAdapterError(NAME, util.BADDATA, "received less than 6 options")
 	}
 
 	return options, stock, nil
@@ -266,10 +288,10 @@ func (s *TDAmeritrade) GetPositions() (map[string]structs.Position, error) {
 	result := TDAResponse{}
 	err = xml.Unmarshal(body, &result)
 	if err != nil {
-		return nil, err
+		return nil, util.NewAdapterError(NAME, util.BADDATA, "%w", err)
 	}
 	if result.Error != "" {
-		return nil, fmt.Errorf(result.Error)
+		return nil, util.NewAdapterError(NAME, util.UNKNOWN, "%s", result.Error)
 	}
 	return s.Positions, nil
 }
@@ -358,12 +380,23 @@ func request(urlStr string, method string, params map[string]string) ([]byte, er
 
 	resp, err := http.DefaultClient.Do(r)
 	if err != nil {
-		return nil, err
+		return nil, util.NewAdapterError(NAME, util.NETWORK, "%w", err)
 	}
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, util.NewAdapterError(NAME, util.NETWORK, "%w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return body, util.NewAdapterError(NAME, util.RATELIMIT, "%s (Retry-After: '%s')", resp.Status, resp.Header.Get("Retry-After"))
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return body, util.NewAdapterError(NAME, util.AUTH, "%s", resp.Status)
+	case resp.StatusCode >= 500:
+		return body, util.NewAdapterError(NAME, util.NETWORK, "%s", resp.Status)
+	case resp.StatusCode >= 400:
+		return body, util.NewAdapterError(NAME, util.UNKNOWN, "%s", resp.Status)
 	}
 	return body, nil
 }
@@ -1,8 +1,12 @@
 package tdameritrade
 
 import (
+	"github.com/eliwjones/thebox/util"
 	"github.com/eliwjones/thebox/util/funcs"
 
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -127,3 +131,38 @@ func Test_TDAmeritrade_GetPositions(t *testing.T) {
 		}
 	*/
 }
+
+func Test_request(t *testing.T) {
+	statuses := map[int]util.ErrorKind{
+		http.StatusTooManyRequests:    util.RATELIMIT,
+		http.StatusUnauthorized:       util.AUTH,
+		http.StatusServiceUnavailable: util.NETWORK,
+		http.StatusNotFound:           util.UNKNOWN,
+	}
+	for status, kind := range statuses {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(status)
+		}))
+		_, err := request(server.URL, "GET", map[string]string{})
+		server.Close()
+		var ae *util.AdapterError
+		if !errors.As(err, &ae) || ae.Kind != kind {
+			t.Errorf("Expected %s for %d, Got: %v", kind, status, err)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("LoggedOn"))
+	}))
+	body, err := request(server.URL, "GET", map[string]string{})
+	if err != nil || string(body) != "LoggedOn" {
+		t.Errorf("Expected: LoggedOn, Got: %s, Err: %v", body, err)
+	}
+	url := server.URL
+	server.Close()
+	_, err = request(url, "GET", map[string]string{})
+	if util.Kind(err) != util.NETWORK {
+		t.Errorf("Expected NETWORK, Got: %v", err)
+	}
+}
@@ -1,23 +1,29 @@
 package main
 
 import (
+	"github.com/eliwjones/thebox/adapter/feed"
+	"github.com/eliwjones/thebox/adapter/simulate"
 	"github.com/eliwjones/thebox/adapter/tdameritrade"
 	"github.com/eliwjones/thebox/collector"
-	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/interfaces"
 
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"time"
 )
 
 var (
 	id       = flag.String("id", "", "In case one is multiple actions with same root_dir.")
-	action   = flag.String("action", "", "'clean', 'collect', 'migrate' or 'process_stream'?")
-	period   = flag.Int64("period", int64(60), "For RunOnce(), collector will panic once we get too close to the 'period'.")
+	action   = flag.String("action", "", "'archive', 'clean', 'collect', 'migrate', 'migrate_config', 'process_stream', 'rebuild_manifest', 'stream', 'summary' or 'verify'?")
+	period   = flag.Int64("period", int64(0), "For RunOnce(), collector will panic once we get too close to the 'period'.  Defaults to config interval.")
 	reckless = flag.Bool("reckless", false, "Request and save data ignoring trading time and day ranges.")
-	root_dir = flag.String("root_dir", "", "Where to find config file, 'log' and 'data' directories?")
-	start    = flag.String("start", "", "Starting Timestamp")
-	end      = flag.String("end", "", "Ending Timestamp")
+	resume   = flag.Bool("resume", false, "For 'process_stream', continue from the last checkpointed day.")
+	root_dir = flag.String("root_dir", "", "Where to find config.json, 'log' and 'data' directories?")
+	start    = flag.String("start", "", "Starting Timestamp.  For 'summary', first yyyymmdd to include.")
+	end      = flag.String("end", "", "Ending Timestamp.  For 'summary', last yyyymmdd to include.")
+	workers  = flag.Int("workers", 1, "For 'process_stream', how many goroutines to shard underlyings across.")
 	yymmdd   = flag.String("yymmdd", "", "For '-action clean' need <YYMMDD> to clean.")
 )
 
@@ -29,7 +35,7 @@ func init() {
 		os.Exit(1)
 	}
 	if *action == "" {
-		fmt.Printf("Please specify -action. ('collect', 'process_stream', 'clean' or 'migrate')\n")
+		fmt.Printf("Please specify -action. ('collect', 'stream', 'process_stream', 'archive', 'verify', 'rebuild_manifest', 'summary', 'clean', 'migrate' or 'migrate_config')\n")
 		os.Exit(1)
 	}
 	if (*action == "clean" || *action == "migrate") && *yymmdd == "" {
@@ -43,14 +49,88 @@ func init() {
 }
 
 func main() {
-	c := collector.New(*action+*id, *root_dir, *period)
+	if *action == "migrate_config" {
+		_, err := collector.MigrateConfig(*root_dir)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	cfg, err := collector.LoadConfig(*root_dir)
+	if err != nil {
+		// Only a missing config falls back to defaults.  One that is there but wrong should be fixed, not ignored.
+		missing := errors.Is(err, os.ErrNotExist)
+		if !missing || *action == "collect" || *action == "stream" {
+			fmt.Printf("Could not load %s/%s: %s\n", *root_dir, collector.CONFIG, err)
+			if missing {
+				fmt.Printf("Old line-based config can be converted with '-action migrate_config'.\n")
+			}
+			os.Exit(1)
+		}
+		cfg = collector.DefaultConfig(*root_dir)
+	}
+	if *action == "collect" || *action == "stream" {
+		err = cfg.ValidateCollect()
+		if err != nil {
+			fmt.Printf("%s/%s: %s\n", *root_dir, collector.CONFIG, err)
+			os.Exit(1)
+		}
+	}
+	if *period != 0 {
+		cfg.Interval = *period
+	}
+
+	c := collector.NewFromConfig(*action+*id, cfg)
 	c.Reckless = *reckless
+	c.Workers = *workers
 
 	switch *action {
 	case "collect":
-		collect(c)
+		collect(c, cfg)
+	case "stream":
+		stream(c, cfg)
 	case "process_stream":
-		c.ProcessStream(*start, *end)
+		c.ProcessStream(*start, *end, *resume)
+	case "archive":
+		err := c.Archive(time.Now().UTC(), cfg.Retention)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	case "verify":
+		problems, err := c.Verify()
+		if errors.Is(err, collector.ErrNoManifest) {
+			fmt.Printf("%s.  Data collected before manifests can be vouched for with '-action rebuild_manifest'.\n", err)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+	case "rebuild_manifest":
+		err := c.RebuildManifest()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	case "summary":
+		summaries, err := collector.Summarize(cfg.Paths.Error, *start, *end)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, s := range summaries {
+			fmt.Printf("%6d  %-9s  %-20s  %-6s  %s - %s  %s\n", s.Count, s.Kind, s.Function, s.Symbol,
+				s.First.Format("2006-01-02 15:04"), s.Last.Format("2006-01-02 15:04"), s.Message)
+		}
 	case "clean":
 		collector.Clean(*root_dir, *yymmdd)
 	case "migrate":
@@ -61,30 +141,58 @@ func main() {
 	}
 }
 
-func collect(c *collector.Collector) {
-	lines, _ := funcs.GetConfig(*root_dir + "/config")
-	id := lines[0]
-	pass := lines[1]
-	sid := lines[2]
-	jsess := lines[3]
+func collect(c *collector.Collector, cfg collector.Config) {
+	store, err := cfg.OpenSecrets()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	for _, symbol := range lines[4:] {
-		if symbol == "" {
-			continue
-		}
-		err := c.Collect(symbol)
+	for _, s := range cfg.Symbols {
+		err := c.Collect(s.Symbol)
 		if err != nil {
 			fmt.Println(err)
 		}
 	}
 
-	tda := tdameritrade.New(id, pass, sid, jsess)
-	if tda.JsessionID != jsess {
-		lines[3] = tda.JsessionID
-		funcs.UpdateConfig(*root_dir+"/config", lines)
+	var adapter interfaces.Adapter
+	switch cfg.Adapter {
+	case "tdameritrade":
+		tda, err := tdameritrade.NewFromSecrets(store)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		adapter = tda
+	case "simulate":
+		sim, err := simulate.NewFromSecrets(store, 0)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		adapter = sim
 	}
 
-	c.Adapter = tda
+	c.Adapter = adapter
 
 	c.RunOnce()
 }
+
+func stream(c *collector.Collector, cfg collector.Config) {
+	if cfg.Feed == "" {
+		fmt.Printf("'stream' requires 'feed' in %s.\n", collector.CONFIG)
+		os.Exit(1)
+	}
+	for _, s := range cfg.Symbols {
+		err := c.Collect(s.Symbol)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	err := c.RunStream(feed.New(cfg.Feed))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -4,13 +4,20 @@ import (
 	"github.com/eliwjones/thebox/adapter/simulate"
 	"github.com/eliwjones/thebox/collector"
 	"github.com/eliwjones/thebox/destiny"
+	"github.com/eliwjones/thebox/optimize"
 	"github.com/eliwjones/thebox/pulsar"
 	"github.com/eliwjones/thebox/trader"
 	"github.com/eliwjones/thebox/util/funcs"
 
+	"errors"
+	"flag"
 	"fmt"
+	"os"
 	"runtime"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
@@ -19,13 +26,48 @@ var (
 	startTS       = ""
 	stopTS        = ""
 	loops         = 500
-	underlying    = "AAPL"
-	weeksBack     = 8
-	multiplier    = 1.0
+	underlyings   = []destiny.Underlying{}
+	strategy      = "edges"
+	params        = destiny.Params{}
 	realTime      = false
+	exits         = trader.AnyExit{}
+	risk          = trader.RiskLimits{}
 )
 
 func main() {
+	flag.StringVar(&strategy, "strategy", strategy, "Destiny strategy: "+strings.Join(destiny.Strategies(), ", "))
+	paramString := flag.String("params", "", "Strategy params, e.g. weeks_back=8,multiplier=1.5.  Unset params use strategy defaults.")
+	underlyingString := flag.String("underlyings", "AAPL", "Underlyings sharing one trader, with optional params over -params, e.g. AAPL;GOOG:weeks_back=4,max_per_week=10")
+	seed := flag.Uint64("seed", 0, "Seeds every run.  Runs are named <underlying>_<weeks_back>_<multiplier>_<time>_<run seed>, and -seed=<run seed> -loops=1 replays one of them.  0 for a random seed.")
+	flag.IntVar(&loops, "loops", loops, "How many runs.")
+	exitString := flag.String("exits", "secretary_backoff", "Trader exit policies, first to fire closes, e.g. secretary;target:multiple=3;stoploss:pct=0.5.  Choose from: "+strings.Join(trader.ExitPolicies(), ", "))
+	riskString := flag.String("risk", "", "Trader risk limits in cents, e.g. max_open=10,max_underlying=500000,max_expiration=1000000,max_daily_loss=100000,max_weekly_loss=300000,max_orders=5/3600,duplicate_symbol=1")
+	grid := flag.String("walkforward", "", "Walk-forward over every combination of params, e.g. weeks_back=4|8,multiplier=1.0|1.5.  Each run of -loops is one week.")
+	inSample := flag.Int("insample", 4, "Weeks of trailing in-sample window for -walkforward.")
+	flag.Parse()
+
+	parsed, err := destiny.ParseParams(*paramString)
+	if err == nil {
+		params, err = destiny.StrategyParams(strategy, parsed)
+	}
+	if err == nil {
+		underlyings, err = destiny.ParseUnderlyings(*underlyingString, params)
+	}
+	if err == nil {
+		exits, err = trader.ParseExits(*exitString)
+	}
+	if err == nil {
+		risk, err = trader.ParseRiskLimits(*riskString)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *seed == 0 {
+		*seed = funcs.RandomSeed()
+	}
+	fmt.Printf("Strategy: %s, Params: %s, Exits: %s, Seed: %d\n", strategy, params, exits.Name(), *seed)
+
 	runtime.GOMAXPROCS(6)
 
 	traderChannel := make(chan *trader.Trader, 1000)
@@ -50,14 +92,40 @@ func main() {
 	startTS = "1422288000"
 	stopTS = "1425311400"
 
+	// Don't bother backtesting against data we can't trust.
+	problems, err := c.Verify()
+	if errors.Is(err, collector.ErrNoManifest) {
+		fmt.Printf("%s has no %s, running unverified.  '-action rebuild_manifest' in collectord vouches for it.\n", collectorRoot, collector.MANIFEST)
+	} else if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%s failed verification:\n\t%s\n", collectorRoot, strings.Join(problems, "\n\t"))
+		return
+	}
+
+	if *grid != "" {
+		walkForward(*grid, *inSample, *underlyingString, *seed)
+		return
+	}
+
+	// Run seeds are drawn up front so they do not depend on goroutine scheduling.
+	// First run seed is the given seed so -loops=1 replays a single run exactly.
+	seeds := []uint64{*seed}
+	r := funcs.NewRand(*seed)
+	for len(seeds) < loops {
+		seeds = append(seeds, r.Uint64())
+	}
+
 	// Cheat to initialize edge data.
-	t := runOnce()
+	t := runOnce(params, underlyings, startTS, stopTS, seeds[0])
 	weekCount := t.WeekCount
 	traderChannel <- t
 
-	for i := 0; i < loops-1; i++ {
+	for _, runSeed := range seeds[1:] {
 		go func() {
-			t := runOnce()
+			t := runOnce(params, underlyings, startTS, stopTS, runSeed)
 			traderChannel <- t
 		}()
 	}
@@ -99,7 +167,7 @@ func main() {
 		max, min, med, avg = getDistribution(h.PositionReturns)
 		fmt.Printf("\tMax: %.2f, Min: %.2f, Med: %.2f, Avg: %.2f\n", max, min, med, avg)
 	}
-	fmt.Printf("Underlying: %s, WeekCount: %d, TotalPositions:%d\n", underlying, weekCount, totalPositions)
+	fmt.Printf("Underlyings: %s, Strategy: %s, Params: %s, WeekCount: %d, TotalPositions:%d\n", *underlyingString, strategy, params, weekCount, totalPositions)
 
 	max, min, med, avg = getDistribution(returns)
 	fmt.Printf("Returns\nMax: %.2f, Min: %.2f, Med: %.2f, Avg: %.2f\n", max, min, med, avg)
@@ -131,14 +199,29 @@ func getDistribution(slice []float64) (max float64, min float64, med float64, av
 	return max, min, med, avg
 }
 
-func runOnce() *trader.Trader {
+func runOnce(params destiny.Params, underlyings []destiny.Underlying, startTS string, stopTS string, seed uint64) *trader.Trader {
 	p := pulsar.New(collectorRoot+"/live/timestamp", startTS, stopTS, true)
 
-	id := funcs.ID(underlying, weeksBack, multiplier, realTime)
+	// Forks must stay in this order for the seed to replay.
+	r := funcs.NewRand(seed)
+	symbols := []string{}
+	for _, u := range underlyings {
+		symbols = append(symbols, u.Symbol)
+	}
+	id := funcs.ID(strings.Join(symbols, "-"), params.Int("weeks_back"), params.Float("multiplier"), realTime, seed)
 	a := simulate.New("simulate", "simulation", 300000*100)
+	a.Rand = r.Fork()
 	t := trader.New(id, "testDir", a, c)
+	t.Exits = exits
+	t.Risk = risk
+	t.Schedule = trader.PulseSchedule(p.Pulses())
 
-	d := destiny.New(id, "testDir", underlying, weeksBack, multiplier, c, t.PoIn)
+	d, err := destiny.New(id, "testDir", strategy, underlyings, r.Fork(), c, t.PoIn)
+	if err != nil {
+		panic(err)
+	}
+	d.WatchPositions(a)
+	t.Money.Rand = r.Fork()
 
 	p.Subscribe("destiny", d.Pulses, d.PulsarReply)
 	p.Subscribe("trader", t.Pulses, t.PulsarReply)
@@ -147,6 +230,72 @@ func runOnce() *trader.Trader {
 	return t
 }
 
+// Picks params each week from the grid on the trailing inSample weeks and reports how they did the week after.
+func walkForward(grid string, inSample int, underlyingString string, seed uint64) {
+	candidates, err := optimize.ParseGrid(grid)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// -params fills in whatever the grid doesn't vary.
+	for idx := range candidates {
+		for k, v := range params {
+			if _, exists := candidates[idx][k]; !exists {
+				candidates[idx][k] = v
+			}
+		}
+		candidates[idx], err = destiny.StrategyParams(strategy, candidates[idx])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	// Evaluations happen in a fixed order, so drawing run seeds as they go still replays.
+	r := funcs.NewRand(seed)
+	evaluate := func(candidate destiny.Params, weekID int64) (float64, error) {
+		us, err := destiny.ParseUnderlyings(underlyingString, candidate)
+		if err != nil {
+			return 0, err
+		}
+		start, stop := fmt.Sprintf("%d", weekID), fmt.Sprintf("%d", weekID+7*24*60*60-1)
+		seeds := []uint64{}
+		for range loops {
+			seeds = append(seeds, r.Uint64())
+		}
+
+		// First run alone to initialize edge data.
+		traderChannel := make(chan *trader.Trader, len(seeds))
+		traderChannel <- runOnce(candidate, us, start, stop, seeds[0])
+		for _, runSeed := range seeds[1:] {
+			go func() {
+				traderChannel <- runOnce(candidate, us, start, stop, runSeed)
+			}()
+		}
+		total := 0.0
+		for range seeds {
+			t := <-traderChannel
+			t.FinalizeHistorae(300000 * 100)
+			total += t.Historae.Return / 100
+		}
+		return total / float64(len(seeds)), nil
+	}
+
+	start, _ := strconv.ParseInt(startTS, 10, 64)
+	end, _ := strconv.ParseInt(stopTS, 10, 64)
+	result, err := optimize.WalkForward(optimize.Config{Candidates: candidates, InSample: inSample, Start: start, End: end}, evaluate)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Walk-forward, Underlyings: %s, Strategy: %s, InSample: %d weeks, Candidates: %d, Seed: %d\n", underlyingString, strategy, inSample, len(candidates), seed)
+	for _, week := range result.Weeks {
+		fmt.Printf("%s InSample: %.4f, Return: %.4f, Equity: %.4f, Params: %s\n",
+			time.Unix(week.WeekID, 0).UTC().Format("20060102"), week.InSample, week.Return, week.Equity, week.Params)
+	}
+	fmt.Printf("Equity: %.4f\n", result.Equity)
+}
+
 func printFloats(floats []float64) {
 	fmt.Printf("[")
 
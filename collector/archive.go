@@ -0,0 +1,321 @@
+package collector
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Days in log/ and live/quotes are text files named yyyymmdd.  Once they are older than the
+// retention window they get zipped into <dir>/archive/<yyyymm>.zip and removed.
+// <dir>/archive/index.json maps yyyymmdd to its zip so readDay() can find it.
+
+const (
+	ARCHIVE_DIR   = "archive"
+	ARCHIVE_INDEX = "index.json"
+)
+
+type archiveEntry struct {
+	Archive string `json:"archive"` // yyyymm.zip
+	Bytes   int64  `json:"bytes"`   // Uncompressed size.
+}
+
+// Zip up days older than policy.ArchiveAfter and prune archives older than the prune policy.
+// Keeps going on errors and returns all of them.
+func (c *Collector) Archive(today time.Time, policy RetentionConfig) error {
+	errs := []error{}
+
+	dirs := []struct {
+		dir        string
+		pruneAfter int
+	}{
+		{c.logdir, policy.LogPruneAfter},
+		{c.livedir + "/quotes", policy.QuotePruneAfter},
+	}
+	for _, d := range dirs {
+		archiveBefore := today.AddDate(0, 0, -policy.ArchiveAfter).Format("20060102")
+		archived, err := archiveDays(d.dir, archiveBefore)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if len(archived) > 0 {
+			fmt.Printf("[Archive] %s: archived %d days (%s - %s)\n", d.dir, len(archived), archived[0], archived[len(archived)-1])
+		}
+
+		if d.pruneAfter == 0 {
+			continue
+		}
+		pruneBefore := today.AddDate(0, 0, -d.pruneAfter).Format("20060102")
+		pruned, days, err := pruneArchives(d.dir, pruneBefore)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if len(pruned) > 0 {
+			fmt.Printf("[Archive] %s: pruned %s\n", d.dir, strings.Join(pruned, ", "))
+		}
+		// Retention let them go, so Verify() shouldn't miss them.
+		if d.dir == c.livedir+"/quotes" {
+			for _, day := range days {
+				c.markDirty("quotes", day)
+			}
+		}
+	}
+	c.flushManifest()
+
+	for _, err := range errs {
+		c.logError("Archive", err)
+	}
+	return errors.Join(errs...)
+}
+
+// Archives every yyyymmdd file in dir before 'before'.  Returns archived days, sorted.
+func archiveDays(dir string, before string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	months := map[string][]string{}
+	for _, entry := range entries {
+		day := entry.Name()
+		if entry.IsDir() || !isDay(day) || day >= before {
+			continue
+		}
+		months[day[:6]] = append(months[day[:6]], day)
+	}
+	if len(months) == 0 {
+		return nil, nil
+	}
+
+	index, err := loadArchiveIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	archived := []string{}
+	for _, month := range sortedKeys(months) {
+		days := months[month]
+		sort.Strings(days)
+		sizes, err := appendToArchive(dir, month, days)
+		if err != nil {
+			return archived, err
+		}
+		for _, day := range days {
+			index[day] = archiveEntry{Archive: month + ".zip", Bytes: sizes[day]}
+		}
+		// Index must point at the zip before raw files go away so readers always find the day.
+		err = saveArchiveIndex(dir, index)
+		if err != nil {
+			return archived, err
+		}
+		for _, day := range days {
+			err = os.Remove(dir + "/" + day)
+			if err != nil {
+				return archived, err
+			}
+		}
+		archived = append(archived, days...)
+	}
+
+	return archived, nil
+}
+
+// Rewrites <dir>/archive/<month>.zip with its existing days plus the new ones.
+// Written to a temp file and renamed so a crash never leaves a half written zip.
+func appendToArchive(dir string, month string, days []string) (map[string]int64, error) {
+	archiveDir := dir + "/" + ARCHIVE_DIR
+	err := os.MkdirAll(archiveDir, 0777)
+	if err != nil {
+		return nil, err
+	}
+	path := archiveDir + "/" + month + ".zip"
+	tmp, err := os.CreateTemp(archiveDir, month+".zip.*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := zip.NewWriter(tmp)
+	adding := map[string]bool{}
+	for _, day := range days {
+		adding[day] = true
+	}
+
+	// Keep whatever is already archived, unless we have a fresh copy.
+	r, err := zip.OpenReader(path)
+	if err == nil {
+		for _, f := range r.File {
+			if adding[f.Name] {
+				continue
+			}
+			err = w.Copy(f)
+			if err != nil {
+				r.Close()
+				return nil, err
+			}
+		}
+		r.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	sizes := map[string]int64{}
+	for _, day := range days {
+		data, err := os.ReadFile(dir + "/" + day)
+		if err != nil {
+			return nil, err
+		}
+		f, err := w.CreateHeader(&zip.FileHeader{Name: day, Method: zip.Deflate})
+		if err != nil {
+			return nil, err
+		}
+		_, err = f.Write(data)
+		if err != nil {
+			return nil, err
+		}
+		sizes[day] = int64(len(data))
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		return nil, err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return nil, err
+	}
+	return sizes, os.Rename(tmp.Name(), path)
+}
+
+func isDay(name string) bool {
+	if len(name) != len("20060102") {
+		return false
+	}
+	_, err := time.Parse("20060102", name)
+	return err == nil
+}
+
+func loadArchiveIndex(dir string) (map[string]archiveEntry, error) {
+	index := map[string]archiveEntry{}
+	data, err := os.ReadFile(dir + "/" + ARCHIVE_DIR + "/" + ARCHIVE_INDEX)
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return index, err
+	}
+	err = json.Unmarshal(data, &index)
+	return index, err
+}
+
+// Removes whole months once every day in them is before 'before'.  Returns pruned archives and the days that were in them.
+func pruneArchives(dir string, before string) ([]string, []string, error) {
+	index, err := loadArchiveIndex(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Last day of each month decides whether the whole month goes.
+	lastDay := map[string]string{}
+	for day, entry := range index {
+		if day > lastDay[entry.Archive] {
+			lastDay[entry.Archive] = day
+		}
+	}
+	pruned := []string{}
+	for _, archive := range sortedKeys(lastDay) {
+		month, _ := time.Parse("200601", strings.TrimSuffix(archive, ".zip"))
+		if month.AddDate(0, 1, -1).Format("20060102") >= before {
+			continue
+		}
+		pruned = append(pruned, archive)
+	}
+	if len(pruned) == 0 {
+		return nil, nil, nil
+	}
+
+	// Drop from index first so nothing goes looking for a zip that is gone.
+	days := []string{}
+	for _, day := range sortedKeys(index) {
+		for _, archive := range pruned {
+			if index[day].Archive == archive {
+				delete(index, day)
+				days = append(days, day)
+			}
+		}
+	}
+	err = saveArchiveIndex(dir, index)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, archive := range pruned {
+		err = os.Remove(dir + "/" + ARCHIVE_DIR + "/" + archive)
+		if err != nil {
+			return pruned, days, err
+		}
+	}
+	return pruned, days, nil
+}
+
+// Reads dir/yyyymmdd, falling back to the archive if it has been archived.
+func readDay(dir string, yyyymmdd string) ([]byte, error) {
+	data, err := os.ReadFile(dir + "/" + yyyymmdd)
+	if !errors.Is(err, os.ErrNotExist) {
+		return data, err
+	}
+	notFound := err
+
+	index, err := loadArchiveIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	entry, exists := index[yyyymmdd]
+	if !exists {
+		return nil, notFound
+	}
+
+	r, err := zip.OpenReader(dir + "/" + ARCHIVE_DIR + "/" + entry.Archive)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	f, err := r.Open(yyyymmdd)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", entry.Archive, err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func saveArchiveIndex(dir string, index map[string]archiveEntry) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return funcs.AtomicWriteFile(dir+"/"+ARCHIVE_DIR, ARCHIVE_INDEX, data)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
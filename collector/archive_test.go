@@ -0,0 +1,125 @@
+package collector
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_Collector_Archive(t *testing.T) {
+	days := []string{"20141230", "20141231", "20150129", "20150130", "20150202"}
+	symbols := []string{"AAPL", "GOOG"}
+
+	c := New("test", t.TempDir(), int64(60))
+	writeTestLogs(c.logdir, days, symbols)
+	c.ProcessStream(days[0], days[4], false)
+	expected := map[string][]byte{}
+	for _, day := range days {
+		expected["log/"+day], _ = os.ReadFile(c.logdir + "/" + day)
+		expected["quotes/"+day], _ = os.ReadFile(c.livedir + "/quotes/" + day)
+	}
+
+	// Everything but the last day gets archived.
+	today, _ := time.Parse("20060102", "20150216")
+	err := c.Archive(today, RetentionConfig{ArchiveAfter: 14})
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	for _, dir := range []string{c.logdir, c.livedir + "/quotes"} {
+		for _, day := range days[:4] {
+			if _, err := os.Stat(dir + "/" + day); err == nil {
+				t.Errorf("Expected %s/%s to have been archived.", dir, day)
+			}
+		}
+		if _, err := os.Stat(dir + "/" + days[4]); err != nil {
+			t.Errorf("Expected %s/%s to be left alone. Err: %s", dir, days[4], err)
+		}
+		for _, archive := range []string{"201412.zip", "201501.zip"} {
+			if _, err := os.Stat(dir + "/" + ARCHIVE_DIR + "/" + archive); err != nil {
+				t.Errorf("Expected archive. Err: %s", err)
+			}
+		}
+	}
+
+	// Archived days read back the same.
+	for _, day := range days {
+		got, err := readDay(c.logdir, day)
+		if err != nil || !bytes.Equal(got, expected["log/"+day]) {
+			t.Errorf("Expected archived log for %s to match. Err: %v", day, err)
+		}
+		got, err = readDay(c.livedir+"/quotes", day)
+		if err != nil || !bytes.Equal(got, expected["quotes/"+day]) {
+			t.Errorf("Expected archived quotes for %s to match. Err: %v", day, err)
+		}
+	}
+	_, err = readDay(c.logdir, "20150203")
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected not exist err, Got: %v", err)
+	}
+
+	// Archiving again later adds to the month that is already there.
+	today, _ = time.Parse("20060102", "20150301")
+	c.Archive(today, RetentionConfig{ArchiveAfter: 14})
+	index, _ := loadArchiveIndex(c.logdir)
+	if len(index) != 5 || index["20150202"].Archive != "201502.zip" || index["20150129"].Bytes != int64(len(expected["log/20150129"])) {
+		t.Errorf("Unexpected index: %+v", index)
+	}
+
+	// ProcessStream reads straight out of the archive.
+	replay := New("test", t.TempDir(), int64(60))
+	replay.logdir = c.logdir
+	replay.ProcessStream(days[0], days[4], false)
+	for _, day := range days {
+		got, _ := os.ReadFile(replay.livedir + "/quotes/" + day)
+		if !bytes.Equal(got, expected["quotes/"+day]) {
+			t.Errorf("Expected same quotes from archived log for %s.", day)
+		}
+	}
+
+	// getQuotes too.
+	ts, _ := time.Parse("20060102 15:04", "20150129 15:00")
+	quotes, err := c.getQuotes(ts.Unix())
+	if err != nil || len(quotes) != 4 {
+		t.Errorf("Expected 4 quotes from archive, Got: %d, Err: %v", len(quotes), err)
+	}
+
+	// Prune whole months once they are entirely past policy.
+	err = c.Archive(today, RetentionConfig{ArchiveAfter: 14, LogPruneAfter: 40})
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	index, _ = loadArchiveIndex(c.logdir)
+	if _, err := os.Stat(c.logdir + "/" + ARCHIVE_DIR + "/201412.zip"); err == nil {
+		t.Errorf("Expected 201412.zip to have been pruned.")
+	}
+	if len(index) != 3 {
+		t.Errorf("Expected 3 days left in index, Got: %+v", index)
+	}
+	quoteIndex, _ := loadArchiveIndex(c.livedir + "/quotes")
+	if len(quoteIndex) != 5 {
+		t.Errorf("Expected quotes to be kept forever, Got: %+v", quoteIndex)
+	}
+}
+
+func Test_pruneArchives(t *testing.T) {
+	dir := t.TempDir()
+	for _, day := range []string{"20150130", "20150202", "20150227"} {
+		os.WriteFile(dir+"/"+day, []byte(day), 0777)
+	}
+	archived, err := archiveDays(dir, "20150301")
+	if err != nil || len(archived) != 3 {
+		t.Errorf("Expected 3 archived days, Got: %v, Err: %v", archived, err)
+	}
+
+	// February is not done until the 28th is past.
+	pruned, days, _ := pruneArchives(dir, "20150228")
+	if !reflect.DeepEqual(pruned, []string{"201501.zip"}) || !reflect.DeepEqual(days, []string{"20150130"}) {
+		t.Errorf("Expected: [201501.zip] [20150130], Got: %v %v", pruned, days)
+	}
+	pruned, days, _ = pruneArchives(dir, "20150301")
+	if !reflect.DeepEqual(pruned, []string{"201502.zip"}) || !reflect.DeepEqual(days, []string{"20150202", "20150227"}) {
+		t.Errorf("Expected: [201502.zip] [20150202 20150227], Got: %v %v", pruned, days)
+	}
+}
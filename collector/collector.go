@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"github.com/eliwjones/thebox/util"
 	"github.com/eliwjones/thebox/util/funcs"
 	"github.com/eliwjones/thebox/util/interfaces"
 	"github.com/eliwjones/thebox/util/structs"
@@ -19,6 +20,7 @@ import (
 
 type Collector struct {
 	Reckless bool
+	Workers  int // ProcessStream() shards log lines by underlying across this many goroutines.
 
 	id        string
 	livedir   string
@@ -29,6 +31,7 @@ type Collector struct {
 	pipe      chan structs.Message
 	replies   chan any
 	rootdir   string
+	rules     map[string]SymbolConfig // Per symbol expiration rules from Config.
 	symbols   []string
 	targets   map[string]map[string]target // "current", "next" for each SYMBOL.
 	timestamp string
@@ -38,8 +41,14 @@ type Collector struct {
 	// But, in time crunch, and can make sexy when have nothing better to do.  Trading is more important.
 	lazyLoadChannel chan lazyLoadMessage                 // Requests for quotes controlled by this channel.
 	quote           map[int64]map[string]structs.Option  // For holding individual timestamped quotes. (Trader likes this)
+	stock           map[int64]map[string]structs.Stock   // Underlying quotes loaded alongside quote.  (Destiny likes this)
 	maximum         map[int64]map[string]structs.Maximum // Holds maximums for calculating regret versus MaxBid.
 	index           map[int64]map[string][]string        // Maps timestamp, underlying to slice of quote symbols.
+
+	// Set when running as a ProcessStream() shard so writes can be merged in order.
+	deferred *shardWrites
+
+	dirty map[string]bool // live/ files written since last flushManifest().
 }
 
 // Ugly feeling first pass.
@@ -49,6 +58,25 @@ type lazyLoadMessage struct {
 	reply     chan error // Signal when done.
 }
 
+// State of ProcessStream() after the last fully processed day.
+type checkpoint struct {
+	Day       string                                  // Last yyyymmdd processed.
+	Timestamp int64                                   // Last log timestamp seen.
+	Targets   map[string]map[string]target            // Should be empty since targets reset daily.
+	Maximums  map[string]map[string][]structs.Maximum // Same as dumpMaximums().
+}
+
+// Consecutive log lines sharing a timestamp.
+type logGroup struct {
+	timestamp int64
+	lines     []logLine
+}
+
+type logLine struct {
+	_type         string
+	encodedEquity string
+}
+
 type target struct {
 	Timestamp int64 // Seconds since epoch target (10 min increments)
 	Stock     structs.Stock
@@ -84,9 +112,11 @@ func New(id string, rootdir string, period int64) *Collector {
 	c.pipe = make(chan structs.Message, 10000)
 	c.lazyLoadChannel = make(chan lazyLoadMessage, 100)
 	c.quote = map[int64]map[string]structs.Option{}
+	c.stock = map[int64]map[string]structs.Stock{}
 	c.maximum = map[int64]map[string]structs.Maximum{}
 	c.index = map[int64]map[string][]string{}
 	c.replies = make(chan any, 1000)
+	c.rules = map[string]SymbolConfig{}
 	c.symbols = []string{}
 
 	c.maximums = map[string]map[string][]structs.Maximum{}
@@ -128,6 +158,19 @@ func New(id string, rootdir string, period int64) *Collector {
 	return c
 }
 
+func NewFromConfig(id string, cfg Config) *Collector {
+	c := New(id, cfg.RootDir, cfg.Interval)
+
+	c.logdir = cfg.Paths.Log
+	c.livedir = cfg.Paths.Live
+	c.errordir = cfg.Paths.Error
+	for _, rule := range cfg.Symbols {
+		c.rules[rule.Symbol] = rule
+	}
+
+	return c
+}
+
 func (c *Collector) RunOnce() {
 	// Must panic if RunOnce() takes longer than 50 seconds?
 	// Longer than cron period will result in out-of-order log entries.
@@ -183,9 +226,10 @@ func (c *Collector) RunOnce() {
 	// Serialize to disk.
 	c.dumpTargets()
 	c.dumpMaximums()
+	c.flushManifest()
 }
 
-func (c *Collector) ProcessStream(start string, end string) {
+func (c *Collector) ProcessStream(start string, end string, resume bool) {
 	sorted_days := []string{}
 	current := start
 	t, err := time.Parse("20060102", current)
@@ -209,40 +253,119 @@ func (c *Collector) ProcessStream(start string, end string) {
 	}
 
 	currentTimestamp := int64(-1)
+	lastDay := ""
+	if resume {
+		cp, err := c.loadCheckpoint()
+		if err != nil {
+			c.logError("ProcessStream", err)
+		} else {
+			c.targets = cp.Targets
+			c.maximums = cp.Maximums
+			currentTimestamp = cp.Timestamp
+			lastDay = cp.Day
+		}
+	}
+
 	for _, yyyymmdd := range sorted_days {
+		if yyyymmdd <= lastDay {
+			fmt.Printf("[%s] Checkpointed, skipping.\n", yyyymmdd)
+			continue
+		}
 		fmt.Println("******************************" + yyyymmdd + "*******************************")
-		log_data, err := os.ReadFile(c.logdir + "/" + yyyymmdd)
+		log_data, err := readDay(c.logdir, yyyymmdd)
 		if err != nil {
 			fmt.Println(err)
 			continue
 		}
 
-		lines := bytes.Split(log_data, []byte("\n"))
-		for _, line := range lines {
-			logTimestamp, _type, encodedEquity := c.parseLogLine(yyyymmdd, string(line))
-			if logTimestamp != currentTimestamp && currentTimestamp != -1 {
-				c.maybeCycleTargets(currentTimestamp)
-				c.maybeCycleMaximums(currentTimestamp)
+		// Quotes for the day are rebuilt from the log, so toss any left over from a previous run.
+		os.Remove(c.livedir + "/quotes/" + yyyymmdd)
+		c.markDirty("quotes", yyyymmdd)
 
-				fmt.Printf("[%s][current_timestamp] %d\n", yyyymmdd, currentTimestamp)
-			}
-			o, err := c.updateTarget(logTimestamp, _type, encodedEquity)
-			if err == nil {
-				c.updateMaximum(o, logTimestamp)
-			}
-			currentTimestamp = logTimestamp
+		groups := c.groupLogLines(yyyymmdd, bytes.Split(log_data, []byte("\n")))
+		if c.Workers > 1 {
+			currentTimestamp = c.processShards(yyyymmdd, groups, currentTimestamp)
+		} else {
+			currentTimestamp = c.processGroups(yyyymmdd, groups, currentTimestamp)
 		}
-		// Just in case fate is frowning on us.  (This should be almost always unnecessary.)
-		c.maybeCycleTargets(currentTimestamp)
-		c.maybeCycleMaximums(currentTimestamp)
 
 		// Reset targets.. since they don't carry over into new days.
 		c.targets["current"] = map[string]target{}
 		c.targets["next"] = map[string]target{}
+
+		// Checkpoint so a crash only costs us the day in progress.
+		c.flushManifest()
+		c.dumpCheckpoint(checkpoint{Day: yyyymmdd, Timestamp: currentTimestamp, Targets: c.targets, Maximums: c.maximums})
+	}
+	c.dumpTargets()
+	c.dumpMaximums()
+	c.flushManifest()
+
+}
+
+// Like RunOnce(), but for a Streamer pushing quotes as they change instead of polling once a period.
+// Message timestamps drive cycling the same way log timestamps do in ProcessStream(),
+// so each interval ends up with whichever quote was closest to it.  Returns once stream is done.
+func (c *Collector) RunStream(stream interfaces.Streamer) error {
+	c.targets = c.loadTargets()
+	c.maximums = c.loadMaximums()
+
+	err := stream.Stream(c.symbols, c.pipe)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	currentTimestamp := int64(-1)
+	lastDump := time.Now().UTC().Unix()
+	for message := range c.pipe {
+		// nil Data implies stream is done.
+		if message.Data == nil {
+			break
+		}
+		timestamp := message.Timestamp
+		if timestamp == 0 {
+			timestamp = time.Now().UTC().Unix()
+		}
+		// Out of order messages are treated as arriving now.
+		timestamp = max(timestamp, currentTimestamp)
+		if timestamp != currentTimestamp && currentTimestamp != -1 {
+			c.maybeCycleTargets(currentTimestamp)
+			c.maybeCycleMaximums(currentTimestamp)
+
+			// Reset targets.. since they don't carry over into new days.  Same as ProcessStream() between logs.
+			if timestamp/int64(24*60*60) != currentTimestamp/int64(24*60*60) {
+				c.targets["current"] = map[string]target{}
+				c.targets["next"] = map[string]target{}
+			}
+		}
+
+		yyyymmdd, line := c.saveToLog(message.Data, timestamp)
+		logTimestamp, _type, encodedEquity := c.parseLogLine(yyyymmdd, line)
+		o, err := c.updateTarget(logTimestamp, _type, encodedEquity)
+		if err == nil {
+			c.updateMaximum(o, logTimestamp)
+		}
+		currentTimestamp = timestamp
+
+		// Don't lose the whole day if we get killed.
+		if time.Now().UTC().Unix()-lastDump >= c.period {
+			c.dumpTargets()
+			c.dumpMaximums()
+			c.flushManifest()
+			lastDump = time.Now().UTC().Unix()
+		}
+	}
+	if currentTimestamp != -1 {
+		c.maybeCycleTargets(currentTimestamp)
+		c.maybeCycleMaximums(currentTimestamp)
 	}
+
 	c.dumpTargets()
 	c.dumpMaximums()
+	c.flushManifest()
 
+	return nil
 }
 
 func (c *Collector) addMaximum(o structs.Option, s structs.Stock, timestamp int64) error {
@@ -288,6 +411,9 @@ func (c *Collector) addMaximum(o structs.Option, s structs.Stock, timestamp int6
 	m.UnderlyingBid = s.Bid
 	m.Volume = o.Volume
 	m.MaxTimestamp = timestamp
+	m.MinimumBid = o.Bid
+	m.MinTimestamp = timestamp
+	m.ExpirationBid = o.Bid
 
 	_, expExists := c.maximums[o.Expiration]
 	if !expExists {
@@ -303,15 +429,20 @@ func (c *Collector) addMaximum(o structs.Option, s structs.Stock, timestamp int6
 }
 
 func (c *Collector) collect(symbol string) (string, string) {
+	rule, exists := c.rules[symbol]
+	if !exists {
+		rule = SymbolConfig{Symbol: symbol, MaxDays: DEFAULT_MAX_DAYS}
+	}
 	thisMonth := time.Now().Format("200601")
-	limit := time.Now().AddDate(0, 0, 22)
+	limit := time.Now().AddDate(0, 0, rule.MaxDays)
 	limitMonth := limit.Format("200601")
+	earliest := time.Now().AddDate(0, 0, rule.MinDays).Format("20060102")
 
 	options, stock, err := c.Adapter.GetOptions(symbol, thisMonth)
 
 	// Isn't technically safe to write here.. but.. I can stand to lose one error in a race.
 	if err != nil && limitMonth == thisMonth {
-		c.logError("collect ("+thisMonth+") - "+symbol, err)
+		c.logSymbolError("collect", symbol, fmt.Errorf("%s: %w", thisMonth, err))
 		fmt.Println(err)
 		c.replies <- false
 		return thisMonth, limitMonth
@@ -319,7 +450,7 @@ func (c *Collector) collect(symbol string) (string, string) {
 	if limitMonth != thisMonth {
 		optionsNextMonth, _, err := c.Adapter.GetOptions(symbol, limitMonth)
 		if err != nil {
-			c.logError("collect ("+limitMonth+") - "+symbol, err)
+			c.logSymbolError("collect", symbol, fmt.Errorf("%s: %w", limitMonth, err))
 			c.replies <- false
 			return thisMonth, limitMonth
 		}
@@ -333,6 +464,9 @@ func (c *Collector) collect(symbol string) (string, string) {
 		if option.Expiration > limit.Format("20060102") {
 			continue
 		}
+		if rule.MinDays > 0 && option.Expiration < earliest {
+			continue
+		}
 		m := structs.Message{Data: option}
 		c.pipe <- m
 	}
@@ -373,11 +507,17 @@ func (c *Collector) DeserializeMaximums(maximums string) ([]structs.Maximum, err
 	var e error
 	Maximums := []structs.Maximum{}
 	for _, maximum := range strings.Split(maximums, "\n") {
-		if maximum == "" {
+		// "#" lines are comments, e.g. destiny's chosen_edges header.
+		if maximum == "" || strings.HasPrefix(maximum, "#") {
 			continue
 		}
+		// Maximums written before drawdown tracking have fewer columns.
+		encodingOrder := funcs.MaximumEncodingOrder
+		if strings.Count(maximum, ",")+1 == len(funcs.MaximumLegacyEncodingOrder) {
+			encodingOrder = funcs.MaximumLegacyEncodingOrder
+		}
 		m := structs.Maximum{}
-		err := funcs.Decode(maximum, &m, funcs.MaximumEncodingOrder)
+		err := funcs.Decode(maximum, &m, encodingOrder)
 		if err != nil {
 			fmt.Printf("Maximum: %s, Err: %s\n", maximum, err)
 			e = err
@@ -388,6 +528,18 @@ func (c *Collector) DeserializeMaximums(maximums string) ([]structs.Maximum, err
 	return Maximums, e
 }
 
+func (c *Collector) dumpCheckpoint(cp checkpoint) {
+	d, err := json.Marshal(cp)
+	if err != nil {
+		c.logError("dumpCheckpoint", err)
+		return
+	}
+	err = funcs.AtomicWriteFile(c.livedir+"/checkpoints", c.id, d)
+	if err != nil {
+		c.logError("dumpCheckpoint", err)
+	}
+}
+
 func (c *Collector) dumpMaximums() {
 	// Not sure of least dumb way to structure data for Marshal, Unmarshal..
 	// Expirementing with marshing all directly to current sub-dir with collector.id as filename.
@@ -401,7 +553,7 @@ func (c *Collector) dumpMaximums() {
 	}
 	path := c.livedir + "/maximums/current"
 	filename := c.id
-	err = funcs.LazyWriteFile(path, filename, d)
+	err = funcs.AtomicWriteFile(path, filename, d)
 	if err != nil {
 		c.logError("dumpMaximums", err)
 	}
@@ -417,7 +569,7 @@ func (c *Collector) dumpTargets() {
 			}
 			path := c.livedir + "/targets/" + _type
 			filename := symbol
-			err = funcs.LazyWriteFile(path, filename, d)
+			err = funcs.AtomicWriteFile(path, filename, d)
 			if err != nil {
 				c.logError("dumpTargets", err)
 			}
@@ -425,6 +577,23 @@ func (c *Collector) dumpTargets() {
 	}
 }
 
+func (c *Collector) encodeLogLine(hhmmss string, message any) string {
+	line := hhmmss
+
+	switch message := message.(type) {
+	case structs.Stock:
+		es, _ := funcs.Encode(&message, funcs.StockEncodingOrder)
+		line += ",s," + es
+	case structs.Option:
+		eo, _ := funcs.Encode(&message, funcs.OptionEncodingOrder)
+		line += ",o," + eo
+	default:
+		panic("SaveToLog switching wrong!")
+	}
+
+	return line
+}
+
 func (c *Collector) GetMaximum(utcTimestamp int64, symbol string) (structs.Maximum, error) {
 	var err error
 	maximum, exists := c.maximum[utcTimestamp][symbol]
@@ -629,6 +798,25 @@ func (c *Collector) GetQuotes(utcTimestamp int64, underlying string) ([]structs.
 	return quotes, err
 }
 
+// Underlying's quote at utcTimestamp.
+func (c *Collector) GetStock(utcTimestamp int64, underlying string) (structs.Stock, error) {
+	var err error
+	stocks, exists := c.stock[utcTimestamp]
+	if !exists {
+		// Stocks are loaded with quotes.
+		message := lazyLoadMessage{timestamp: utcTimestamp, _type: "Quotes"}
+		message.reply = make(chan error)
+		c.lazyLoadChannel <- message
+		err = <-message.reply
+		stocks = c.stock[utcTimestamp]
+	}
+	stock, exists := stocks[underlying]
+	if !exists && err == nil {
+		err = fmt.Errorf("underlying: %s does not exist for timestamp: %d", underlying, utcTimestamp)
+	}
+	return stock, err
+}
+
 func (c *Collector) getQuotes(utcTimestamp int64) (map[string]structs.Option, error) {
 	// Now just returns all quotes across all symbols.
 	// Will do more clever filtering if required later on.
@@ -642,7 +830,7 @@ func (c *Collector) getQuotes(utcTimestamp int64) (map[string]structs.Option, er
 	thisSaturday := funcs.NextFriday(utcTime).AddDate(0, 0, 1).Format("20060102")
 
 	// load livedir /quotes/yyyymmdd, decode, and filter by underlying, expiration.
-	quoteData, err := os.ReadFile(c.livedir + "/quotes/" + yyyymmdd)
+	quoteData, err := readDay(c.livedir+"/quotes", yyyymmdd)
 	if err != nil {
 		return map[string]structs.Option{}, err
 	}
@@ -651,10 +839,29 @@ func (c *Collector) getQuotes(utcTimestamp int64) (map[string]structs.Option, er
 	for ts, quotes := range c.quote {
 		quotesCopy[ts] = quotes
 	}
+	stocksCopy := map[int64]map[string]structs.Stock{}
+	for ts, stocks := range c.stock {
+		stocksCopy[ts] = stocks
+	}
 
 	for _, line := range bytes.Split(quoteData, []byte("\n")) {
 		ts, _type, encodedEquity := c.parseQuoteLine(string(line))
-		if ts == -1 || _type != "o" {
+		if ts == -1 {
+			continue
+		}
+		if _type == "s" {
+			s := structs.Stock{}
+			funcs.Decode(encodedEquity, &s, funcs.StockEncodingOrder)
+			if s.Symbol == "" {
+				continue
+			}
+			if _, exists := stocksCopy[ts]; !exists {
+				stocksCopy[ts] = map[string]structs.Stock{}
+			}
+			stocksCopy[ts][s.Symbol] = s
+			continue
+		}
+		if _type != "o" {
 			continue
 		}
 		o := structs.Option{}
@@ -674,10 +881,49 @@ func (c *Collector) getQuotes(utcTimestamp int64) (map[string]structs.Option, er
 		quotesCopy[ts][o.Symbol] = o
 	}
 	// Presumably this is safe since getQuotesChannel disallows concurrent access.
+	c.stock = stocksCopy
 	c.quote = quotesCopy
 	return c.quote[utcTimestamp], nil
 }
 
+// Splits a day of log lines into runs that share a log timestamp.
+func (c *Collector) groupLogLines(yyyymmdd string, lines [][]byte) []logGroup {
+	groups := []logGroup{}
+	for _, line := range lines {
+		logTimestamp, _type, encodedEquity := c.parseLogLine(yyyymmdd, string(line))
+		if len(groups) == 0 || groups[len(groups)-1].timestamp != logTimestamp {
+			groups = append(groups, logGroup{timestamp: logTimestamp})
+		}
+		g := &groups[len(groups)-1]
+		g.lines = append(g.lines, logLine{_type: _type, encodedEquity: encodedEquity})
+	}
+	return groups
+}
+
+func (c *Collector) loadCheckpoint() (checkpoint, error) {
+	cp := checkpoint{}
+	data, err := os.ReadFile(c.livedir + "/checkpoints/" + c.id)
+	if err != nil {
+		return cp, err
+	}
+	err = json.Unmarshal(data, &cp)
+	if err != nil {
+		return cp, err
+	}
+	if cp.Targets == nil {
+		cp.Targets = map[string]map[string]target{}
+	}
+	for _, _type := range []string{"current", "next"} {
+		if cp.Targets[_type] == nil {
+			cp.Targets[_type] = map[string]target{}
+		}
+	}
+	if cp.Maximums == nil {
+		cp.Maximums = map[string]map[string][]structs.Maximum{}
+	}
+	return cp, nil
+}
+
 func (c *Collector) loadMaximums() map[string]map[string][]structs.Maximum {
 	maximums := map[string]map[string][]structs.Maximum{}
 
@@ -727,65 +973,42 @@ func (c *Collector) loadTargets() map[string]map[string]target {
 }
 
 func (c *Collector) logError(functionName string, err any) {
-	err_str := ""
+	c.logSymbolError(functionName, "", err)
+}
+
+// Journal err as an ErrorRecord in errordir/yyyymmdd.
+func (c *Collector) logSymbolError(functionName string, symbol string, err any) {
+	r := ErrorRecord{Time: time.Now().UTC(), Function: functionName, Symbol: symbol}
 	switch err := err.(type) {
 	case string:
-		err_str = err
+		r.Message = err
 	case error:
-		err_str = fmt.Sprintf("%s", err)
+		r.Message = err.Error()
+		r.Kind = util.Kind(err)
 	}
-	message := fmt.Sprintf("[%s] %s", functionName, err_str)
-	funcs.LazyAppendFile(c.errordir, time.Now().Format("20060102"), time.Now().Format("15:04:05")+" : "+message)
+	line, _ := json.Marshal(r)
+	funcs.LazyAppendFile(c.errordir, r.Time.Format("20060102"), string(line))
 }
 
 func (c *Collector) maybeCycleMaximums(currentTimestamp int64) {
 	yymmdd := time.Unix(currentTimestamp, 0).Format("20060102")
+	expirations := []string{}
 	for expiration := range c.maximums {
 		// Not past expiration, do nothing.
 		if yymmdd <= expiration {
 			continue
 		}
-		maxpath := c.livedir + "/maximums"
-		funcs.LazyWriteFile(maxpath, expiration, []byte(""))
-		edges := map[string]structs.Maximum{} // timestamp_symbol_o.Type, maximum
-
-		// Write Edges and Maximums.
-		for _, maxSlice := range c.maximums[expiration] {
-			for _, max := range maxSlice {
-				// Uninterested in non-max maximum.
-				if max.MaximumBid <= max.OptionAsk {
-					continue
-				}
-				// Maximums.
-				em, _ := funcs.Encode(&max, funcs.MaximumEncodingOrder)
-				funcs.LazyAppendFile(maxpath, expiration, em)
-
-				// Edges.
-				// Not sure if I even care about Edges anymore.
-				key := fmt.Sprintf("%d_%s_%s", max.Timestamp, max.Underlying, max.OptionType)
-				if edges[key].OptionAsk <= 0 {
-					edges[key] = max
-					continue
-				}
-				// Not ultimately sure how to handle pre-computing edges when accounting for commission.
-				// This will vary from broker to broker.. so would become fairly complicated if totally generalized.
-				if funcs.Multiplier(max.MaximumBid, max.OptionAsk, 2.2) > funcs.Multiplier(edges[key].MaximumBid, edges[key].OptionAsk, 2.2) {
-					edges[key] = max
-				}
-			}
-		}
+		expirations = append(expirations, expiration)
+	}
+	sort.Strings(expirations)
 
-		// Encode.
-		es := []structs.Maximum{}
-		for _, e := range edges {
-			es = append(es, e)
+	for _, expiration := range expirations {
+		if c.deferred != nil {
+			c.deferred.cycleMaximums(expiration, c.maximums[expiration])
+			delete(c.maximums, expiration)
+			continue
 		}
-		encodedEdges, _ := c.SerializeMaximums(es)
-
-		// Save to c.livedir + "/edges/" + timestamp
-		path := c.livedir + "/edges"
-		filename := expiration
-		err := funcs.LazyWriteFile(path, filename, []byte(encodedEdges))
+		err := c.writeMaximums(expiration, c.maximums[expiration])
 		if err != nil {
 			c.logError("maybeCycleMaximums", err)
 			return
@@ -803,7 +1026,18 @@ func (c *Collector) maybeCycleTargets(current_timestamp int64) {
 	interval := getTenMinTimestamp(current_timestamp)
 	interval_hhmmss := interval % int64(24*60*60)
 
+	if c.deferred != nil {
+		c.deferred.cycle += 1
+	}
+
+	// Sorted so quotes are written in the same order every time.
+	symbols := []string{}
 	for symbol := range c.targets["current"] {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	for _, symbol := range symbols {
 		// Zero Timestamp suggests there is nothing to promote.
 		if c.targets["current"][symbol].Timestamp == 0 {
 			continue
@@ -879,6 +1113,31 @@ func (c *Collector) parseQuoteLine(line string) (utcTimestamp int64, _type strin
 	return utcTimestamp, _type, encodedEquity
 }
 
+func (c *Collector) processGroups(yyyymmdd string, groups []logGroup, currentTimestamp int64) int64 {
+	for _, g := range groups {
+		if g.timestamp != currentTimestamp && currentTimestamp != -1 {
+			c.maybeCycleTargets(currentTimestamp)
+			c.maybeCycleMaximums(currentTimestamp)
+
+			if c.deferred == nil {
+				fmt.Printf("[%s][current_timestamp] %d\n", yyyymmdd, currentTimestamp)
+			}
+		}
+		for _, line := range g.lines {
+			o, err := c.updateTarget(g.timestamp, line._type, line.encodedEquity)
+			if err == nil {
+				c.updateMaximum(o, g.timestamp)
+			}
+		}
+		currentTimestamp = g.timestamp
+	}
+	// Just in case fate is frowning on us.  (This should be almost always unnecessary.)
+	c.maybeCycleTargets(currentTimestamp)
+	c.maybeCycleMaximums(currentTimestamp)
+
+	return currentTimestamp
+}
+
 func (c *Collector) promoteTarget(t target) {
 	if t.Stock.Symbol == "" {
 		message := fmt.Sprintf("Empty Target, Discarding, t.Timestamp: %d", t.Timestamp)
@@ -892,7 +1151,12 @@ func (c *Collector) promoteTarget(t target) {
 		return
 	}
 	yyyymmdd := time.Unix(t.Timestamp, 0).Format("20060102")
-	funcs.LazyAppendFile(c.livedir+"/quotes", yyyymmdd, lines)
+	if c.deferred != nil {
+		c.deferred.appendQuotes(yyyymmdd, t.Stock.Symbol, lines)
+	} else {
+		funcs.LazyAppendFile(c.livedir+"/quotes", yyyymmdd, lines)
+		c.markDirty("quotes", yyyymmdd)
+	}
 
 	// touch appropriate /live/timestamp/<ts> filename.
 	ts := fmt.Sprintf("%d", t.Timestamp)
@@ -908,20 +1172,20 @@ func (c *Collector) promoteTarget(t target) {
 }
 
 func (c *Collector) SaveToLog(message any) (string, string) {
-	line := c.timestamp
-
-	switch message := message.(type) {
-	case structs.Stock:
-		es, _ := funcs.Encode(&message, funcs.StockEncodingOrder)
-		line += ",s," + es
-	case structs.Option:
-		eo, _ := funcs.Encode(&message, funcs.OptionEncodingOrder)
-		line += ",o," + eo
-	default:
-		panic("SaveToLog switching wrong!")
-	}
 	// Write to YYMMDD file in logdir.
+	// Plain lines rather than funcs.AppendRecord(), since logs and quotes are the format every dataset,
+	// archive and manifest already has.
 	filename := time.Now().Format("20060102")
+	line := c.encodeLogLine(c.timestamp, message)
+	funcs.LazyAppendFile(c.logdir, filename, line)
+
+	return filename, line
+}
+
+// SaveToLog() for streamed messages which each carry their own timestamp.
+func (c *Collector) saveToLog(message any, utcTimestamp int64) (string, string) {
+	filename := time.Unix(utcTimestamp, 0).UTC().Format("20060102")
+	line := c.encodeLogLine(fmt.Sprintf("%d", utcTimestamp%int64(24*60*60)), message)
 	funcs.LazyAppendFile(c.logdir, filename, line)
 
 	return filename, line
@@ -956,10 +1220,21 @@ func (c *Collector) updateMaximum(o structs.Option, timestamp int64) {
 	}
 
 	for idx := range c.maximums[o.Expiration][o.Symbol] {
+		// Loaded from before drawdown tracking.  Start the minimum here rather than at a 100% drawdown.
+		if c.maximums[o.Expiration][o.Symbol][idx].MinTimestamp == 0 {
+			c.maximums[o.Expiration][o.Symbol][idx].MinimumBid = o.Bid
+			c.maximums[o.Expiration][o.Symbol][idx].MinTimestamp = timestamp
+		}
 		if o.Bid > c.maximums[o.Expiration][o.Symbol][idx].MaximumBid {
 			c.maximums[o.Expiration][o.Symbol][idx].MaximumBid = o.Bid
 			c.maximums[o.Expiration][o.Symbol][idx].MaxTimestamp = timestamp
 		}
+		if o.Bid < c.maximums[o.Expiration][o.Symbol][idx].MinimumBid {
+			c.maximums[o.Expiration][o.Symbol][idx].MinimumBid = o.Bid
+			c.maximums[o.Expiration][o.Symbol][idx].MinTimestamp = timestamp
+		}
+		// Whatever we see last before cycling is the Bid at expiration.
+		c.maximums[o.Expiration][o.Symbol][idx].ExpirationBid = o.Bid
 	}
 }
 
@@ -981,6 +1256,8 @@ func (c *Collector) updateTarget(utcTimestamp int64, _type string, encodedEquity
 	return o, err
 }
 
+// Quotes for a later interval go to "next", which keeps whichever quote is closest to that interval per symbol
+// until maybeCycleTargets() promotes it.
 func (c *Collector) updateOptionTarget(o structs.Option, utc_timestamp int64) error {
 	hhmmss_in_seconds := utc_timestamp % int64(24*60*60)
 	near, distance := isNear(hhmmss_in_seconds, o.Time, 45)
@@ -1011,13 +1288,19 @@ func (c *Collector) updateOptionTarget(o structs.Option, utc_timestamp int64) er
 		// interval is old, do not want.
 		break
 	case utc_interval > current_target.Timestamp:
-		// Future interval, stick into "next"
+		// Future interval, stick into "next" if it is closer than what is there.
 		next_target := c.targets["next"][o.Underlying]
-		next_target.Timestamp = utc_interval
-		next_target.Options = map[string]structs.Option{}
-		next_target.Options[o.Symbol] = o
+		if next_target.Timestamp != utc_interval {
+			next_target = target{Timestamp: utc_interval, Options: map[string]structs.Option{}}
+		}
+		next_hhmmss := utc_interval % int64(24*60*60)
+		_, new_distance := isNear(next_hhmmss, o.Time, 45)
+		_, old_distance := isNear(next_hhmmss, next_target.Options[o.Symbol].Time, 45)
 
-		c.targets["next"][o.Underlying] = next_target
+		if new_distance < old_distance {
+			next_target.Options[o.Symbol] = o
+			c.targets["next"][o.Underlying] = next_target
+		}
 	}
 
 	return nil
@@ -1054,23 +1337,93 @@ func (c *Collector) updateStockTarget(s structs.Stock, utc_timestamp int64) erro
 		break
 	case utc_interval > current_target.Timestamp:
 		next_target := c.targets["next"][s.Symbol]
-		next_target.Timestamp = utc_interval
-		next_target.Stock = s
-		next_target.Options = map[string]structs.Option{}
+		if next_target.Timestamp != utc_interval {
+			next_target = target{Timestamp: utc_interval, Options: map[string]structs.Option{}}
+		}
+		next_hhmmss := utc_interval % int64(24*60*60)
+		_, new_distance := isNear(next_hhmmss, s.Time, 45)
+		_, old_distance := isNear(next_hhmmss, next_target.Stock.Time, 45)
 
-		c.targets["next"][s.Symbol] = next_target
+		if new_distance < old_distance {
+			next_target.Stock = s
+			c.targets["next"][s.Symbol] = next_target
+		}
 	}
 
 	return nil
 }
 
+func (c *Collector) writeMaximums(expiration string, maximums map[string][]structs.Maximum) error {
+	edges := map[string]structs.Maximum{} // timestamp_symbol_o.Type, maximum
+	encodedMaximums := ""
+
+	symbols := []string{}
+	for symbol := range maximums {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	// Write Edges and Maximums.
+	for _, symbol := range symbols {
+		for _, max := range maximums[symbol] {
+			// Uninterested in non-max maximum.
+			if max.MaximumBid <= max.OptionAsk {
+				continue
+			}
+			// Maximums.
+			em, _ := funcs.Encode(&max, funcs.MaximumEncodingOrder)
+			encodedMaximums += em + "\n"
+
+			// Edges.
+			// Not sure if I even care about Edges anymore.
+			key := fmt.Sprintf("%d_%s_%s", max.Timestamp, max.Underlying, max.OptionType)
+			if edges[key].OptionAsk <= 0 {
+				edges[key] = max
+				continue
+			}
+			// Not ultimately sure how to handle pre-computing edges when accounting for commission.
+			// This will vary from broker to broker.. so would become fairly complicated if totally generalized.
+			if funcs.Multiplier(max.MaximumBid, max.OptionAsk, 2.2) > funcs.Multiplier(edges[key].MaximumBid, edges[key].OptionAsk, 2.2) {
+				edges[key] = max
+			}
+		}
+	}
+	err := funcs.AtomicWriteFile(c.livedir+"/maximums", expiration, []byte(encodedMaximums))
+	if err != nil {
+		return err
+	}
+	c.markDirty("maximums", expiration)
+
+	// Encode.
+	keys := []string{}
+	for key := range edges {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	es := []structs.Maximum{}
+	for _, key := range keys {
+		es = append(es, edges[key])
+	}
+	encodedEdges, _ := c.SerializeMaximums(es)
+
+	// Save to c.livedir + "/edges/" + timestamp
+	c.markDirty("edges", expiration)
+	return funcs.AtomicWriteFile(c.livedir+"/edges", expiration, []byte(encodedEdges))
+}
+
 func encodeTarget(t target) (string, error) {
 	es, err := funcs.Encode(&t.Stock, funcs.StockEncodingOrder)
 	if err != nil {
 		return "", err
 	}
 	lines := fmt.Sprintf("%d,s,%s", t.Timestamp, es)
-	for _, o := range t.Options {
+	symbols := []string{}
+	for symbol := range t.Options {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		o := t.Options[symbol]
 		eo, err := funcs.Encode(&o, funcs.OptionEncodingOrder)
 		if err != nil {
 			return "", err
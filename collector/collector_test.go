@@ -1,18 +1,56 @@
 package collector
 
 import (
+	"github.com/eliwjones/thebox/adapter/feed"
 	"github.com/eliwjones/thebox/adapter/simulate"
 	"github.com/eliwjones/thebox/util/funcs"
 	"github.com/eliwjones/thebox/util/structs"
 
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
 
+// Generates a morning of minutely log lines for each symbol on each day.
+func writeTestLogs(logdir string, days []string, symbols []string) {
+	for d, yyyymmdd := range days {
+		lines := []string{}
+		for minute := 14*60 + 31; minute < 15*60+30; minute++ {
+			secs := int64(minute * 60)
+			for i, symbol := range symbols {
+				bid := 10000 * (i + 1)
+				s := structs.Stock{Symbol: symbol, Time: secs - 18000, Bid: bid, Ask: bid + 10, Volume: 100}
+				es, _ := funcs.Encode(&s, funcs.StockEncodingOrder)
+				lines = append(lines, fmt.Sprintf("%d,s,%s", secs, es))
+				for j, _type := range []string{"c", "p"} {
+					strike := bid + 500 - j*1000
+					// Wander around so maximums and minimums move.
+					optionBid := 100 + (minute*7+d*13+i*3+j)%50
+					o := structs.Option{Underlying: symbol, Expiration: "20150130", Time: secs - 18000, Strike: strike,
+						Bid: optionBid, Ask: optionBid + 5, Volume: 10, Type: _type}
+					o.Symbol = fmt.Sprintf("%s_013015%s%d", symbol, strings.ToUpper(_type), strike/100)
+					eo, _ := funcs.Encode(&o, funcs.OptionEncodingOrder)
+					lines = append(lines, fmt.Sprintf("%d,o,%s", secs, eo))
+				}
+			}
+		}
+		funcs.LazyWriteFile(logdir, yyyymmdd, []byte(strings.Join(lines, "\n")+"\n"))
+	}
+}
+
+func readSortedLines(path string) []string {
+	data, _ := os.ReadFile(path)
+	lines := strings.Split(string(data), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
 func Test_Collector_collect(t *testing.T) {
 	c := New("test", "../testdata", int64(60))
 
@@ -202,6 +240,26 @@ func Test_Collector_GetQuotes(t *testing.T) {
 	}
 }
 
+func Test_Collector_GetStock(t *testing.T) {
+	c := New("test", t.TempDir(), int64(60))
+	tgt := target{Timestamp: 1422540000, Stock: structs.Stock{Symbol: "AAPL", Bid: 11800, Ask: 11810}, Options: map[string]structs.Option{}}
+	lines, _ := encodeTarget(tgt)
+	funcs.LazyAppendFile(c.livedir+"/quotes", "20150129", lines)
+
+	stock, err := c.GetStock(1422540000, "AAPL")
+	if err != nil || stock.Bid != 11800 {
+		t.Errorf("Expected Bid: 11800, Got: %+v, Err: %v", stock, err)
+	}
+	_, err = c.GetStock(1422540000, "GOOG")
+	if err == nil {
+		t.Errorf("Expected err for missing underlying.")
+	}
+	_, err = c.GetStock(1422540060, "AAPL")
+	if err == nil {
+		t.Errorf("Expected err for missing timestamp.")
+	}
+}
+
 func Test_Collector_loadTargets(t *testing.T) {
 	c := New("test", "../testdata", int64(60))
 
@@ -306,6 +364,150 @@ func Test_Collector_maybeCycleTargets(t *testing.T) {
 	}
 }
 
+func Test_Collector_ProcessStream_resume(t *testing.T) {
+	days := []string{"20150129", "20150130", "20150202"}
+	symbols := []string{"AAPL", "GOOG"}
+
+	full := New("test", t.TempDir(), int64(60))
+	writeTestLogs(full.logdir, days, symbols)
+	full.ProcessStream(days[0], days[2], false)
+
+	resumed := New("test", t.TempDir(), int64(60))
+	writeTestLogs(resumed.logdir, days, symbols)
+	resumed.ProcessStream(days[0], days[1], false)
+
+	// Crash partway through last day leaves partial quotes lying around.
+	funcs.LazyAppendFile(resumed.livedir+"/quotes", days[2], "1422890400,s,GOOG,1422872400,20000,20010,0,0,0,100")
+
+	// Resuming must not need logs for checkpointed days.
+	os.Remove(resumed.logdir + "/" + days[0])
+	os.Remove(resumed.logdir + "/" + days[1])
+
+	resumed = New("test", resumed.rootdir, int64(60))
+	resumed.ProcessStream(days[0], days[2], true)
+
+	for _, path := range []string{"/quotes/" + days[0], "/quotes/" + days[2], "/maximums/20150130", "/edges/20150130"} {
+		expected := readSortedLines(full.livedir + path)
+		got := readSortedLines(resumed.livedir + path)
+		if len(expected) < 2 {
+			t.Errorf("Expected data in %s", path)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s mismatch.\nExpected:\n%v\nGot:\n%v", path, expected, got)
+		}
+	}
+
+	cp, err := resumed.loadCheckpoint()
+	if err != nil {
+		t.Errorf("Got err: %s", err)
+	}
+	if cp.Day != days[2] {
+		t.Errorf("Expected: %s, Got: %s", days[2], cp.Day)
+	}
+	if len(cp.Maximums) != 0 {
+		t.Errorf("Expected expired maximums to have been cycled. Got: %d", len(cp.Maximums))
+	}
+}
+
+func Test_Collector_ProcessStream_Workers(t *testing.T) {
+	days := []string{"20150129", "20150130", "20150202"}
+	symbols := []string{"AAPL", "BABA", "GOOG", "INTC", "MSFT"}
+
+	sequential := New("test", t.TempDir(), int64(60))
+	writeTestLogs(sequential.logdir, days, symbols)
+	sequential.ProcessStream(days[0], days[2], false)
+
+	parallel := New("test", t.TempDir(), int64(60))
+	parallel.Workers = 3
+	writeTestLogs(parallel.logdir, days, symbols)
+	parallel.ProcessStream(days[0], days[2], false)
+
+	paths := []string{"/maximums/20150130", "/edges/20150130", "/checkpoints/test"}
+	for _, day := range days {
+		paths = append(paths, "/quotes/"+day)
+	}
+	for _, path := range paths {
+		expected, err := os.ReadFile(sequential.livedir + path)
+		if err != nil || len(expected) == 0 {
+			t.Errorf("Expected data in %s. Err: %v", path, err)
+		}
+		got, _ := os.ReadFile(parallel.livedir + path)
+		if !bytes.Equal(expected, got) {
+			t.Errorf("%s mismatch.\nExpected:\n%s\nGot:\n%s", path, expected, got)
+		}
+	}
+}
+
+func Test_Collector_RunStream(t *testing.T) {
+	// Quotes every 7 seconds so the closest one to each interval is never exactly on it.
+	// Second day stops short of an interval, so targets left over from the first would show up.
+	day, _ := time.Parse("20060102", "20150129")
+	lines := []string{}
+	for d, until := range []int64{15*60*60 + 30*60, 15*60*60 + 25*60} {
+		for secs := int64(14*60*60 + 31*60); secs < until; secs += 7 {
+			for i, symbol := range []string{"AAPL", "GOOG", "INTC"} {
+				bid := 10000*(i+1) + int(secs%100)
+				s := structs.Stock{Symbol: symbol, Time: secs - 18000, Bid: bid, Ask: bid + 10, Volume: 100}
+				es, _ := funcs.Encode(&s, funcs.StockEncodingOrder)
+				o := structs.Option{Underlying: symbol, Symbol: symbol + "_020615C100", Expiration: "20150206", Time: secs - 18000,
+					Strike: 10000, Bid: 100 + int(secs%50), Ask: 110, Volume: 10, Type: "c"}
+				eo, _ := funcs.Encode(&o, funcs.OptionEncodingOrder)
+				timestamp := day.Unix() + int64(d*24*60*60) + secs
+				lines = append(lines, fmt.Sprintf("%d,s,%s", timestamp, es), fmt.Sprintf("%d,o,%s", timestamp, eo))
+			}
+		}
+	}
+	server, err := feed.NewServer(lines)
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	defer server.Close()
+
+	c := New("test", t.TempDir(), int64(60))
+	c.Reckless = true
+	c.Collect("AAPL")
+	c.Collect("GOOG")
+	err = c.RunStream(feed.New(server.Addr()))
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+
+	quotes := readSortedLines(c.livedir + "/quotes/20150129")
+	promoted := map[string]int64{}
+	for _, line := range quotes {
+		timestamp, _type, encodedEquity := c.parseQuoteLine(line)
+		if _type != "s" {
+			continue
+		}
+		s := structs.Stock{}
+		funcs.Decode(encodedEquity, &s, funcs.StockEncodingOrder)
+		if s.Symbol == "INTC" {
+			t.Errorf("Did not subscribe to INTC. Got: %s", line)
+		}
+		// Closest quote on a 7 second grid is never more than 3 seconds away.
+		_, distance := isNear(timestamp%int64(24*60*60), s.Time, 45)
+		if timestamp >= day.Unix()+14*60*60+40*60 && distance > 3 {
+			t.Errorf("Expected closest quote to %d. Got %v seconds away: %s", timestamp, distance, line)
+		}
+		promoted[s.Symbol] += 1
+	}
+	if promoted["AAPL"] < 5 || promoted["GOOG"] < 5 {
+		t.Errorf("Expected a promoted target for every interval. Got: %v", promoted)
+	}
+
+	// Everything streamed was logged, and replaying the log gives the same quotes.
+	replay := New("test", t.TempDir(), int64(60))
+	funcs.CopyDir(c.logdir, replay.logdir)
+	replay.ProcessStream("20150129", "20150130", false)
+	for _, yyyymmdd := range []string{"20150129", "20150130"} {
+		expected := readSortedLines(replay.livedir + "/quotes/" + yyyymmdd)
+		got := readSortedLines(c.livedir + "/quotes/" + yyyymmdd)
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Expected streamed quotes to match replayed log for %s.\nExpected:\n%v\nGot:\n%v", yyyymmdd, expected, got)
+		}
+	}
+}
+
 // Mainly, wish to verify maximums is updated.
 func Test_Collector_promoteTarget(tst *testing.T) {
 	tmp := tst.TempDir()
@@ -370,6 +572,16 @@ func Test_Collector_SerializeMaximums_DeserializeMaximums(t *testing.T) {
 	if !reflect.DeepEqual(maximums, dms) {
 		t.Errorf("Expected:\n%v\nGot:\n%v", maximums, dms)
 	}
+
+	// Legacy maximums without drawdown columns should still decode.
+	legacy := "1422014400,c,11300,AAPL,AAPL_012315C113,0,105,110,1422014400"
+	dms, err = c.DeserializeMaximums(legacy)
+	if err != nil {
+		t.Errorf("Got err: %s", err)
+	}
+	if len(dms) != 1 || dms[0].MaximumBid != 110 || dms[0].MinimumBid != 0 {
+		t.Errorf("Expected legacy maximum with MaximumBid: 110, Got: %v", dms)
+	}
 }
 
 func Test_Collector_updateTarget(t *testing.T) {
@@ -393,6 +605,35 @@ func Test_Collector_updateTarget(t *testing.T) {
 	}
 }
 
+func Test_Collector_updateTarget_next(t *testing.T) {
+	c := New("test", "../testdata", int64(60))
+
+	t1, _ := time.Parse("20060102 15:04", "20150126 19:00")
+	ts := t1.Unix()
+	local := ts%int64(24*60*60) - 18000
+	c.updateStockTarget(structs.Stock{Symbol: "GOOG", Time: local, Bid: 100}, ts)
+
+	// Next interval keeps whichever quote is closest to it, not whichever came last.
+	for _, offset := range []int64{560, 590, 630} {
+		c.updateStockTarget(structs.Stock{Symbol: "GOOG", Time: local + offset, Bid: int(offset)}, ts+offset)
+		for _, symbol := range []string{"GOOG_013015C600", "GOOG_013015P500"} {
+			o := structs.Option{Underlying: "GOOG", Symbol: symbol, Time: local + offset, Bid: int(offset)}
+			c.updateOptionTarget(o, ts+offset)
+		}
+	}
+
+	next := c.targets["next"]["GOOG"]
+	if next.Timestamp != ts+600 || next.Stock.Bid != 590 {
+		t.Errorf("Expected Timestamp: %d, Bid: 590, Got: %d, %d", ts+600, next.Timestamp, next.Stock.Bid)
+	}
+	if len(next.Options) != 2 || next.Options["GOOG_013015C600"].Bid != 590 || next.Options["GOOG_013015P500"].Bid != 590 {
+		t.Errorf("Expected both options at Bid: 590, Got: %v", next.Options)
+	}
+	if c.targets["current"]["GOOG"].Stock.Bid != 100 {
+		t.Errorf("Did not expect current target to change. Got: %v", c.targets["current"]["GOOG"])
+	}
+}
+
 // Kitchen sinking this since don't want to do over and over.
 func Test_Collector_addMaximum_updateMaximum_dumpMaximums_loadMaximums(t *testing.T) {
 	c := New("test", "../testdata", int64(60))
@@ -431,6 +672,9 @@ func Test_Collector_addMaximum_updateMaximum_dumpMaximums_loadMaximums(t *testin
 	if c.maximums[o.Expiration][o.Symbol][0].MaximumBid == o.Bid {
 		t.Errorf("Did not expect MaximumBid to change.")
 	}
+	if c.maximums[o.Expiration][o.Symbol][0].MinimumBid != o.Bid {
+		t.Errorf("Expected MinimumBid: %d, Got: %d", o.Bid, c.maximums[o.Expiration][o.Symbol][0].MinimumBid)
+	}
 
 	o.Bid += 100
 	c.updateMaximum(o, ts)
@@ -438,6 +682,12 @@ func Test_Collector_addMaximum_updateMaximum_dumpMaximums_loadMaximums(t *testin
 	if c.maximums[o.Expiration][o.Symbol][0].MaximumBid != o.Bid {
 		t.Errorf("Expected: %d, Got: %d", o.Bid, c.maximums[o.Expiration][o.Symbol][0].MaximumBid)
 	}
+	if c.maximums[o.Expiration][o.Symbol][0].MinimumBid != o.Bid-100 {
+		t.Errorf("Did not expect MinimumBid to change.")
+	}
+	if c.maximums[o.Expiration][o.Symbol][0].ExpirationBid != o.Bid {
+		t.Errorf("Expected ExpirationBid: %d, Got: %d", o.Bid, c.maximums[o.Expiration][o.Symbol][0].ExpirationBid)
+	}
 
 	maximums := c.maximums
 
@@ -454,4 +704,15 @@ func Test_Collector_addMaximum_updateMaximum_dumpMaximums_loadMaximums(t *testin
 	if !reflect.DeepEqual(c.maximums, maximums) {
 		t.Errorf("Expected %v, Got: %v", maximums, c.maximums)
 	}
+
+	// Loaded from before drawdown tracking.
+	c.maximums[o.Expiration][o.Symbol][0].MinimumBid = 0
+	c.maximums[o.Expiration][o.Symbol][0].MinTimestamp = 0
+	c.maximums[o.Expiration][o.Symbol][0].ExpirationBid = 0
+	c.updateMaximum(o, ts+60)
+
+	legacy := c.maximums[o.Expiration][o.Symbol][0]
+	if legacy.MinimumBid != o.Bid || legacy.MinTimestamp != ts+60 || legacy.ExpirationBid != o.Bid {
+		t.Errorf("Expected MinimumBid: %d, MinTimestamp: %d, ExpirationBid: %d, Got: %+v", o.Bid, ts+60, o.Bid, legacy)
+	}
 }
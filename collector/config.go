@@ -0,0 +1,253 @@
+package collector
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/secrets"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	CONFIG              = "config.json"
+	PASSPHRASE_ENV      = "THEBOX_PASSPHRASE" // Used to unlock secrets when no key_file is configured.
+	DEFAULT_INTERVAL    = int64(60)
+	DEFAULT_MAX_DAYS    = 22
+	DEFAULT_ARCHIVE     = 14 // Days to keep log/ and live/quotes files before zipping them up.
+	MAX_EXPIRATION_DAYS = 28 // collect() only looks at this month and the month containing the limit.
+)
+
+// Typed replacement for the old line-based root_dir/config.
+type Config struct {
+	Adapter     string          `json:"adapter"`     // "tdameritrade" or "simulate".
+	Credentials string          `json:"credentials"` // Encrypted secrets.Store holding adapter credentials.
+	TokenCache  string          `json:"token_cache"` // Encrypted secrets.Store for session tokens.  Defaults to credentials.
+	KeyFile     string          `json:"key_file"`    // Unlocks secrets.  Falls back to $THEBOX_PASSPHRASE.
+	Feed        string          `json:"feed"`        // host:port of line-delimited quote feed for 'stream'.
+	Symbols     []SymbolConfig  `json:"symbols"`     // What to collect.
+	Interval    int64           `json:"interval"`    // Seconds between collect runs.  RunOnce() must finish inside it.
+	Paths       PathConfig      `json:"paths"`       // Where to put data.  Defaults to root_dir/{log,live,error}.
+	Retention   RetentionConfig `json:"retention"`   // What 'archive' keeps around.
+
+	RootDir string `json:"-"` // Relative paths are resolved against this.
+}
+
+type PathConfig struct {
+	Log   string `json:"log"`
+	Live  string `json:"live"`
+	Error string `json:"error"`
+}
+
+// All in days.  Prune values of 0 keep archives forever.
+type RetentionConfig struct {
+	ArchiveAfter    int `json:"archive_after"`     // Zip up days older than this.  Defaults to 14.
+	LogPruneAfter   int `json:"log_prune_after"`   // Delete archived logs older than this.
+	QuotePruneAfter int `json:"quote_prune_after"` // Delete archived quotes older than this.
+}
+
+type SymbolConfig struct {
+	Symbol  string `json:"symbol"`
+	MinDays int    `json:"min_days"` // Skip expirations closer than this many days.
+	MaxDays int    `json:"max_days"` // Skip expirations further than this many days.  Defaults to 22.
+}
+
+// Config for root_dir without a config file.  Good enough for everything but 'collect'.
+func DefaultConfig(rootdir string) Config {
+	cfg := Config{RootDir: rootdir, Interval: DEFAULT_INTERVAL}
+	cfg.Retention = RetentionConfig{ArchiveAfter: DEFAULT_ARCHIVE}
+	cfg.Paths = PathConfig{Log: rootdir + "/log", Live: rootdir + "/live", Error: rootdir + "/error"}
+	return cfg
+}
+
+func LoadConfig(rootdir string) (Config, error) {
+	cfg := DefaultConfig(rootdir)
+	data, err := os.ReadFile(rootdir + "/" + CONFIG)
+	if err != nil {
+		return cfg, err
+	}
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %s", CONFIG, err)
+	}
+	cfg.RootDir = rootdir
+
+	// Fill in defaults and resolve paths.
+	if cfg.Interval == 0 {
+		cfg.Interval = DEFAULT_INTERVAL
+	}
+	if cfg.Retention.ArchiveAfter == 0 {
+		cfg.Retention.ArchiveAfter = DEFAULT_ARCHIVE
+	}
+	for idx := range cfg.Symbols {
+		if cfg.Symbols[idx].MaxDays == 0 {
+			cfg.Symbols[idx].MaxDays = DEFAULT_MAX_DAYS
+		}
+	}
+	defaults := DefaultConfig(rootdir).Paths
+	cfg.Paths.Log = cfg.resolve(cfg.Paths.Log, defaults.Log)
+	cfg.Paths.Live = cfg.resolve(cfg.Paths.Live, defaults.Live)
+	cfg.Paths.Error = cfg.resolve(cfg.Paths.Error, defaults.Error)
+	cfg.Credentials = cfg.resolve(cfg.Credentials, "")
+	cfg.TokenCache = cfg.resolve(cfg.TokenCache, "")
+	cfg.KeyFile = cfg.resolve(cfg.KeyFile, "")
+
+	return cfg, cfg.Validate()
+}
+
+// Converts the old plaintext line-based config (id, pass, sid, jsessionid, symbols...) into config.json
+// and an encrypted secrets store.  A key file is generated unless $THEBOX_PASSPHRASE is set.
+// The old config is removed once its secrets are encrypted, so they are not left lying around in plaintext.
+func MigrateConfig(rootdir string) (Config, error) {
+	lines, err := funcs.GetConfig(rootdir + "/config")
+	if err != nil {
+		return Config{}, err
+	}
+	if len(lines) < 4 {
+		return Config{}, fmt.Errorf("expected at least 4 lines in legacy config. got: %d", len(lines))
+	}
+	cfg := Config{Adapter: "tdameritrade", Credentials: "secrets", TokenCache: "token", Interval: DEFAULT_INTERVAL}
+	for _, symbol := range lines[4:] {
+		if symbol == "" {
+			continue
+		}
+		cfg.Symbols = append(cfg.Symbols, SymbolConfig{Symbol: symbol, MaxDays: DEFAULT_MAX_DAYS})
+	}
+	if os.Getenv(PASSPHRASE_ENV) == "" {
+		cfg.KeyFile = "secret.key"
+		err = secrets.GenerateKeyFile(rootdir + "/" + cfg.KeyFile)
+		if err != nil {
+			return cfg, err
+		}
+	}
+	data, _ := json.MarshalIndent(cfg, "", "  ")
+	err = os.WriteFile(rootdir+"/"+CONFIG, data, 0600)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg, err = LoadConfig(rootdir)
+	if err != nil {
+		return cfg, err
+	}
+	store, err := cfg.OpenSecrets()
+	if err != nil {
+		return cfg, err
+	}
+	err = store.SetCredentials(cfg.Adapter, structs.Credentials{Id: lines[0], Auth: lines[1], Source: lines[2]})
+	if err != nil {
+		return cfg, err
+	}
+	err = store.SaveToken(cfg.Adapter, lines[3])
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, os.Remove(rootdir + "/config")
+}
+
+func (cfg Config) OpenSecrets() (*secrets.Store, error) {
+	var key secrets.Key
+	switch {
+	case cfg.KeyFile != "":
+		k, err := secrets.KeyFile(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	case os.Getenv(PASSPHRASE_ENV) != "":
+		key = secrets.Passphrase(os.Getenv(PASSPHRASE_ENV))
+	default:
+		return nil, fmt.Errorf("no key_file configured and $%s is not set", PASSPHRASE_ENV)
+	}
+	tokenCache := cfg.TokenCache
+	if tokenCache == cfg.Credentials {
+		tokenCache = ""
+	}
+	return secrets.Open(cfg.Credentials, tokenCache, key)
+}
+
+// Checks the fields every action uses.  collect and stream also need ValidateCollect().
+func (cfg Config) Validate() error {
+	return joinProblems(cfg.problems())
+}
+
+// Validate() plus the adapter, credentials and symbols that only collect and stream use.
+func (cfg Config) ValidateCollect() error {
+	errs := cfg.problems()
+
+	switch cfg.Adapter {
+	case "tdameritrade", "simulate":
+	default:
+		errs = append(errs, fmt.Sprintf("unknown adapter: '%s'", cfg.Adapter))
+	}
+	if cfg.Credentials == "" {
+		errs = append(errs, "credentials is required")
+	}
+	if len(cfg.Symbols) == 0 {
+		errs = append(errs, "at least one symbol is required")
+	}
+	seen := map[string]bool{}
+	for _, s := range cfg.Symbols {
+		if s.Symbol == "" || s.Symbol != strings.ToUpper(s.Symbol) || strings.ContainsAny(s.Symbol, " ,_/") {
+			errs = append(errs, fmt.Sprintf("bad symbol: '%s'", s.Symbol))
+		}
+		if seen[s.Symbol] {
+			errs = append(errs, fmt.Sprintf("duplicate symbol: '%s'", s.Symbol))
+		}
+		seen[s.Symbol] = true
+		if s.MinDays < 0 || s.MaxDays < 1 || s.MaxDays > MAX_EXPIRATION_DAYS || s.MinDays > s.MaxDays {
+			errs = append(errs, fmt.Sprintf("%s: need 0 <= min_days <= max_days <= %d. got: %d, %d", s.Symbol, MAX_EXPIRATION_DAYS, s.MinDays, s.MaxDays))
+		}
+	}
+	return joinProblems(errs)
+}
+
+func (cfg Config) problems() []string {
+	errs := []string{}
+	if cfg.Interval <= 10 {
+		errs = append(errs, fmt.Sprintf("interval must be more than 10 seconds. got: %d", cfg.Interval))
+	}
+	if err := cfg.Retention.Validate(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	return errs
+}
+
+func joinProblems(errs []string) error {
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (r RetentionConfig) Validate() error {
+	errs := []string{}
+	if r.ArchiveAfter < 0 {
+		errs = append(errs, fmt.Sprintf("archive_after must not be negative. got: %d", r.ArchiveAfter))
+	}
+	for name, pruneAfter := range map[string]int{"log_prune_after": r.LogPruneAfter, "quote_prune_after": r.QuotePruneAfter} {
+		if pruneAfter != 0 && pruneAfter < r.ArchiveAfter {
+			errs = append(errs, fmt.Sprintf("%s must be 0 or at least archive_after. got: %d", name, pruneAfter))
+		}
+	}
+	sort.Strings(errs)
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (cfg Config) resolve(path string, fallback string) string {
+	if path == "" {
+		return fallback
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(cfg.RootDir, path)
+}
@@ -0,0 +1,170 @@
+package collector
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_Config_LoadConfig(t *testing.T) {
+	rootdir := t.TempDir()
+
+	_, err := LoadConfig(rootdir)
+	if err == nil {
+		t.Errorf("Expected err for missing config.")
+	}
+
+	data := `{"adapter": "simulate", "credentials": "creds.json", "token_cache": "/tmp/token",
+		"symbols": [{"symbol": "AAPL"}, {"symbol": "GOOG", "min_days": 2, "max_days": 15}],
+		"paths": {"live": "/data/live"}}`
+	os.WriteFile(rootdir+"/"+CONFIG, []byte(data), 0600)
+
+	cfg, err := LoadConfig(rootdir)
+	if err != nil {
+		t.Errorf("Did not expect err: %s", err)
+	}
+	if cfg.Interval != DEFAULT_INTERVAL {
+		t.Errorf("Expected: %d, Got: %d", DEFAULT_INTERVAL, cfg.Interval)
+	}
+	if cfg.Retention.ArchiveAfter != DEFAULT_ARCHIVE {
+		t.Errorf("Expected: %d, Got: %d", DEFAULT_ARCHIVE, cfg.Retention.ArchiveAfter)
+	}
+	if cfg.Symbols[0].MaxDays != DEFAULT_MAX_DAYS || cfg.Symbols[1].MaxDays != 15 {
+		t.Errorf("Expected MaxDays: %d, 15. Got: %v", DEFAULT_MAX_DAYS, cfg.Symbols)
+	}
+	if cfg.Credentials != rootdir+"/creds.json" || cfg.TokenCache != "/tmp/token" {
+		t.Errorf("Expected resolved paths, Got: %s, %s", cfg.Credentials, cfg.TokenCache)
+	}
+	if cfg.Paths.Log != rootdir+"/log" || cfg.Paths.Live != "/data/live" {
+		t.Errorf("Expected resolved paths, Got: %+v", cfg.Paths)
+	}
+
+	c := NewFromConfig("test", cfg)
+	if c.livedir != "/data/live" || c.rules["GOOG"].MinDays != 2 {
+		t.Errorf("Expected config to be applied. Got: %s, %v", c.livedir, c.rules)
+	}
+}
+
+func Test_Config_MigrateConfig(t *testing.T) {
+	rootdir := t.TempDir()
+	t.Setenv(PASSPHRASE_ENV, "")
+	os.WriteFile(rootdir+"/config", []byte("user\npass\nsource\nsession\nAAPL\nGOOG\n"), 0600)
+
+	cfg, err := MigrateConfig(rootdir)
+	if err != nil {
+		t.Errorf("Did not expect err: %s", err)
+	}
+	if len(cfg.Symbols) != 2 {
+		t.Errorf("Expected 2 symbols, Got: %v", cfg.Symbols)
+	}
+	info, _ := os.Stat(rootdir + "/secret.key")
+	if info == nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected generated key file with 0600, Got: %v", info)
+	}
+	if _, err := os.Stat(rootdir + "/config"); !os.IsNotExist(err) {
+		t.Errorf("Expected plaintext config removed, Got: %v", err)
+	}
+	plaintext, _ := os.ReadFile(cfg.Credentials)
+	if strings.Contains(string(plaintext), "pass") {
+		t.Errorf("Expected encrypted credentials, Got: %s", plaintext)
+	}
+
+	store, err := cfg.OpenSecrets()
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	creds, err := store.Credentials("tdameritrade")
+	if err != nil || creds.Auth != "pass" {
+		t.Errorf("Expected auth: pass, Got: %v, Err: %s", creds, err)
+	}
+	if store.Token("tdameritrade") != "session" {
+		t.Errorf("Expected: session, Got: %s", store.Token("tdameritrade"))
+	}
+
+	err = store.SaveToken("tdameritrade", "newsession")
+	if err != nil {
+		t.Errorf("Did not expect err: %s", err)
+	}
+	store, _ = cfg.OpenSecrets()
+	if store.Token("tdameritrade") != "newsession" {
+		t.Errorf("Expected: newsession, Got: %s", store.Token("tdameritrade"))
+	}
+	info, _ = os.Stat(cfg.TokenCache)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected token cache with 0600, Got: %o", info.Mode().Perm())
+	}
+}
+
+func Test_Config_OpenSecrets(t *testing.T) {
+	rootdir := t.TempDir()
+	cfg := Config{Credentials: rootdir + "/secrets"}
+	t.Setenv(PASSPHRASE_ENV, "")
+
+	_, err := cfg.OpenSecrets()
+	if err == nil {
+		t.Errorf("Expected err without key_file or passphrase.")
+	}
+
+	t.Setenv(PASSPHRASE_ENV, "hunter2")
+	store, err := cfg.OpenSecrets()
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	store.SaveToken("simulate", "session")
+
+	store, _ = cfg.OpenSecrets()
+	if store.Token("simulate") != "session" {
+		t.Errorf("Expected token in credentials file, Got: %s", store.Token("simulate"))
+	}
+
+	t.Setenv(PASSPHRASE_ENV, "wrong")
+	_, err = cfg.OpenSecrets()
+	if err == nil {
+		t.Errorf("Expected err for wrong passphrase.")
+	}
+}
+
+func Test_Config_Validate(t *testing.T) {
+	cfg := Config{Adapter: "simulate", Credentials: "creds.json", Interval: 60}
+	cfg.Symbols = []SymbolConfig{{Symbol: "AAPL", MaxDays: 22}}
+	if err := cfg.ValidateCollect(); err != nil {
+		t.Errorf("Did not expect err: %s", err)
+	}
+
+	bad := []Config{
+		{Adapter: "etrade", Credentials: "creds.json", Interval: 60, Symbols: cfg.Symbols},
+		{Adapter: "simulate", Interval: 60, Symbols: cfg.Symbols},
+		{Adapter: "simulate", Credentials: "creds.json", Interval: 5, Symbols: cfg.Symbols},
+		{Adapter: "simulate", Credentials: "creds.json", Interval: 60},
+		{Adapter: "simulate", Credentials: "creds.json", Interval: 60, Symbols: []SymbolConfig{{Symbol: "aapl", MaxDays: 22}}},
+		{Adapter: "simulate", Credentials: "creds.json", Interval: 60, Symbols: []SymbolConfig{{Symbol: "AAPL", MaxDays: 22}, {Symbol: "AAPL", MaxDays: 22}}},
+		{Adapter: "simulate", Credentials: "creds.json", Interval: 60, Symbols: []SymbolConfig{{Symbol: "AAPL", MaxDays: 45}}},
+		{Adapter: "simulate", Credentials: "creds.json", Interval: 60, Symbols: []SymbolConfig{{Symbol: "AAPL", MinDays: 10, MaxDays: 5}}},
+		{Adapter: "simulate", Credentials: "creds.json", Interval: 60, Symbols: cfg.Symbols, Retention: RetentionConfig{ArchiveAfter: 14, LogPruneAfter: 7}},
+	}
+	for _, b := range bad {
+		err := b.ValidateCollect()
+		if err == nil {
+			t.Errorf("Expected err for: %+v", b)
+		}
+	}
+
+	// All problems are reported at once.
+	err := Config{}.ValidateCollect()
+	if err == nil || strings.Count(err.Error(), ";") < 3 {
+		t.Errorf("Expected multiple errors, Got: %s", err)
+	}
+
+	// Actions other than collect and stream only need paths, interval and retention.
+	paths := Config{Interval: 60, Retention: RetentionConfig{ArchiveAfter: 14}}
+	if err := paths.Validate(); err != nil {
+		t.Errorf("Did not expect err: %s", err)
+	}
+	if err := paths.ValidateCollect(); err == nil {
+		t.Errorf("Expected err without adapter, credentials and symbols.")
+	}
+	paths.Retention.LogPruneAfter = 7
+	if err := paths.Validate(); err == nil {
+		t.Errorf("Expected err for: %+v", paths)
+	}
+}
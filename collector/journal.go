@@ -0,0 +1,117 @@
+package collector
+
+import (
+	"github.com/eliwjones/thebox/util"
+
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errordir/yyyymmdd holds one JSON ErrorRecord per line.  Files from before the journal
+// have "HH:MM:SS : [function] message" lines, which Summarize() still understands.
+
+type ErrorRecord struct {
+	Time     time.Time      `json:"time"`
+	Function string         `json:"function"`
+	Symbol   string         `json:"symbol,omitempty"`
+	Kind     util.ErrorKind `json:"kind"`
+	Message  string         `json:"message"`
+}
+
+// Recurring failure.  Messages are grouped with digits masked so timestamps and counts don't split groups.
+type ErrorSummary struct {
+	Function string
+	Symbol   string
+	Kind     util.ErrorKind
+	Message  string // First message seen.
+	Count    int
+	First    time.Time
+	Last     time.Time
+}
+
+var digits = regexp.MustCompile(`[0-9]+`)
+
+// Reads journals for days in [start, end] ("" for no limit) and groups them, most frequent first.
+func Summarize(errordir string, start string, end string) ([]ErrorSummary, error) {
+	entries, err := os.ReadDir(errordir)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[string]*ErrorSummary{}
+	for _, entry := range entries {
+		day := entry.Name()
+		if entry.IsDir() || !isDay(day) || (start != "" && day < start) || (end != "" && day > end) {
+			continue
+		}
+		data, err := os.ReadFile(errordir + "/" + day)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			r, err := parseErrorLine(day, line)
+			if err != nil {
+				continue
+			}
+			key := strings.Join([]string{r.Function, r.Symbol, r.Kind.String(), digits.ReplaceAllString(r.Message, "#")}, "|")
+			g, exists := groups[key]
+			if !exists {
+				g = &ErrorSummary{Function: r.Function, Symbol: r.Symbol, Kind: r.Kind, Message: r.Message, First: r.Time, Last: r.Time}
+				groups[key] = g
+			}
+			g.Count += 1
+			if r.Time.Before(g.First) {
+				g.First = r.Time
+			}
+			if r.Time.After(g.Last) {
+				g.Last = r.Time
+			}
+		}
+	}
+
+	summaries := []ErrorSummary{}
+	for _, g := range groups {
+		summaries = append(summaries, *g)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Count == summaries[j].Count {
+			return summaries[i].Last.After(summaries[j].Last)
+		}
+		return summaries[i].Count > summaries[j].Count
+	})
+	return summaries, nil
+}
+
+func parseErrorLine(yyyymmdd string, line string) (ErrorRecord, error) {
+	r := ErrorRecord{}
+	if line == "" {
+		return r, errors.New("empty line")
+	}
+	if strings.HasPrefix(line, "{") {
+		err := json.Unmarshal([]byte(line), &r)
+		return r, err
+	}
+
+	// Legacy: "HH:MM:SS : [function] message"
+	hhmmss, rest, found := strings.Cut(line, " : [")
+	if !found {
+		return r, errors.New("unrecognized line")
+	}
+	function, message, found := strings.Cut(rest, "] ")
+	if !found {
+		return r, errors.New("unrecognized line")
+	}
+	t, err := time.ParseInLocation("20060102 15:04:05", yyyymmdd+" "+hhmmss, time.Local)
+	if err != nil {
+		return r, err
+	}
+	r.Time = t.UTC()
+	r.Function = function
+	r.Message = message
+	return r, nil
+}
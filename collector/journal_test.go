@@ -0,0 +1,58 @@
+package collector
+
+import (
+	"github.com/eliwjones/thebox/util"
+
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Collector_logSymbolError(t *testing.T) {
+	c := New("test", t.TempDir(), int64(60))
+	err := fmt.Errorf("201501: %w", util.NewAdapterError("tdameritrade", util.RATELIMIT, "429 Too Many Requests"))
+	c.logSymbolError("collect", "AAPL", err)
+
+	data, _ := os.ReadFile(c.errordir + "/" + time.Now().UTC().Format("20060102"))
+	r := ErrorRecord{}
+	json.Unmarshal([]byte(strings.TrimSpace(string(data))), &r)
+	if r.Function != "collect" || r.Symbol != "AAPL" || r.Kind != util.RATELIMIT || r.Message != err.Error() {
+		t.Errorf("Unexpected record: %+v", r)
+	}
+	if !strings.Contains(string(data), `"kind":"RATELIMIT"`) {
+		t.Errorf("Expected kind by name, Got: %s", data)
+	}
+}
+
+func Test_Summarize(t *testing.T) {
+	c := New("test", t.TempDir(), int64(60))
+	for i := range 3 {
+		c.logSymbolError("collect", "AAPL", util.NewAdapterError("tdameritrade", util.RATELIMIT, "retry in %d seconds", i+10))
+	}
+	c.logSymbolError("collect", "GOOG", util.NewAdapterError("tdameritrade", util.BADDATA, "received less than 6 options"))
+	c.logError("promoteTarget", "Empty Target, Discarding, t.Timestamp: 1422540000")
+	// From before the journal.
+	os.WriteFile(c.errordir+"/20150129", []byte("10:00:00 : [promoteTarget] Empty Target, Discarding, t.Timestamp: 1422525600\ngarbage\n"), 0777)
+
+	summaries, err := Summarize(c.errordir, "", "")
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	if len(summaries) != 3 {
+		t.Fatalf("Expected 3 groups, Got: %+v", summaries)
+	}
+	if summaries[0].Count != 3 || summaries[0].Kind != util.RATELIMIT || summaries[0].Symbol != "AAPL" {
+		t.Errorf("Expected AAPL rate limits first, Got: %+v", summaries[0])
+	}
+	if summaries[1].Count != 2 || summaries[1].Function != "promoteTarget" || summaries[1].First.Format("20060102") != "20150129" {
+		t.Errorf("Expected legacy and new promoteTarget errors together, Got: %+v", summaries[1])
+	}
+
+	summaries, _ = Summarize(c.errordir, "20150129", "20150129")
+	if len(summaries) != 1 || summaries[0].Count != 1 {
+		t.Errorf("Expected only 20150129, Got: %+v", summaries)
+	}
+}
@@ -0,0 +1,192 @@
+package collector
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// live/manifest.json holds a checksum and record count for every file in live/{quotes,maximums,edges}.
+// The collector marks files dirty as it writes them and flushes the manifest whenever it dumps state,
+// so Verify() can tell a complete file from one that was truncated, half written or edited by hand.
+
+const (
+	MANIFEST = "manifest.json"
+)
+
+var manifestDirs = []string{"quotes", "maximums", "edges"}
+
+// From Verify() for data collected before manifests.  RebuildManifest() vouches for it as it stands.
+var ErrNoManifest = errors.New(MANIFEST + " not found")
+
+// Held across load-modify-write of the manifest and around dirty.
+var manifestMu sync.Mutex
+
+type manifestEntry struct {
+	Sha256  string `json:"sha256"`
+	Records int    `json:"records"` // Non-empty lines.
+	Bytes   int64  `json:"bytes"`
+}
+
+// Checks every file in the manifest still matches and that nothing has shown up without being recorded.
+// Returns one line per problem.  err is only for when the manifest itself can not be read.
+func (c *Collector) Verify() ([]string, error) {
+	if _, err := os.Stat(c.livedir + "/" + MANIFEST); errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoManifest
+	}
+	manifest, err := c.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	problems := []string{}
+
+	for _, path := range sortedKeys(manifest) {
+		expected := manifest[path]
+		got, err := c.manifestEntry(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			problems = append(problems, fmt.Sprintf("%s: missing", path))
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %s", path, err))
+		case got.Bytes < expected.Bytes:
+			problems = append(problems, fmt.Sprintf("%s: truncated. expected %d bytes (%d records), got %d bytes (%d records)", path, expected.Bytes, expected.Records, got.Bytes, got.Records))
+		case got != expected:
+			problems = append(problems, fmt.Sprintf("%s: checksum mismatch. expected %d records, got %d records", path, expected.Records, got.Records))
+		}
+	}
+
+	for _, dir := range manifestDirs {
+		entries, err := os.ReadDir(c.livedir + "/" + dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			problems = append(problems, fmt.Sprintf("%s: %s", dir, err))
+		}
+		for _, entry := range entries {
+			path := dir + "/" + entry.Name()
+			if entry.IsDir() || !isDay(entry.Name()) {
+				continue
+			}
+			if _, exists := manifest[path]; !exists {
+				problems = append(problems, fmt.Sprintf("%s: not in manifest", path))
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+func (c *Collector) flushManifest() {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	if len(c.dirty) == 0 {
+		return
+	}
+	manifest, err := c.loadManifest()
+	if err != nil {
+		c.logError("flushManifest", err)
+		return
+	}
+	for path := range c.dirty {
+		entry, err := c.manifestEntry(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			delete(manifest, path)
+		case err != nil:
+			c.logError("flushManifest", err)
+			continue
+		default:
+			manifest[path] = entry
+		}
+		delete(c.dirty, path)
+	}
+
+	err = c.writeManifest(manifest)
+	if err != nil {
+		c.logError("flushManifest", err)
+	}
+}
+
+// Records every file in live/{quotes,maximums,edges} as it is now.  For data collected before manifests,
+// so only run it on data already known to be good.
+func (c *Collector) RebuildManifest() error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifest := map[string]manifestEntry{}
+	for _, dir := range manifestDirs {
+		entries, err := os.ReadDir(c.livedir + "/" + dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !isDay(entry.Name()) {
+				continue
+			}
+			path := dir + "/" + entry.Name()
+			manifest[path], err = c.manifestEntry(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	c.dirty = map[string]bool{}
+	return c.writeManifest(manifest)
+}
+
+func (c *Collector) writeManifest(manifest map[string]manifestEntry) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Concurrent readers must never see half a manifest.
+	return funcs.AtomicWriteFile(c.livedir, MANIFEST, data)
+}
+
+func (c *Collector) loadManifest() (map[string]manifestEntry, error) {
+	manifest := map[string]manifestEntry{}
+	data, err := os.ReadFile(c.livedir + "/" + MANIFEST)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return manifest, fmt.Errorf("%s: %s", MANIFEST, err)
+	}
+	return manifest, nil
+}
+
+// path is relative to livedir, e.g. "quotes/20150129".
+func (c *Collector) manifestEntry(path string) (manifestEntry, error) {
+	// Quotes may have been archived.
+	dir, day := filepath.Split(path)
+	data, err := readDay(c.livedir+"/"+strings.TrimSuffix(dir, "/"), day)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+	records := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			records += 1
+		}
+	}
+	sum := sha256.Sum256(data)
+	return manifestEntry{Sha256: hex.EncodeToString(sum[:]), Records: records, Bytes: int64(len(data))}, nil
+}
+
+func (c *Collector) markDirty(dir string, filename string) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	if c.dirty == nil {
+		c.dirty = map[string]bool{}
+	}
+	c.dirty[dir+"/"+filename] = true
+}
@@ -0,0 +1,94 @@
+package collector
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Collector_Verify(t *testing.T) {
+	days := []string{"20150129", "20150130", "20150202"}
+
+	c := New("test", t.TempDir(), int64(60))
+	writeTestLogs(c.logdir, days, []string{"AAPL", "GOOG"})
+	c.ProcessStream(days[0], days[2], false)
+
+	manifest, _ := c.loadManifest()
+	for _, path := range []string{"quotes/20150129", "quotes/20150130", "quotes/20150202", "maximums/20150130", "edges/20150130"} {
+		if manifest[path].Records == 0 || len(manifest[path].Sha256) != 64 {
+			t.Errorf("Expected manifest entry for %s, Got: %+v", path, manifest[path])
+		}
+	}
+	problems, err := c.Verify()
+	if err != nil || len(problems) != 0 {
+		t.Errorf("Expected clean verify, Got: %v, Err: %v", problems, err)
+	}
+
+	// Archived quotes still verify.
+	today, _ := time.Parse("20060102", "20150301")
+	c.Archive(today, RetentionConfig{ArchiveAfter: 1})
+	problems, _ = c.Verify()
+	if len(problems) != 0 {
+		t.Errorf("Expected clean verify after archive, Got: %v", problems)
+	}
+
+	// Quotes pruned by retention are gone on purpose, not missing.
+	pruned := New("test", t.TempDir(), int64(60))
+	funcs.CopyDir(c.rootdir, pruned.rootdir)
+	pruned.Archive(today, RetentionConfig{ArchiveAfter: 1, QuotePruneAfter: 1})
+	if _, err := os.Stat(pruned.livedir + "/quotes/" + ARCHIVE_DIR + "/201501.zip"); !os.IsNotExist(err) {
+		t.Errorf("Expected 201501.zip pruned, Got: %v", err)
+	}
+	problems, err = pruned.Verify()
+	if err != nil || len(problems) != 0 {
+		t.Errorf("Expected clean verify after prune, Got: %v, Err: %v", problems, err)
+	}
+
+	// Truncate, edit, delete and add files behind the manifest's back.
+	data, _ := os.ReadFile(c.livedir + "/maximums/20150130")
+	os.WriteFile(c.livedir+"/maximums/20150130", data[:len(data)/2], 0777)
+	data, _ = os.ReadFile(c.livedir + "/edges/20150130")
+	os.WriteFile(c.livedir+"/edges/20150130", []byte(strings.Replace(string(data), "1", "2", 1)), 0777)
+	os.Remove(c.livedir + "/quotes/" + ARCHIVE_DIR + "/201502.zip")
+	os.WriteFile(c.livedir+"/quotes/20150203", []byte("hand made\n"), 0777)
+
+	problems, err = c.Verify()
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	expected := []string{"edges/20150130: checksum mismatch", "maximums/20150130: truncated", "quotes/20150202: ", "quotes/20150203: not in manifest"}
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, Got: %v", len(expected), problems)
+	}
+	for idx, problem := range problems {
+		if !strings.HasPrefix(problem, expected[idx]) {
+			t.Errorf("Expected: %s..., Got: %s", expected[idx], problem)
+		}
+	}
+
+	// Reprocessing rewrites the manifest.
+	os.Remove(c.livedir + "/quotes/20150203")
+	c.ProcessStream(days[0], days[2], false)
+	problems, _ = c.Verify()
+	if len(problems) != 0 {
+		t.Errorf("Expected clean verify after reprocessing, Got: %v", problems)
+	}
+
+	// Collected before manifests.
+	os.Remove(c.livedir + "/" + MANIFEST)
+	_, err = c.Verify()
+	if err != ErrNoManifest {
+		t.Errorf("Expected: %s, Got: %v", ErrNoManifest, err)
+	}
+	err = c.RebuildManifest()
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	problems, err = c.Verify()
+	if err != nil || len(problems) != 0 {
+		t.Errorf("Expected clean verify after rebuild, Got: %v, Err: %v", problems, err)
+	}
+}
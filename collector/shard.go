@@ -0,0 +1,174 @@
+package collector
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Targets and maximums only ever look at one underlying at a time, so ProcessStream() can split a day
+// by underlying and hand each shard to its own goroutine.  Every shard still sees every log timestamp
+// so targets and maximums cycle exactly when they would have sequentially.  Anything a shard would have
+// written to disk is held in shardWrites and merged back in sequential order once all shards are done.
+
+type shardWrites struct {
+	cycle  int                                     // How many times maybeCycleTargets() has been called.
+	quotes []shardQuotes                           // Promoted targets awaiting append to /quotes.
+	cycled map[string]map[string][]structs.Maximum // Expired maximums awaiting writeMaximums().
+}
+
+type shardQuotes struct {
+	cycle    int
+	symbol   string
+	yyyymmdd string
+	lines    string
+}
+
+func (w *shardWrites) appendQuotes(yyyymmdd string, symbol string, lines string) {
+	w.quotes = append(w.quotes, shardQuotes{cycle: w.cycle, symbol: symbol, yyyymmdd: yyyymmdd, lines: lines})
+}
+
+func (w *shardWrites) cycleMaximums(expiration string, maximums map[string][]structs.Maximum) {
+	if w.cycled[expiration] == nil {
+		w.cycled[expiration] = map[string][]structs.Maximum{}
+	}
+	for symbol, maxSlice := range maximums {
+		w.cycled[expiration][symbol] = append(w.cycled[expiration][symbol], maxSlice...)
+	}
+}
+
+func (c *Collector) newShard() *Collector {
+	s := &Collector{id: c.id, rootdir: c.rootdir, livedir: c.livedir, logdir: c.logdir, errordir: c.errordir}
+	s.maximums = map[string]map[string][]structs.Maximum{}
+	s.targets = map[string]map[string]target{"current": {}, "next": {}}
+	s.deferred = &shardWrites{cycled: map[string]map[string][]structs.Maximum{}}
+	return s
+}
+
+func (c *Collector) processShards(yyyymmdd string, groups []logGroup, currentTimestamp int64) int64 {
+	shards := []*Collector{}
+	for range c.Workers {
+		shards = append(shards, c.newShard())
+	}
+
+	// Hand out current state.
+	for expiration, maxes := range c.maximums {
+		for symbol, maxSlice := range maxes {
+			if len(maxSlice) == 0 {
+				continue
+			}
+			s := shards[shardFor(maxSlice[0].Underlying, c.Workers)]
+			if s.maximums[expiration] == nil {
+				s.maximums[expiration] = map[string][]structs.Maximum{}
+			}
+			s.maximums[expiration][symbol] = maxSlice
+		}
+	}
+	for _type, symboldata := range c.targets {
+		for symbol, t := range symboldata {
+			shards[shardFor(symbol, c.Workers)].targets[_type][symbol] = t
+		}
+	}
+
+	// Every shard gets every timestamp, but only lines for its own underlyings.
+	// Unparseable lines go to the first shard so they are still logged once.
+	shardGroups := make([][]logGroup, c.Workers)
+	for _, g := range groups {
+		for i := range shardGroups {
+			shardGroups[i] = append(shardGroups[i], logGroup{timestamp: g.timestamp})
+		}
+		for _, line := range g.lines {
+			underlying := strings.SplitN(line.encodedEquity, ",", 2)[0]
+			i := shardFor(underlying, c.Workers)
+			if line._type == "" {
+				i = 0
+			}
+			last := len(shardGroups[i]) - 1
+			shardGroups[i][last].lines = append(shardGroups[i][last].lines, line)
+		}
+	}
+
+	// Shards all see the same timestamps so they all finish on the same one.
+	lastTimestamps := make([]int64, c.Workers)
+	var wg sync.WaitGroup
+	for i, s := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lastTimestamps[i] = s.processGroups(yyyymmdd, shardGroups[i], currentTimestamp)
+		}()
+	}
+	wg.Wait()
+
+	// Merge quotes in the order they would have been promoted sequentially.
+	quotes := []shardQuotes{}
+	for _, s := range shards {
+		quotes = append(quotes, s.deferred.quotes...)
+	}
+	sort.SliceStable(quotes, func(i, j int) bool {
+		if quotes[i].cycle == quotes[j].cycle {
+			return quotes[i].symbol < quotes[j].symbol
+		}
+		return quotes[i].cycle < quotes[j].cycle
+	})
+	for _, q := range quotes {
+		funcs.LazyAppendFile(c.livedir+"/quotes", q.yyyymmdd, q.lines)
+		c.markDirty("quotes", q.yyyymmdd)
+	}
+
+	// Merge maximums.
+	c.maximums = map[string]map[string][]structs.Maximum{}
+	cycled := map[string]map[string][]structs.Maximum{}
+	for _, s := range shards {
+		for expiration, maxes := range s.maximums {
+			if c.maximums[expiration] == nil {
+				c.maximums[expiration] = map[string][]structs.Maximum{}
+			}
+			for symbol, maxSlice := range maxes {
+				c.maximums[expiration][symbol] = maxSlice
+			}
+		}
+		for expiration, maxes := range s.deferred.cycled {
+			if cycled[expiration] == nil {
+				cycled[expiration] = map[string][]structs.Maximum{}
+			}
+			for symbol, maxSlice := range maxes {
+				cycled[expiration][symbol] = maxSlice
+			}
+		}
+	}
+	expirations := []string{}
+	for expiration := range cycled {
+		expirations = append(expirations, expiration)
+	}
+	sort.Strings(expirations)
+	for _, expiration := range expirations {
+		err := c.writeMaximums(expiration, cycled[expiration])
+		if err != nil {
+			// Put them back so next cycle can try again.
+			c.logError("processShards", err)
+			c.maximums[expiration] = cycled[expiration]
+		}
+	}
+
+	// Targets are reset daily, but keep them consistent with the sequential run anyway.
+	for _, s := range shards {
+		for _type, symboldata := range s.targets {
+			for symbol, t := range symboldata {
+				c.targets[_type][symbol] = t
+			}
+		}
+	}
+
+	return lastTimestamps[0]
+}
+
+func shardFor(underlying string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(underlying))
+	return int(h.Sum32() % uint32(shards))
+}
@@ -0,0 +1,45 @@
+package destiny
+
+import (
+	"github.com/eliwjones/thebox/util/structs"
+)
+
+// Why an edge did not turn into a ProtoOrder.
+const (
+	NO_MATCH        = "no match"                // No quote of the right type could be scored.
+	MULTIPLIER_DIFF = "multiplier diff too big" // Best quote's multiplier strays too far from the edge's.
+	ALREADY_SENT    = "already sent"            // Same symbol at same timestamp went out already.
+	MAX_PER_WEEK    = "max per week"            // Underlying hit its MaxPerWeek.
+	FEW_SAMPLES     = "too few samples"         // Model hasn't seen enough quotes like this one.
+	LOW_EV          = "expected value too low"  // Model says it isn't worth buying.
+)
+
+// One edge evaluation, accepted or not.  Appended to <dataDir>/<id>/destiny/decisions/<weekID>
+// as JSON lines and sent on Destiny's "decision" channel.
+type Decision struct {
+	Timestamp       int64           `json:"timestamp"`
+	Underlying      string          `json:"underlying"`
+	Strategy        string          `json:"strategy"`
+	Matcher         string          `json:"matcher,omitempty"`
+	Edge            structs.Maximum `json:"edge"`
+	Quote           structs.Option  `json:"quote"`          // Best match.  Empty if none.
+	Distance        float64         `json:"distance"`       // Matcher's distance from edge to Quote.
+	EdgeMultiplier  float64         `json:"edgeMultiplier"` // MaximumBid over edge's ask.
+	QuoteMultiplier float64         `json:"quoteMultiplier"`
+	MultiplierDiff  float64         `json:"multiplierDiff"`           // Fraction of EdgeMultiplier.
+	Samples         int             `json:"samples,omitempty"`        // Past maximums behind ExpectedValue.
+	HitProbability  float64         `json:"hitProbability,omitempty"` // Of reaching the target multiple.
+	ExpectedValue   float64         `json:"expectedValue,omitempty"`  // Profit per dollar.
+	Accepted        bool            `json:"accepted"`
+	Reason          string          `json:"reason,omitempty"` // Why not.
+	Detail          string          `json:"detail,omitempty"`
+
+	claimed bool // Matched to a ProtoOrder by Destiny.
+}
+
+// Hands decision to whoever is listening.  Strategies should call this for every edge they consider.
+func (p Pulse) Decide(decision Decision) {
+	if p.decide != nil {
+		p.decide(decision)
+	}
+}
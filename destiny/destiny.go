@@ -2,191 +2,386 @@ package destiny
 
 import (
 	"github.com/eliwjones/thebox/collector"
-	"github.com/eliwjones/thebox/util"
+	"github.com/eliwjones/thebox/dispatcher"
 	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/interfaces"
 	"github.com/eliwjones/thebox/util/structs"
 
+	"encoding/json"
 	"fmt"
-	"math"
 	"os"
-	"sort"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
 type Destiny struct {
-	collector      *collector.Collector
-	dataDir        string                      // top level dir for data.
-	edges          map[int64][]structs.Maximum // edges keyed by TimestampID().
-	edgeMultiplier float64
-	id             string // allows for namespacing and multiple simulation runs.
-	PoC            chan structs.ProtoOrder
-	Pulses         chan int64 // timestamps from pulsar come here.
-	PulsarReply    chan int64 // Reply back to Pulsar when done doing work.
-	underlying     string
-	weeksBack      int
-}
-
-func New(id string, dataDir string, underlying string, weeksBack int, edgeMultiplier float64, c *collector.Collector, poc chan structs.ProtoOrder) *Destiny {
-	d := &Destiny{id: id, dataDir: dataDir, edgeMultiplier: edgeMultiplier,
-		underlying: underlying, weeksBack: weeksBack}
+	adapter     interfaces.Adapter // Where to look up open positions.  Optional.
+	collector   *collector.Collector
+	dataDir     string                 // top level dir for data.
+	destinyDir  string                 // Where to save information pertaining to this instance of destiny.
+	dispatcher  *dispatcher.Dispatcher // My megaphone.
+	emitted     map[string]int         // Times each timestamp_symbol went out this week, so replayed pulses don't resend them.
+	id          string                 // allows for namespacing and multiple simulation runs.
+	next        int                    // Which underlying goes first next pulse.
+	PoC         chan structs.ProtoOrder
+	protoOrders []structs.ProtoOrder // Sent to PoC this week.
+	Pulses      chan int64           // timestamps from pulsar come here.
+	PulsarReply chan int64           // Reply back to Pulsar when done doing work.
+	rand        *funcs.Rand
+	strategy    string
+	underlyings []*underlying
+	weekID      int64
+}
+
+// An underlying to trade and how.
+type Underlying struct {
+	Symbol     string
+	Params     Params // Strategy params.  Unset ones fall back to the strategy's defaults.
+	MaxPerWeek int    // Most ProtoOrders to send per week.  0 for no limit.
+}
+
+type underlying struct {
+	Underlying
+	rand     *funcs.Rand
+	sent     int // ProtoOrders sent this week.
+	strategy Strategy
+}
+
+// Strategies with state worth keeping across restarts, e.g. which edges were chosen this week.
+type Stateful interface {
+	MarshalState() ([]byte, error)
+	UnmarshalState(data []byte) error
+}
+
+// What goes in <dataDir>/<id>/destiny/state.
+type destinyState struct {
+	Strategy    string                     `json:"strategy"`
+	Underlyings map[string]underlyingState `json:"underlyings"`
+	WeekID      int64                      `json:"weekId"`
+	Next        int                        `json:"next"`
+	ProtoOrders []structs.ProtoOrder       `json:"protoOrders"` // Sent this week.  Not sent again if pulses are replayed.
+}
+
+type underlyingState struct {
+	Params        Params          `json:"params"`
+	MaxPerWeek    int             `json:"maxPerWeek"`
+	Rand          []byte          `json:"rand"`          // funcs.Rand state.
+	StrategyState json.RawMessage `json:"strategyState"` // From Stateful strategies.
+}
+
+// Runs one strategy instance per underlying and sends their ProtoOrders to poc, taking turns.
+// r is forked for each underlying's random choices.  nil for a randomly seeded one.
+// Picks up where a previous Destiny with the same id, strategy and underlyings left off.
+func New(id string, dataDir string, strategy string, underlyings []Underlying, r *funcs.Rand, c *collector.Collector, poc chan structs.ProtoOrder) (*Destiny, error) {
+	d := &Destiny{id: id, dataDir: dataDir, strategy: strategy}
 	d.collector = c
-	d.edges = map[int64][]structs.Maximum{}
+	if r == nil {
+		r = funcs.NewRand(funcs.RandomSeed())
+	}
+	d.rand = r
+	if len(underlyings) == 0 {
+		return nil, fmt.Errorf("no underlyings")
+	}
+
+	// Every underlying's strategy shares past edges so they are only loaded once a week.
+	pastEdges := newEdgeCache(c, dataDir).pastEdges
+	for _, u := range underlyings {
+		for _, existing := range d.underlyings {
+			if existing.Symbol == u.Symbol {
+				return nil, fmt.Errorf("underlying %s given twice", u.Symbol)
+			}
+		}
+		params, err := StrategyParams(strategy, u.Params)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", u.Symbol, err)
+		}
+		u.Params = params
+		du := &underlying{Underlying: u, rand: r.Fork()}
+		env := Env{Collector: c, DataDir: dataDir, ID: id, PastEdges: pastEdges, Rand: du.rand, Underlying: u.Symbol}
+		du.strategy, err = NewStrategy(strategy, env, params)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", u.Symbol, err)
+		}
+		d.underlyings = append(d.underlyings, du)
+	}
+
+	d.dispatcher = dispatcher.New(1000)
+	d.emitted = map[string]int{}
 	d.PoC = poc
 	d.Pulses = make(chan int64, 1000)
 	d.PulsarReply = make(chan int64, 1000)
+	d.destinyDir = fmt.Sprintf("%s/%s/destiny", d.dataDir, d.id)
+
+	serializedState, err := os.ReadFile(d.destinyDir + "/state")
+	if err == nil {
+		err = d.deserializeState(serializedState)
+		if err != nil {
+			fmt.Printf("[Destiny] Ignoring saved state: %s\n", err)
+		}
+	}
 
 	go d.processPulses()
 
-	return d
+	return d, nil
+}
+
+// "AAPL;GOOG:weeks_back=4,max_per_week=10" -> AAPL with defaults, GOOG with its own weeks_back and limit.
+// max_per_week belongs to Destiny.  Everything else is a strategy param layered over defaults.
+func ParseUnderlyings(s string, defaults Params) ([]Underlying, error) {
+	underlyings := []Underlying{}
+	for _, spec := range strings.Split(s, ";") {
+		symbol, paramString, _ := strings.Cut(spec, ":")
+		u := Underlying{Symbol: strings.TrimSpace(symbol), Params: Params{}}
+		if u.Symbol == "" {
+			return nil, fmt.Errorf("missing symbol in: '%s'", spec)
+		}
+		params, err := ParseParams(paramString)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", u.Symbol, err)
+		}
+		for k, v := range defaults {
+			u.Params[k] = v
+		}
+		for k, v := range params {
+			if k != "max_per_week" {
+				u.Params[k] = v
+				continue
+			}
+			u.MaxPerWeek, err = strconv.Atoi(v)
+			if err != nil || u.MaxPerWeek < 0 {
+				return nil, fmt.Errorf("%s: max_per_week must be a whole number, got: '%s'", u.Symbol, v)
+			}
+		}
+		underlyings = append(underlyings, u)
+	}
+	return underlyings, nil
+}
+
+// Sends Decisions on id "decision" to subscriber.
+func (d *Destiny) Subscribe(id string, whoami string, subscriber chan any, wait bool) {
+	d.dispatcher.Subscribe(id, whoami, subscriber, wait)
+}
+
+// Lets the strategies see open positions.  Must be called before the first pulse.
+func (d *Destiny) WatchPositions(a interfaces.Adapter) {
+	d.adapter = a
 }
 
 func (d *Destiny) processPulses() {
-	currentWeekID := int64(0)
 	for timestamp := range d.Pulses {
 		if timestamp == -1 {
 			// Serialize state in preparation for shutdown.
+			serializedState, err := d.serializeState()
+			if err == nil {
+				err = funcs.AtomicWriteFile(d.destinyDir, "state", serializedState)
+			}
+			if err != nil {
+				fmt.Printf("[Destiny] Failed to save state: %s\n", err)
+			}
 			d.PulsarReply <- timestamp
 			return
 		}
-		// if Change week, load new edges.
 		weekID := funcs.WeekID(timestamp)
-		if currentWeekID != weekID {
-			d.populateEdges(timestamp)
-			currentWeekID = weekID
+		if d.weekID != weekID {
+			d.emitted = map[string]int{}
+			d.protoOrders = []structs.ProtoOrder{}
+			for _, u := range d.underlyings {
+				u.sent = 0
+			}
+			d.weekID = weekID
 		}
 
-		quotes, _ := d.collector.GetQuotes(timestamp, d.underlying)
-
-		//Grind into ProtoOrders to send to Trader.
-		for _, edge := range d.edges[funcs.TimestampID(timestamp)] {
-			// Grind into Order.  Send to Trader.
-			// Find quote nearest to edge.
-
-			edgePremiumPct := funcs.PremiumPct(edge.OptionAsk, edge.Strike, float64(2.2))
-			nearestPremiumPct := float64(1)
-			matchOption := structs.Option{}
-			for _, quote := range quotes {
-				if quote.Type != edge.OptionType {
-					continue
-				}
-				premiumPct := funcs.PremiumPct(quote.Ask, quote.Strike, float64(2.2))
-				if math.Abs(premiumPct-edgePremiumPct) < math.Abs(nearestPremiumPct-edgePremiumPct) {
-					matchOption = quote
-					nearestPremiumPct = premiumPct
-				}
+		positions := map[string]structs.Position{}
+		if d.adapter != nil {
+			current, err := d.adapter.GetPositions()
+			if err != nil {
+				fmt.Printf("[%d] GetPositions: %s\n", timestamp, err)
+			}
+			for id, p := range current {
+				positions[id] = p
+			}
+		}
 
+		proposals := [][]structs.ProtoOrder{}
+		decisions := []Decision{}
+		for _, u := range d.underlyings {
+			pulse := Pulse{Timestamp: timestamp, Underlying: u.Symbol, Positions: positions}
+			pulse.Quotes, _ = d.collector.GetQuotes(timestamp, u.Symbol)
+			pulse.Stock, _ = d.collector.GetStock(timestamp, u.Symbol)
+			pulse.decide = func(decision Decision) {
+				decision.Strategy = d.strategy
+				decisions = append(decisions, decision)
 			}
-			if matchOption.Symbol == "" {
-				fmt.Printf("[%d] Empty matchOption, continuing.\n", timestamp)
+			proposals = append(proposals, u.strategy.OnPulse(pulse))
+		}
+
+		// Send to ProtoOrder Channel.
+		proposed := map[string]int{}
+		for _, idx := range d.roundRobin(proposals) {
+			u := d.underlyings[idx[0]]
+			po := proposals[idx[0]][idx[1]]
+			decision := d.decisionFor(&decisions, po)
+			// Counted, since two edges can pick the same option on one pulse.
+			key := fmt.Sprintf("%d_%s", po.Timestamp, po.Symbol)
+			proposed[key] += 1
+			if proposed[key] <= d.emitted[key] {
+				decision.Accepted, decision.Reason = false, ALREADY_SENT
 				continue
 			}
-
-			// Determine if matchOption could return "close enough" multiplier.
-			// This is completely naive method.
-			// Could block if not close enough, OR could simply submit order with Min(edge.Ask, matchOption.Ask)
-
-			edgeMultiplier := funcs.Multiplier(edge.MaximumBid, edge.OptionAsk, 2.2)
-			matchOptionMultiplier := funcs.Multiplier(edge.MaximumBid, matchOption.Ask, 2.2)
-			multiplierDiff := math.Abs(edgeMultiplier - matchOptionMultiplier)
-			// Want multiplier to be within 10% of edge Multiplier.
-			// If it is too far away, then I'm in uncharted territory that would require more thought.
-			if multiplierDiff/edgeMultiplier > float64(0.1) {
-				fmt.Printf("Multiplier Diff Too Big: %.4f\n", multiplierDiff)
+			if u.MaxPerWeek > 0 && u.sent >= u.MaxPerWeek {
+				decision.Accepted, decision.Reason = false, MAX_PER_WEEK
+				decision.Detail = fmt.Sprintf("sent %d", u.sent)
 				continue
 			}
-
-			// Seconds to Max
-			secondsToMax := edge.MaxTimestamp - edge.Timestamp
-
-			// Construct PO.
-			po := structs.ProtoOrder{}
-			po.Timestamp = timestamp
-			po.Symbol = matchOption.Symbol
-			po.LimitOpen = matchOption.Ask
-			po.LimitTS = timestamp + secondsToMax
-			po.Type = util.OPTION
-			po.Underlying = d.underlying
-
-			// Send to ProtoOrder Channel.
+			d.emitted[key] += 1
+			u.sent += 1
+			d.protoOrders = append(d.protoOrders, po)
 			d.PoC <- po
 		}
+		d.publish(decisions)
 
 		// Done doing my thing.  Send
 		d.PulsarReply <- timestamp
 	}
 }
 
-func (d *Destiny) populateEdges(timestamp int64) {
-	edges := []structs.Maximum{}
+// Accepted decision that produced po, so its outcome can be updated.
+// Strategies that don't record decisions get a bare one.
+func (d *Destiny) decisionFor(decisions *[]Decision, po structs.ProtoOrder) *Decision {
+	for idx := range *decisions {
+		decision := &(*decisions)[idx]
+		if decision.Accepted && !decision.claimed && decision.Timestamp == po.Timestamp && decision.Quote.Symbol == po.Symbol {
+			decision.claimed = true
+			return decision
+		}
+	}
+	*decisions = append(*decisions, Decision{Timestamp: po.Timestamp, Underlying: po.Underlying, Strategy: d.strategy,
+		Quote: structs.Option{Symbol: po.Symbol, Ask: po.LimitOpen}, Accepted: true, claimed: true})
+	return &(*decisions)[len(*decisions)-1]
+}
 
-	filename := fmt.Sprintf("%d", funcs.WeekID(timestamp))
-	path := fmt.Sprintf("%s/destiny/%02d_week_edges", d.dataDir, d.weeksBack)
-	edgeData, err := os.ReadFile(path + "/" + filename)
+// Only restores state saved by the same strategy with the same underlyings and params.  Anything else would be someone else's plan.
+func (d *Destiny) deserializeState(state []byte) error {
+	ds := destinyState{}
+	err := json.Unmarshal(state, &ds)
 	if err != nil {
-		// Not found, load from collector and persist.
-		fmt.Printf("[populateEdges] %s\n", err)
-		edges = d.collector.GetPastNEdges(timestamp, d.weeksBack)
-
-		// Encode and save for future reference.
-		encodedEdges, _ := d.collector.SerializeMaximums(edges)
-		err = funcs.LazyWriteFile(path, filename, []byte(encodedEdges))
+		return err
+	}
+	if ds.Strategy != d.strategy || len(ds.Underlyings) != len(d.underlyings) {
+		return fmt.Errorf("saved by %s for %d underlyings, now running %s for %d", ds.Strategy, len(ds.Underlyings), d.strategy, len(d.underlyings))
+	}
+	for _, u := range d.underlyings {
+		us, exists := ds.Underlyings[u.Symbol]
+		if !exists || !reflect.DeepEqual(us.Params, u.Params) || us.MaxPerWeek != u.MaxPerWeek {
+			return fmt.Errorf("%s changed. saved: %s max_per_week=%d, now: %s max_per_week=%d", u.Symbol, us.Params, us.MaxPerWeek, u.Params, u.MaxPerWeek)
+		}
+	}
+	rands := make([]funcs.Rand, len(d.underlyings))
+	for idx, u := range d.underlyings {
+		err = rands[idx].UnmarshalBinary(ds.Underlyings[u.Symbol].Rand)
 		if err != nil {
-			message := fmt.Sprintf("Failed to write encodedEdges. Err: %s", err)
-			panic(message)
+			return fmt.Errorf("%s: %s", u.Symbol, err)
+		}
+	}
+	// Strategies can only be checked by loading them, so put back what they had if any fails.
+	previous := make([][]byte, len(d.underlyings))
+	for idx, u := range d.underlyings {
+		us := ds.Underlyings[u.Symbol]
+		s, ok := u.strategy.(Stateful)
+		if !ok || len(us.StrategyState) == 0 {
+			continue
+		}
+		previous[idx], err = s.MarshalState()
+		if err == nil {
+			err = s.UnmarshalState(us.StrategyState)
 		}
-	} else {
-		// Got edgeData, decode into []structs.Maximum
-		edges, err = d.collector.DeserializeMaximums(string(edgeData))
 		if err != nil {
-			panic("Someone broke something with DeserializeMaximums or SerializeMaximums.")
+			for undo := range idx {
+				if previous[undo] != nil {
+					d.underlyings[undo].strategy.(Stateful).UnmarshalState(previous[undo])
+				}
+			}
+			return fmt.Errorf("%s: %s", u.Symbol, err)
 		}
 	}
-
-	// Filter out unwanted symbols.
-	edges = filterEdgesByUnderlying(edges, d.underlying)
-
-	// Choose 30 Edges.
-	bag := funcs.ChooseMFromN(30, len(edges))
-	d.edges = map[int64][]structs.Maximum{}
-	for _, index := range bag {
-		edge := edges[index]
-		timestampID := funcs.TimestampID(edge.Timestamp)
-		d.edges[timestampID] = append(d.edges[timestampID], edge)
+	for idx, u := range d.underlyings {
+		// Same pointer the strategy holds, so it carries on from here too.
+		*u.rand = rands[idx]
 	}
-	// Limit to multipliers of interest. Also, has effect of removing gaps.
-	for timestampID := range d.edges {
-		d.edges[timestampID] = filterEdgesByMultiplier(d.edges[timestampID], d.edgeMultiplier)
+	d.weekID = ds.WeekID
+	d.next = ds.Next
+	d.protoOrders = ds.ProtoOrders
+	for _, po := range d.protoOrders {
+		d.emitted[fmt.Sprintf("%d_%s", po.Timestamp, po.Symbol)] += 1
+		for _, u := range d.underlyings {
+			if u.Symbol == po.Underlying {
+				u.sent += 1
+			}
+		}
 	}
+	return nil
+}
 
-	// Save d.edges to disk so can compare to actual constructed "orders"?
-	toBeSerialized := []structs.Maximum{}
-	for _, edges := range d.edges {
-		toBeSerialized = append(toBeSerialized, edges...)
+// Appends decisions to this week's decisions file and sends them to "decision" subscribers.
+func (d *Destiny) publish(decisions []Decision) {
+	if len(decisions) == 0 {
+		return
+	}
+	lines := []string{}
+	for _, decision := range decisions {
+		line, err := json.Marshal(decision)
+		if err != nil {
+			fmt.Printf("[Destiny] Failed to encode decision: %s\n", err)
+			continue
+		}
+		lines = append(lines, string(line))
+		d.dispatcher.Send(decision, "decision")
+	}
+	err := funcs.LazyAppendFile(d.destinyDir+"/decisions", fmt.Sprintf("%d", d.weekID), strings.Join(lines, "\n"))
+	if err != nil {
+		fmt.Printf("[Destiny] Failed to write decisions: %s\n", err)
 	}
-	sort.Sort(collector.ByTimestampID(toBeSerialized))
-	encodedEdges, _ := d.collector.SerializeMaximums(toBeSerialized)
-	path = fmt.Sprintf("%s/%s/destiny/chosen_edges", d.dataDir, d.id)
-	funcs.LazyWriteFile(path, filename, []byte(encodedEdges))
 }
 
-func filterEdgesByMultiplier(edges []structs.Maximum, multiplier float64) []structs.Maximum {
-	filteredEdges := []structs.Maximum{}
-	for _, edge := range edges {
-		edgeMultiple := float64(edge.MaximumBid) / (float64(edge.OptionAsk) + float64(2.2))
-		if edgeMultiple < multiplier {
-			continue
+// Order to send proposals in: one from each underlying in turn, starting with a different
+// underlying each pulse so nobody always gets first dibs on allotments.  Returns [underlying, proposal] pairs.
+func (d *Destiny) roundRobin(proposals [][]structs.ProtoOrder) [][2]int {
+	order := [][2]int{}
+	start := d.next % len(proposals)
+	d.next = (start + 1) % len(proposals)
+	for round := 0; ; round++ {
+		added := false
+		for offset := range proposals {
+			idx := (start + offset) % len(proposals)
+			if round < len(proposals[idx]) {
+				order = append(order, [2]int{idx, round})
+				added = true
+			}
+		}
+		if !added {
+			return order
 		}
-		filteredEdges = append(filteredEdges, edge)
 	}
-	return filteredEdges
 }
 
-func filterEdgesByUnderlying(edges []structs.Maximum, underlying string) []structs.Maximum {
-	filteredEdges := []structs.Maximum{}
-	for _, edge := range edges {
-		if edge.Underlying != underlying {
-			continue
+func (d *Destiny) serializeState() ([]byte, error) {
+	ds := destinyState{Strategy: d.strategy, WeekID: d.weekID, Next: d.next, ProtoOrders: d.protoOrders}
+	ds.Underlyings = map[string]underlyingState{}
+	for _, u := range d.underlyings {
+		us := underlyingState{Params: u.Params, MaxPerWeek: u.MaxPerWeek}
+		var err error
+		us.Rand, err = u.rand.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := u.strategy.(Stateful); ok {
+			us.StrategyState, err = s.MarshalState()
+			if err != nil {
+				return nil, err
+			}
 		}
-		filteredEdges = append(filteredEdges, edge)
+		ds.Underlyings[u.Symbol] = us
 	}
-	return filteredEdges
+	return json.Marshal(ds)
 }
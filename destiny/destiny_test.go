@@ -1,8 +1,16 @@
 package destiny
 
 import (
+	"github.com/eliwjones/thebox/adapter/simulate"
+	"github.com/eliwjones/thebox/collector"
+	"github.com/eliwjones/thebox/util/funcs"
 	"github.com/eliwjones/thebox/util/structs"
 
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -46,3 +54,282 @@ func Test_Destiny_filterEdgesByUnderlying(t *testing.T) {
 		}
 	}
 }
+
+func Test_Destiny_processPulses(t *testing.T) {
+	c := collector.New("test", t.TempDir(), int64(60))
+	a := simulate.New("simulate", "simulation", 100000)
+	a.Positions["p1"] = structs.Position{Id: "p1"}
+	poc := make(chan structs.ProtoOrder, 10)
+
+	d, err := New("test", t.TempDir(), "echo", []Underlying{{Symbol: "AAPL", Params: Params{"size": "3"}}}, nil, c, poc)
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	d.WatchPositions(a)
+	if lastEcho.env.ID != "test" || lastEcho.env.Underlying != "AAPL" || lastEcho.params.Int("size") != 3 || lastEcho.params.Float("rate") != 0.5 {
+		t.Errorf("Unexpected env: %+v, params: %v", lastEcho.env, lastEcho.params)
+	}
+
+	d.Pulses <- 1422540000
+	<-d.PulsarReply
+	d.Pulses <- -1
+	<-d.PulsarReply
+
+	po := <-poc
+	if po.Symbol != "AAPL_ECHO0" || po.Timestamp != 1422540000 || po.Underlying != "AAPL" || len(poc) != 2 {
+		t.Errorf("Unexpected ProtoOrder: %+v", po)
+	}
+	if len(lastEcho.pulses) != 1 || lastEcho.pulses[0].Positions["p1"].Id != "p1" {
+		t.Errorf("Expected one pulse with position p1, Got: %+v", lastEcho.pulses)
+	}
+
+	_, err = New("test", t.TempDir(), "nope", []Underlying{{Symbol: "AAPL"}}, nil, c, poc)
+	if err == nil {
+		t.Errorf("Expected err for unknown strategy.")
+	}
+}
+
+func Test_Destiny_multipleUnderlyings(t *testing.T) {
+	c := collector.New("test", t.TempDir(), int64(60))
+	poc := make(chan structs.ProtoOrder, 100)
+	underlyings, err := ParseUnderlyings("AAPL;GOOG:size=3;BABA:size=2,max_per_week=3", Params{"size": "1"})
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	d, err := New("test", t.TempDir(), "echo", underlyings, nil, c, poc)
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+
+	for _, pulse := range []int64{1422540000, 1422540060, -1} {
+		d.Pulses <- pulse
+		<-d.PulsarReply
+	}
+	close(poc)
+	got := []string{}
+	for po := range poc {
+		got = append(got, po.Symbol)
+	}
+	// Take turns, starting with the next underlying each pulse.  BABA runs out after 3.
+	expected := []string{
+		"AAPL_ECHO0", "GOOG_ECHO0", "BABA_ECHO0", "GOOG_ECHO1", "BABA_ECHO1", "GOOG_ECHO2",
+		"GOOG_ECHO0", "BABA_ECHO0", "AAPL_ECHO0", "GOOG_ECHO1", "GOOG_ECHO2",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %v\nGot: %v", expected, got)
+	}
+
+	for _, bad := range []string{"AAPL;", "AAPL:max_per_week=-1", "AAPL:max_per_week=x"} {
+		_, err = ParseUnderlyings(bad, Params{})
+		if err == nil {
+			t.Errorf("Expected err for: %s", bad)
+		}
+	}
+	_, err = New("test", t.TempDir(), "echo", []Underlying{{Symbol: "AAPL"}, {Symbol: "AAPL"}}, nil, c, poc)
+	if err == nil {
+		t.Errorf("Expected err for duplicate underlying.")
+	}
+	_, err = New("test", t.TempDir(), "echo", []Underlying{{Symbol: "AAPL", Params: Params{"size": "x"}}}, nil, c, poc)
+	if err == nil {
+		t.Errorf("Expected err for bad params.")
+	}
+}
+
+func Test_edgeCache(t *testing.T) {
+	dataDir := t.TempDir()
+	c := collector.New("test", t.TempDir(), int64(60))
+	week := weekOfEdges()
+	timestamp := week[0].Timestamp + 7*24*60*60
+	encoded, _ := c.SerializeMaximums(week)
+	path := dataDir + "/destiny/08_week_edges"
+	funcs.AtomicWriteFile(path, fmt.Sprintf("%d", funcs.WeekID(timestamp)), []byte(encoded))
+
+	ec := newEdgeCache(c, dataDir)
+	if len(ec.pastEdges(timestamp, 8)) != len(week) {
+		t.Fatalf("Expected %d edges.", len(week))
+	}
+	// Second underlying doesn't go back to disk.
+	os.RemoveAll(path)
+	if len(ec.pastEdges(timestamp+60, 8)) != len(week) {
+		t.Errorf("Expected cached edges.")
+	}
+	// Next week drops this week.
+	ec.pastEdges(timestamp+7*24*60*60, 8)
+	if len(ec.loaded) != 1 {
+		t.Errorf("Expected only next week loaded, Got: %d", len(ec.loaded))
+	}
+}
+
+func Test_Destiny_matchEdge(t *testing.T) {
+	edge := structs.Maximum{OptionType: "c", Strike: 10000, OptionAsk: 200, MaximumBid: 400}
+	quotes := []structs.Option{
+		{Symbol: "A", Type: "p", Strike: 10000, Ask: 200},
+		{Symbol: "B", Type: "c", Strike: 10000, Ask: 300},
+		{Symbol: "C", Type: "c", Strike: 10500, Ask: 205},
+	}
+	decision := matchEdge("premium", edge, Pulse{Quotes: quotes}, 0.1)
+	if !decision.Accepted || decision.Quote.Symbol != "C" {
+		t.Errorf("Expected C, Got: %s, Reason: %s", decision.Quote.Symbol, decision.Reason)
+	}
+
+	// Only B left, and it costs too much to reach the same multiplier.
+	decision = matchEdge("premium", edge, Pulse{Quotes: quotes[:2]}, 0.1)
+	if decision.Accepted || decision.Reason != MULTIPLIER_DIFF {
+		t.Errorf("Expected %s, Got: %+v", MULTIPLIER_DIFF, decision)
+	}
+	decision = matchEdge("premium", edge, Pulse{Quotes: quotes[:2]}, 1.0)
+	if !decision.Accepted {
+		t.Errorf("Did not expect rejection with loose tolerance: %+v", decision)
+	}
+	decision = matchEdge("premium", edge, Pulse{Quotes: quotes[:1]}, 1.0)
+	if decision.Accepted || decision.Reason != NO_MATCH {
+		t.Errorf("Expected %s with no calls, Got: %+v", NO_MATCH, decision)
+	}
+}
+
+func Test_Destiny_state(t *testing.T) {
+	dataDir := t.TempDir()
+	c := collector.New("test", t.TempDir(), int64(60))
+	week := weekOfEdges()
+	timestamp := week[0].Timestamp + 7*24*60*60
+	encoded, _ := c.SerializeMaximums(week)
+	funcs.AtomicWriteFile(dataDir+"/destiny/08_week_edges", fmt.Sprintf("%d", funcs.WeekID(timestamp)), []byte(encoded))
+
+	run := func(params Params, seed uint64, pulses ...int64) (*Destiny, []structs.ProtoOrder) {
+		poc := make(chan structs.ProtoOrder, 100)
+		d, err := New("test", dataDir, "edges", []Underlying{{Symbol: "AAPL", Params: params}}, funcs.NewRand(seed), c, poc)
+		if err != nil {
+			t.Fatalf("Did not expect err: %s", err)
+		}
+		for _, pulse := range append(pulses, -1) {
+			d.Pulses <- pulse
+			<-d.PulsarReply
+		}
+		close(poc)
+		pos := []structs.ProtoOrder{}
+		for po := range poc {
+			pos = append(pos, po)
+		}
+		return d, pos
+	}
+
+	d1, _ := run(Params{"sample": "10"}, 5, timestamp)
+	chosen := d1.underlyings[0].strategy.(*edges).edges
+	if len(chosen) == 0 {
+		t.Fatalf("Expected chosen edges.")
+	}
+
+	// Restart with a different seed picks up the same edges and random stream.
+	d2, _ := run(Params{"sample": "10"}, 99)
+	u1, u2 := d1.underlyings[0], d2.underlyings[0]
+	if !reflect.DeepEqual(u2.strategy.(*edges).edges, chosen) || u2.strategy.(*edges).weekID != funcs.WeekID(timestamp) {
+		t.Errorf("Expected restored edges.")
+	}
+	if u1.rand.Uint64() != u2.rand.Uint64() || u2.rand.Seed != u1.rand.Seed {
+		t.Errorf("Expected restored rand.")
+	}
+
+	// Bad rand leaves the strategy as it was rather than half restored.
+	state, _ := os.ReadFile(d1.destinyDir + "/state")
+	ds := destinyState{}
+	json.Unmarshal(state, &ds)
+	us := ds.Underlyings["AAPL"]
+	us.Rand = us.Rand[:4]
+	ds.Underlyings["AAPL"] = us
+	state, _ = json.Marshal(ds)
+	d4, err := New("test", t.TempDir(), "edges", []Underlying{{Symbol: "AAPL", Params: Params{"sample": "10"}}}, funcs.NewRand(99), c, nil)
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	seed := d4.underlyings[0].rand.Seed
+	err = d4.deserializeState(state)
+	if err == nil || len(d4.underlyings[0].strategy.(*edges).edges) != 0 || d4.underlyings[0].rand.Seed != seed {
+		t.Errorf("Expected err and untouched state, Got: %v", err)
+	}
+
+	// Different params start fresh.
+	d3, _ := run(Params{"sample": "20"}, 99)
+	if len(d3.underlyings[0].strategy.(*edges).edges) != 0 || d3.underlyings[0].rand.Seed == u1.rand.Seed {
+		t.Errorf("Expected fresh state for different params.")
+	}
+}
+
+func Test_Destiny_state_protoOrders(t *testing.T) {
+	dataDir := t.TempDir()
+	c := collector.New("test", t.TempDir(), int64(60))
+	run := func(params Params, pulses ...int64) []structs.ProtoOrder {
+		poc := make(chan structs.ProtoOrder, 100)
+		d, _ := New("test", dataDir, "echo", []Underlying{{Symbol: "AAPL", Params: params}}, nil, c, poc)
+		for _, pulse := range append(pulses, -1) {
+			d.Pulses <- pulse
+			<-d.PulsarReply
+		}
+		close(poc)
+		pos := []structs.ProtoOrder{}
+		for po := range poc {
+			pos = append(pos, po)
+		}
+		return pos
+	}
+
+	if len(run(nil, 1422540000, 1422540060)) != 2 {
+		t.Fatalf("Expected 2 ProtoOrders.")
+	}
+	// Replayed pulses don't send the same ProtoOrders twice.
+	pos := run(nil, 1422540000, 1422540060, 1422540120)
+	if len(pos) != 1 || pos[0].Timestamp != 1422540120 {
+		t.Errorf("Expected only the new ProtoOrder, Got: %+v", pos)
+	}
+	// New week, clean slate.
+	if len(run(nil, 1422540000+7*24*60*60)) != 1 {
+		t.Errorf("Expected ProtoOrder for new week.")
+	}
+
+	// Same option picked twice on one pulse goes out twice, but still only once per replay.
+	same := Params{"size": "2", "mode": "same"}
+	if len(run(same, 1422540000)) != 2 {
+		t.Errorf("Expected both ProtoOrders for the same option.")
+	}
+	if pos = run(same, 1422540000, 1422540060); len(pos) != 2 || pos[0].Timestamp != 1422540060 {
+		t.Errorf("Expected only the new pulse's ProtoOrders, Got: %+v", pos)
+	}
+}
+
+func Test_Destiny_decisions(t *testing.T) {
+	dataDir := t.TempDir()
+	c := collector.New("test", t.TempDir(), int64(60))
+	poc := make(chan structs.ProtoOrder, 100)
+	d, err := New("test", dataDir, "echo", []Underlying{{Symbol: "AAPL", Params: Params{"size": "2"}, MaxPerWeek: 3}}, nil, c, poc)
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	subscriber := make(chan any, 100)
+	d.Subscribe("decision", "tester", subscriber, true)
+
+	for _, pulse := range []int64{1422540000, 1422540000, 1422540060} {
+		d.Pulses <- pulse
+		<-d.PulsarReply
+	}
+
+	expected := []string{"", "", ALREADY_SENT, ALREADY_SENT, "", MAX_PER_WEEK}
+	got := []string{}
+	for range expected {
+		decision := (<-subscriber).(Decision)
+		if decision.Strategy != "echo" || decision.Accepted != (decision.Reason == "") {
+			t.Errorf("Bad decision: %+v", decision)
+		}
+		got = append(got, decision.Reason)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %v\nGot: %v", expected, got)
+	}
+
+	data, err := os.ReadFile(fmt.Sprintf("%s/test/destiny/decisions/%d", dataDir, funcs.WeekID(1422540000)))
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(expected) || !strings.Contains(lines[5], `"reason":"max per week","detail":"sent 3"`) {
+		t.Errorf("Expected %d decisions ending in max per week, Got:\n%s", len(expected), data)
+	}
+}
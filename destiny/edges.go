@@ -0,0 +1,227 @@
+package destiny
+
+import (
+	"github.com/eliwjones/thebox/collector"
+	"github.com/eliwjones/thebox/util"
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Replays a sample of edges from the past weeks_back weeks, buying whatever option
+// currently looks most like each edge.  method is one of the samplers and matcher one of the matchers.
+func init() {
+	Register("edges", Params{"weeks_back": "8", "multiplier": "1.0", "tolerance": "0.1", "sample": "30", "method": "uniform", "matcher": "premium"}, newEdges)
+}
+
+type edges struct {
+	env            Env
+	edges          map[int64][]structs.Maximum // edges keyed by TimestampID().
+	edgeMultiplier float64                     // Ignore edges with smaller multipliers.
+	matcher        string                      // Key into matchers.
+	method         string                      // Key into samplers.
+	sample         int                         // How many edges to choose each week.
+	tolerance      float64                     // How far, as a fraction, a match's multiplier may stray from its edge's.
+	weekID         int64                       // Week edges were loaded for.
+	weeksBack      int
+}
+
+func newEdges(env Env, params Params) (Strategy, error) {
+	if params.Int("weeks_back") < 1 {
+		return nil, fmt.Errorf("weeks_back must be at least 1, got: %d", params.Int("weeks_back"))
+	}
+	if params.Int("sample") < 1 {
+		return nil, fmt.Errorf("sample must be at least 1, got: %d", params.Int("sample"))
+	}
+	if _, exists := samplers[params["method"]]; !exists {
+		return nil, fmt.Errorf("unknown method: '%s' choose from: %s", params["method"], strings.Join(sortedNames(samplers), ", "))
+	}
+	if _, exists := matchers[params["matcher"]]; !exists {
+		return nil, fmt.Errorf("unknown matcher: '%s' choose from: %s", params["matcher"], strings.Join(sortedNames(matchers), ", "))
+	}
+	e := &edges{env: env, edgeMultiplier: params.Float("multiplier"), tolerance: params.Float("tolerance"), weeksBack: params.Int("weeks_back")}
+	e.matcher = params["matcher"]
+	e.method = params["method"]
+	e.sample = params.Int("sample")
+	e.edges = map[int64][]structs.Maximum{}
+	if e.env.PastEdges == nil {
+		e.env.PastEdges = newEdgeCache(env.Collector, env.DataDir).pastEdges
+	}
+	return e, nil
+}
+
+// Chosen edges for the current week, so a restart mid-week doesn't resample them.
+type edgesState struct {
+	WeekID int64                       `json:"weekId"`
+	Edges  map[int64][]structs.Maximum `json:"edges"`
+}
+
+func (e *edges) MarshalState() ([]byte, error) {
+	return json.Marshal(edgesState{WeekID: e.weekID, Edges: e.edges})
+}
+
+func (e *edges) Name() string {
+	return "edges"
+}
+
+func (e *edges) OnPulse(pulse Pulse) []structs.ProtoOrder {
+	// if Change week, load new edges.
+	weekID := funcs.WeekID(pulse.Timestamp)
+	if e.weekID != weekID {
+		e.populateEdges(pulse.Timestamp)
+		e.weekID = weekID
+	}
+
+	//Grind into ProtoOrders to send to Trader.
+	pos := []structs.ProtoOrder{}
+	for _, edge := range e.edges[funcs.TimestampID(pulse.Timestamp)] {
+		decision := matchEdge(e.matcher, edge, pulse, e.tolerance)
+		pulse.Decide(decision)
+		if !decision.Accepted {
+			continue
+		}
+		matchOption := decision.Quote
+
+		// Seconds to Max
+		secondsToMax := edge.MaxTimestamp - edge.Timestamp
+
+		// Construct PO.
+		po := structs.ProtoOrder{}
+		po.Timestamp = pulse.Timestamp
+		po.Expiration = matchOption.Expiration
+		po.Symbol = matchOption.Symbol
+		po.LimitOpen = matchOption.Ask
+		po.LimitTS = pulse.Timestamp + secondsToMax
+		po.Type = util.OPTION
+		po.Underlying = pulse.Underlying
+
+		pos = append(pos, po)
+	}
+	return pos
+}
+
+func (e *edges) populateEdges(timestamp int64) {
+	c := e.env.Collector
+	filename := fmt.Sprintf("%d", funcs.WeekID(timestamp))
+	edges := e.env.PastEdges(timestamp, e.weeksBack)
+
+	// Filter out unwanted symbols.
+	edges = filterEdgesByUnderlying(edges, e.env.Underlying)
+
+	// Choose e.sample Edges.
+	e.edges = map[int64][]structs.Maximum{}
+	for _, edge := range samplers[e.method](e.env.Rand, edges, e.sample) {
+		timestampID := funcs.TimestampID(edge.Timestamp)
+		e.edges[timestampID] = append(e.edges[timestampID], edge)
+	}
+	// Limit to multipliers of interest. Also, has effect of removing gaps.
+	for timestampID := range e.edges {
+		e.edges[timestampID] = filterEdgesByMultiplier(e.edges[timestampID], e.edgeMultiplier)
+	}
+
+	// Save e.edges to disk so can compare to actual constructed "orders"?
+	toBeSerialized := []structs.Maximum{}
+	for _, edges := range e.edges {
+		toBeSerialized = append(toBeSerialized, edges...)
+	}
+	sort.Sort(collector.ByTimestampID(toBeSerialized))
+	encodedEdges, _ := c.SerializeMaximums(toBeSerialized)
+	// DeserializeMaximums() skips the header.
+	header := fmt.Sprintf("# method=%s sample=%d candidates=%d", e.method, e.sample, len(edges))
+	path := fmt.Sprintf("%s/%s/destiny/chosen_edges/%s", e.env.DataDir, e.env.ID, e.env.Underlying)
+	funcs.AtomicWriteFile(path, filename, []byte(header+"\n"+encodedEdges))
+}
+
+func (e *edges) UnmarshalState(data []byte) error {
+	es := edgesState{}
+	err := json.Unmarshal(data, &es)
+	if err != nil {
+		return err
+	}
+	e.weekID = es.WeekID
+	e.edges = es.Edges
+	if e.edges == nil {
+		e.edges = map[int64][]structs.Maximum{}
+	}
+	return nil
+}
+
+// Past edges for every underlying.  Loaded once per week and weeks_back, then shared by everyone holding pastEdges.
+type edgeCache struct {
+	collector *collector.Collector
+	dataDir   string
+	loaded    map[string][]structs.Maximum // "<weekID>_<weeksBack>".  Only the current week.
+}
+
+func newEdgeCache(c *collector.Collector, dataDir string) *edgeCache {
+	return &edgeCache{collector: c, dataDir: dataDir, loaded: map[string][]structs.Maximum{}}
+}
+
+// Also cached on disk in <dataDir>/destiny/<weeksBack>_week_edges/<weekID> for other destinies.
+func (ec *edgeCache) pastEdges(timestamp int64, weeksBack int) []structs.Maximum {
+	c := ec.collector
+	filename := fmt.Sprintf("%d", funcs.WeekID(timestamp))
+	key := fmt.Sprintf("%s_%d", filename, weeksBack)
+	if edges, exists := ec.loaded[key]; exists {
+		return edges
+	}
+	for k := range ec.loaded {
+		if !strings.HasPrefix(k, filename+"_") {
+			delete(ec.loaded, k)
+		}
+	}
+
+	edges := []structs.Maximum{}
+	path := fmt.Sprintf("%s/destiny/%02d_week_edges", ec.dataDir, weeksBack)
+	edgeData, err := os.ReadFile(path + "/" + filename)
+	if err != nil {
+		// Not found, load from collector and persist.
+		fmt.Printf("[populateEdges] %s\n", err)
+		edges = c.GetPastNEdges(timestamp, weeksBack)
+
+		// Encode and save for future reference.
+		encodedEdges, _ := c.SerializeMaximums(edges)
+		// Other destinies may be reading this cache at the same time.
+		err = funcs.AtomicWriteFile(path, filename, []byte(encodedEdges))
+		if err != nil {
+			message := fmt.Sprintf("Failed to write encodedEdges. Err: %s", err)
+			panic(message)
+		}
+	} else {
+		// Got edgeData, decode into []structs.Maximum
+		edges, err = c.DeserializeMaximums(string(edgeData))
+		if err != nil {
+			panic("Someone broke something with DeserializeMaximums or SerializeMaximums.")
+		}
+	}
+	ec.loaded[key] = edges
+	return edges
+}
+
+func filterEdgesByMultiplier(edges []structs.Maximum, multiplier float64) []structs.Maximum {
+	filteredEdges := []structs.Maximum{}
+	for _, edge := range edges {
+		edgeMultiple := float64(edge.MaximumBid) / (float64(edge.OptionAsk) + float64(2.2))
+		if edgeMultiple < multiplier {
+			continue
+		}
+		filteredEdges = append(filteredEdges, edge)
+	}
+	return filteredEdges
+}
+
+func filterEdgesByUnderlying(edges []structs.Maximum, underlying string) []structs.Maximum {
+	filteredEdges := []structs.Maximum{}
+	for _, edge := range edges {
+		if edge.Underlying != underlying {
+			continue
+		}
+		filteredEdges = append(filteredEdges, edge)
+	}
+	return filteredEdges
+}
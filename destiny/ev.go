@@ -0,0 +1,99 @@
+package destiny
+
+import (
+	"github.com/eliwjones/thebox/util"
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"fmt"
+)
+
+// Buys the top quotes by expected value, where expected value comes from what past maximums
+// in the same time of week and premium bucket did, assuming a sale at target times the ask or else at expiration.
+func init() {
+	Register("ev", Params{"weeks_back": "8", "target": "2.0", "min_ev": "0.0", "min_samples": "5", "top": "1",
+		"time_bucket": "3600", "premium_bucket": "0.005"}, newEV)
+}
+
+type ev struct {
+	env          Env
+	minEV        float64
+	minSamples   int
+	model        *Model
+	pastMaximums func(timestamp int64, underlying string, n int) map[string][]structs.Maximum
+	target       float64
+	top          int // How many of the best quotes to consider each pulse.
+	weekID       int64
+	weeksBack    int
+}
+
+func newEV(env Env, params Params) (Strategy, error) {
+	if params.Int("weeks_back") < 1 {
+		return nil, fmt.Errorf("weeks_back must be at least 1, got: %d", params.Int("weeks_back"))
+	}
+	if params.Int("time_bucket") < 1 {
+		return nil, fmt.Errorf("time_bucket must be at least 1, got: %d", params.Int("time_bucket"))
+	}
+	if params.Float("premium_bucket") <= 0 {
+		return nil, fmt.Errorf("premium_bucket must be positive, got: %f", params.Float("premium_bucket"))
+	}
+	e := &ev{env: env, minEV: params.Float("min_ev"), minSamples: params.Int("min_samples"), target: params.Float("target"),
+		top: params.Int("top"), weeksBack: params.Int("weeks_back")}
+	e.model = NewModel(int64(params.Int("time_bucket")), params.Float("premium_bucket"))
+	if env.Collector != nil {
+		e.pastMaximums = env.Collector.GetPastNMaximums
+	}
+	return e, nil
+}
+
+func (e *ev) Name() string {
+	return "ev"
+}
+
+func (e *ev) OnPulse(pulse Pulse) []structs.ProtoOrder {
+	// Same time of week from past weeks.  The model keeps what earlier pulses loaded, so buckets fill in as the week goes,
+	// and forgets anything older than weeks_back once a new week starts.
+	if weekID := funcs.WeekID(pulse.Timestamp); weekID != e.weekID {
+		e.weekID = weekID
+		e.model.Evict(weekID - int64(e.weeksBack)*7*24*60*60)
+	}
+	if e.pastMaximums != nil {
+		maximums := e.pastMaximums(pulse.Timestamp, pulse.Underlying, e.weeksBack)
+		for _, expiration := range sortedNames(maximums) {
+			e.model.Add(maximums[expiration]...)
+		}
+	}
+
+	pos := []structs.ProtoOrder{}
+	estimates := e.model.Rank(pulse, e.target)
+	for _, estimate := range estimates[:min(e.top, len(estimates))] {
+		decision := Decision{Timestamp: pulse.Timestamp, Underlying: pulse.Underlying, Quote: estimate.Quote,
+			Samples: estimate.Samples, HitProbability: estimate.HitProbability, ExpectedValue: estimate.ExpectedValue}
+		switch {
+		case estimate.Samples < e.minSamples:
+			decision.Reason = FEW_SAMPLES
+			decision.Detail = fmt.Sprintf("%d < min_samples %d", estimate.Samples, e.minSamples)
+		case estimate.ExpectedValue <= e.minEV:
+			decision.Reason = LOW_EV
+			decision.Detail = fmt.Sprintf("%.4f <= min_ev %.4f", estimate.ExpectedValue, e.minEV)
+		default:
+			decision.Accepted = true
+		}
+		pulse.Decide(decision)
+		if !decision.Accepted {
+			continue
+		}
+
+		po := structs.ProtoOrder{}
+		po.Timestamp = pulse.Timestamp
+		po.Expiration = estimate.Quote.Expiration
+		po.Symbol = estimate.Quote.Symbol
+		po.LimitOpen = estimate.Quote.Ask
+		po.LimitTS = pulse.Timestamp + estimate.SecondsToMax
+		po.Type = util.OPTION
+		po.Underlying = pulse.Underlying
+
+		pos = append(pos, po)
+	}
+	return pos
+}
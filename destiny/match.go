@@ -0,0 +1,171 @@
+package destiny
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"fmt"
+	"math"
+	"time"
+)
+
+// How the edges strategy decides which live quote is most like a historical edge.
+// distance is only comparable within one matcher.  ok is false when the quote can't be scored,
+// e.g. no underlying price or an ask below intrinsic value.
+type matcher func(edge structs.Maximum, quote structs.Option, pulse Pulse) (distance float64, ok bool)
+
+var matchers = map[string]matcher{
+	"premium":   matchPremium,
+	"moneyness": matchMoneyness,
+	"delta":     matchDelta,
+	"tte":       matchTTE,
+}
+
+// Finds the quote nearest to edge and checks it could return "close enough" a multiplier.
+// Rejected decisions say why.
+func matchEdge(name string, edge structs.Maximum, pulse Pulse, tolerance float64) Decision {
+	decision := Decision{Timestamp: pulse.Timestamp, Underlying: pulse.Underlying, Matcher: name, Edge: edge}
+	match := matchers[name]
+	nearest := math.Inf(1)
+	wrongType, unscored := 0, 0
+	for _, quote := range pulse.Quotes {
+		if quote.Type != edge.OptionType {
+			wrongType += 1
+			continue
+		}
+		distance, ok := match(edge, quote, pulse)
+		if !ok {
+			unscored += 1
+			continue
+		}
+		if distance < nearest {
+			decision.Quote = quote
+			nearest = distance
+		}
+	}
+	if decision.Quote.Symbol == "" {
+		decision.Reason = NO_MATCH
+		decision.Detail = fmt.Sprintf("quotes: %d, wrong type: %d, could not score: %d, underlying bid: %d",
+			len(pulse.Quotes), wrongType, unscored, pulse.Stock.Bid)
+		return decision
+	}
+	decision.Distance = nearest
+
+	// This is completely naive method.
+	// Could block if not close enough, OR could simply submit order with Min(edge.Ask, matchOption.Ask)
+	decision.EdgeMultiplier = funcs.Multiplier(edge.MaximumBid, edge.OptionAsk, 2.2)
+	decision.QuoteMultiplier = funcs.Multiplier(edge.MaximumBid, decision.Quote.Ask, 2.2)
+	decision.MultiplierDiff = math.Abs(decision.EdgeMultiplier-decision.QuoteMultiplier) / decision.EdgeMultiplier
+	// Want multiplier to be within tolerance of edge Multiplier.
+	// If it is too far away, then I'm in uncharted territory that would require more thought.
+	if decision.MultiplierDiff > tolerance {
+		decision.Reason = MULTIPLIER_DIFF
+		decision.Detail = fmt.Sprintf("%.4f > tolerance %.4f", decision.MultiplierDiff, tolerance)
+		return decision
+	}
+	decision.Accepted = true
+	return decision
+}
+
+// Original method.  Ask over strike.
+func matchPremium(edge structs.Maximum, quote structs.Option, pulse Pulse) (float64, bool) {
+	edgePremiumPct := funcs.PremiumPct(edge.OptionAsk, edge.Strike, float64(2.2))
+	premiumPct := funcs.PremiumPct(quote.Ask, quote.Strike, float64(2.2))
+	return math.Abs(premiumPct - edgePremiumPct), true
+}
+
+// Log of strike over underlying bid, so 5% out of the money matches 5% out of the money.
+func matchMoneyness(edge structs.Maximum, quote structs.Option, pulse Pulse) (float64, bool) {
+	if edge.UnderlyingBid <= 0 || pulse.Stock.Bid <= 0 {
+		return 0, false
+	}
+	return math.Abs(moneyness(quote.Strike, pulse.Stock.Bid) - moneyness(edge.Strike, edge.UnderlyingBid)), true
+}
+
+// Moneyness scaled by sqrt(time left), so Monday's 5% out of the money matches
+// a nearer strike on Thursday when there is less time to get there.
+func matchTTE(edge structs.Maximum, quote structs.Option, pulse Pulse) (float64, bool) {
+	edgeT := yearsToExpiration(edge.Expiration, edge.Timestamp)
+	quoteT := yearsToExpiration(quote.Expiration, pulse.Timestamp)
+	if edge.UnderlyingBid <= 0 || pulse.Stock.Bid <= 0 || edgeT <= 0 || quoteT <= 0 {
+		return 0, false
+	}
+	edgeScaled := moneyness(edge.Strike, edge.UnderlyingBid) / math.Sqrt(edgeT)
+	quoteScaled := moneyness(quote.Strike, pulse.Stock.Bid) / math.Sqrt(quoteT)
+	return math.Abs(quoteScaled - edgeScaled), true
+}
+
+// Black-Scholes delta, with volatility implied from each side's ask.
+func matchDelta(edge structs.Maximum, quote structs.Option, pulse Pulse) (float64, bool) {
+	edgeDelta, ok := delta(edge.OptionType, edge.UnderlyingBid, edge.Strike, edge.OptionAsk, yearsToExpiration(edge.Expiration, edge.Timestamp))
+	if !ok {
+		return 0, false
+	}
+	quoteDelta, ok := delta(quote.Type, pulse.Stock.Bid, quote.Strike, quote.Ask, yearsToExpiration(quote.Expiration, pulse.Timestamp))
+	if !ok {
+		return 0, false
+	}
+	return math.Abs(quoteDelta - edgeDelta), true
+}
+
+func moneyness(strike int, underlying int) float64 {
+	return math.Log(float64(strike) / float64(underlying))
+}
+
+// Time from timestamp until 4pm Eastern (close enough to 21:00 UTC) on expiration, in years.
+// Edges loaded from the destiny cache lose Expiration, but they are all weeklies.
+func yearsToExpiration(expiration string, timestamp int64) float64 {
+	t, err := time.Parse("20060102", expiration)
+	if err != nil {
+		t = funcs.NextFriday(time.Unix(timestamp, 0).UTC()).Truncate(24 * time.Hour)
+	}
+	seconds := t.Add(21*time.Hour).Unix() - timestamp
+	return float64(seconds) / (365 * 24 * 60 * 60)
+}
+
+// Zero interest rate Black-Scholes.  Prices in cents, t in years.
+func blackScholes(optionType string, s float64, k float64, t float64, sigma float64) (price float64, delta float64) {
+	d1 := (math.Log(s/k) + sigma*sigma*t/2) / (sigma * math.Sqrt(t))
+	d2 := d1 - sigma*math.Sqrt(t)
+	if optionType == "c" {
+		return s*normCDF(d1) - k*normCDF(d2), normCDF(d1)
+	}
+	return k*normCDF(-d2) - s*normCDF(-d1), normCDF(d1) - 1
+}
+
+func delta(optionType string, underlying int, strike int, ask int, t float64) (float64, bool) {
+	if underlying <= 0 || strike <= 0 || ask <= 0 || t <= 0 {
+		return 0, false
+	}
+	sigma, ok := impliedVolatility(optionType, float64(underlying), float64(strike), float64(ask), t)
+	if !ok {
+		return 0, false
+	}
+	_, d := blackScholes(optionType, float64(underlying), float64(strike), t, sigma)
+	return d, true
+}
+
+// Bisection, since price only goes up with volatility.  Not ok if price is outside what any volatility gives.
+func impliedVolatility(optionType string, s float64, k float64, price float64, t float64) (float64, bool) {
+	low, high := 0.0001, 10.0
+	if p, _ := blackScholes(optionType, s, k, t, low); price < p {
+		return 0, false
+	}
+	if p, _ := blackScholes(optionType, s, k, t, high); price > p {
+		return 0, false
+	}
+	for range 100 {
+		mid := (low + high) / 2
+		p, _ := blackScholes(optionType, s, k, t, mid)
+		if p < price {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return (low + high) / 2, true
+}
+
+func normCDF(x float64) float64 {
+	return (1 + math.Erf(x/math.Sqrt2)) / 2
+}
@@ -0,0 +1,88 @@
+package destiny
+
+import (
+	"github.com/eliwjones/thebox/util/structs"
+
+	"math"
+	"strings"
+	"testing"
+)
+
+func Test_blackScholes(t *testing.T) {
+	// S=100, K=100, T=1, sigma=0.2.  Textbook values.
+	call, callDelta := blackScholes("c", 100, 100, 1, 0.2)
+	put, putDelta := blackScholes("p", 100, 100, 1, 0.2)
+	if math.Abs(call-7.9656) > 0.001 || math.Abs(callDelta-0.5398) > 0.001 {
+		t.Errorf("Expected call: 7.9656, delta: 0.5398, Got: %.4f, %.4f", call, callDelta)
+	}
+	// Put-call parity with zero rates.
+	if math.Abs(call-put) > 0.0001 || math.Abs(callDelta-putDelta-1) > 0.0001 {
+		t.Errorf("Expected put: %.4f, delta: %.4f, Got: %.4f, %.4f", call, callDelta-1, put, putDelta)
+	}
+
+	sigma, ok := impliedVolatility("c", 100, 100, call, 1)
+	if !ok || math.Abs(sigma-0.2) > 0.0001 {
+		t.Errorf("Expected sigma: 0.2, Got: %.6f", sigma)
+	}
+	// Cheaper than intrinsic value.
+	_, ok = impliedVolatility("c", 110, 100, 5, 1)
+	if ok {
+		t.Errorf("Expected no volatility for price below intrinsic.")
+	}
+}
+
+func Test_matchers(t *testing.T) {
+	// Friday 20150130.  Edge seen Monday with 4 days left, pulse on Thursday with 1 day left.
+	monday, thursday := int64(1422280800), int64(1422540000)
+	edge := structs.Maximum{OptionType: "c", OptionSymbol: "AAPL_013015C115", Expiration: "20150130", Timestamp: monday,
+		Strike: 11500, UnderlyingBid: 11000, OptionAsk: 60, MaximumBid: 200}
+	quotes := []structs.Option{
+		{Symbol: "AAPL_013015C110", Type: "c", Expiration: "20150130", Strike: 11000, Ask: 180},
+		{Symbol: "AAPL_013015C115", Type: "c", Expiration: "20150130", Strike: 11500, Ask: 50},
+		{Symbol: "AAPL_013015C123", Type: "c", Expiration: "20150130", Strike: 12300, Ask: 20},
+		{Symbol: "AAPL_013015C125", Type: "c", Expiration: "20150130", Strike: 12500, Ask: 4},
+		{Symbol: "AAPL_013015C130", Type: "c", Expiration: "20150130", Strike: 13000, Ask: 2},
+		{Symbol: "AAPL_013015P110", Type: "p", Expiration: "20150130", Strike: 11000, Ask: 60},
+	}
+	// Underlying rallied to 120.
+	pulse := Pulse{Timestamp: thursday, Quotes: quotes, Stock: structs.Stock{Symbol: "AAPL", Bid: 12000}}
+
+	nearest := func(name string) string {
+		best, symbol := math.Inf(1), ""
+		for _, quote := range quotes {
+			if quote.Type != edge.OptionType {
+				continue
+			}
+			distance, ok := matchers[name](edge, quote, pulse)
+			if ok && distance < best {
+				best, symbol = distance, quote.Symbol
+			}
+		}
+		return symbol
+	}
+
+	// Same strike is same premium, but now deep in the money.
+	expected := map[string]string{"premium": "AAPL_013015C115", "moneyness": "AAPL_013015C125", "tte": "AAPL_013015C123", "delta": "AAPL_013015C123"}
+	for name, symbol := range expected {
+		if got := nearest(name); got != symbol {
+			t.Errorf("%s: Expected: %s, Got: %s", name, symbol, got)
+		}
+	}
+
+	// Nothing to compare to without an underlying price.
+	pulse.Stock = structs.Stock{}
+	for _, name := range []string{"moneyness", "tte", "delta"} {
+		if got := nearest(name); got != "" {
+			t.Errorf("%s: Expected no match without underlying, Got: %s", name, got)
+		}
+	}
+	decision := matchEdge("moneyness", edge, pulse, 1.0)
+	if decision.Reason != NO_MATCH || !strings.Contains(decision.Detail, "quotes: 6, wrong type: 1, could not score: 5") {
+		t.Errorf("Expected diagnostics, Got: %+v", decision)
+	}
+
+	// Cached edges have no Expiration, so use that week's Friday.
+	if yearsToExpiration("", monday) != yearsToExpiration("20150130", monday) {
+		t.Errorf("Expected missing expiration to default to Friday.")
+	}
+}
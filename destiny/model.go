@@ -0,0 +1,192 @@
+package destiny
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Empirical distribution of what past maximums did, bucketed by time of week and premium,
+// so a live quote can be scored by what quotes like it went on to do.
+type Model struct {
+	buckets       map[modelKey]*Distribution
+	premiumBucket float64          // Width of a premium bucket, as a fraction of strike.
+	seen          map[string]int64 // Timestamp by "<Timestamp>_<OptionSymbol>", for ones already added.
+	timeBucket    int64            // Width of a time of week bucket, in seconds.
+}
+
+type modelKey struct {
+	underlying    string
+	optionType    string
+	timeBucket    int64
+	premiumBucket int
+}
+
+func NewModel(timeBucket int64, premiumBucket float64) *Model {
+	return &Model{buckets: map[modelKey]*Distribution{}, premiumBucket: premiumBucket, seen: map[string]int64{}, timeBucket: timeBucket}
+}
+
+// Adds maximums, ignoring gap fillers and ones already added.  Legacy maximums that never saw
+// a bid after decoding are skipped too, since their zero ExpirationBid would read as a total loss.
+func (m *Model) Add(maximums ...structs.Maximum) {
+	for _, maximum := range maximums {
+		id := fmt.Sprintf("%d_%s", maximum.Timestamp, maximum.OptionSymbol)
+		if maximum.OptionAsk <= 0 || maximum.Strike <= 0 {
+			continue
+		}
+		if _, exists := m.seen[id]; exists {
+			continue
+		}
+		// Legacy encodings have no MinTimestamp.  A real worthless expiry still counts.
+		if maximum.MinTimestamp == 0 && maximum.ExpirationBid == 0 {
+			continue
+		}
+		m.seen[id] = maximum.Timestamp
+		key := m.key(maximum.Underlying, maximum.OptionType, maximum.Timestamp, maximum.OptionAsk, maximum.Strike)
+		d, exists := m.buckets[key]
+		if !exists {
+			d = &Distribution{}
+			m.buckets[key] = d
+		}
+		d.add(maximum)
+	}
+}
+
+// Drops maximums from before timestamp, so the model only remembers a trailing window.
+func (m *Model) Evict(before int64) {
+	for id, timestamp := range m.seen {
+		if timestamp < before {
+			delete(m.seen, id)
+		}
+	}
+	for key, d := range m.buckets {
+		d.evict(before)
+		if d.Count() == 0 {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// What past quotes like quote did, or nil if there were none.
+func (m *Model) Distribution(underlying string, quote structs.Option, timestamp int64) *Distribution {
+	return m.buckets[m.key(underlying, quote.Type, timestamp, quote.Ask, quote.Strike)]
+}
+
+func (m *Model) key(underlying string, optionType string, timestamp int64, ask int, strike int) modelKey {
+	premium := funcs.PremiumPct(ask, strike, 2.2)
+	return modelKey{underlying: underlying, optionType: optionType,
+		timeBucket: funcs.TimestampID(timestamp) / m.timeBucket, premiumBucket: int(math.Floor(premium / m.premiumBucket))}
+}
+
+// Scores every quote in pulse that the model has seen the like of, best expected value first.
+func (m *Model) Rank(pulse Pulse, target float64) []Estimate {
+	estimates := []Estimate{}
+	for _, quote := range pulse.Quotes {
+		d := m.Distribution(pulse.Underlying, quote, pulse.Timestamp)
+		if d == nil {
+			continue
+		}
+		estimates = append(estimates, Estimate{Quote: quote, Samples: d.Count(), HitProbability: d.HitProbability(target),
+			ExpectedValue: d.ExpectedValue(target), SecondsToMax: d.SecondsToMax(0.5)})
+	}
+	sort.SliceStable(estimates, func(i, j int) bool {
+		if estimates[i].ExpectedValue == estimates[j].ExpectedValue {
+			return estimates[i].Quote.Symbol < estimates[j].Quote.Symbol
+		}
+		return estimates[i].ExpectedValue > estimates[j].ExpectedValue
+	})
+	return estimates
+}
+
+type Estimate struct {
+	Quote          structs.Option
+	Samples        int
+	HitProbability float64
+	ExpectedValue  float64
+	SecondsToMax   int64 // Median.
+}
+
+// Outcomes of past maximums, per dollar paid (commission included).
+type Distribution struct {
+	multipliers       []float64 // Best bid seen.
+	expiryMultipliers []float64 // Last bid before expiration.
+	secondsToMax      []int64
+	timestamps        []int64 // When each maximum started, for evict.
+}
+
+func (d *Distribution) add(maximum structs.Maximum) {
+	d.multipliers = append(d.multipliers, funcs.Multiplier(maximum.MaximumBid, maximum.OptionAsk, 2.2))
+	d.expiryMultipliers = append(d.expiryMultipliers, funcs.Multiplier(maximum.ExpirationBid, maximum.OptionAsk, 2.2))
+	d.secondsToMax = append(d.secondsToMax, max(maximum.MaxTimestamp-maximum.Timestamp, 0))
+	d.timestamps = append(d.timestamps, maximum.Timestamp)
+}
+
+func (d *Distribution) evict(before int64) {
+	kept := 0
+	for idx, timestamp := range d.timestamps {
+		if timestamp < before {
+			continue
+		}
+		d.multipliers[kept] = d.multipliers[idx]
+		d.expiryMultipliers[kept] = d.expiryMultipliers[idx]
+		d.secondsToMax[kept] = d.secondsToMax[idx]
+		d.timestamps[kept] = timestamp
+		kept += 1
+	}
+	d.multipliers = d.multipliers[:kept]
+	d.expiryMultipliers = d.expiryMultipliers[:kept]
+	d.secondsToMax = d.secondsToMax[:kept]
+	d.timestamps = d.timestamps[:kept]
+}
+
+func (d *Distribution) Count() int {
+	return len(d.multipliers)
+}
+
+// Profit per dollar from selling at target if it is reached and holding to expiration if not.
+// target <= 0 sells every one at its maximum, i.e. the hindsight upper bound.
+func (d *Distribution) ExpectedValue(target float64) float64 {
+	if d.Count() == 0 {
+		return 0
+	}
+	total := 0.0
+	for idx, multiplier := range d.multipliers {
+		switch {
+		case target <= 0:
+			total += multiplier
+		case multiplier >= target:
+			total += target
+		default:
+			total += d.expiryMultipliers[idx]
+		}
+	}
+	return total/float64(d.Count()) - 1
+}
+
+// Fraction of maximums that reached target.
+func (d *Distribution) HitProbability(target float64) float64 {
+	if d.Count() == 0 {
+		return 0
+	}
+	hits := 0
+	for _, multiplier := range d.multipliers {
+		if multiplier >= target {
+			hits += 1
+		}
+	}
+	return float64(hits) / float64(d.Count())
+}
+
+// q in [0, 1].  0.5 for the median time it took to reach the maximum.
+func (d *Distribution) SecondsToMax(q float64) int64 {
+	if d.Count() == 0 {
+		return 0
+	}
+	sorted := append([]int64{}, d.secondsToMax...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(math.Round(q * float64(len(sorted)-1)))
+	return sorted[min(max(idx, 0), len(sorted)-1)]
+}
@@ -0,0 +1,126 @@
+package destiny
+
+import (
+	"github.com/eliwjones/thebox/util/structs"
+
+	"fmt"
+	"math"
+	"testing"
+)
+
+// Ten weeks of AAPL calls at Monday 14:00.  Cheap ones (ask 100) triple half the time and otherwise expire at 50.
+// Dear ones (ask 400) never get past 1.5x and expire worthless.
+func pastMaximums(timestamp int64, underlying string, n int) map[string][]structs.Maximum {
+	maximums := map[string][]structs.Maximum{}
+	for week := range n {
+		ts := timestamp - int64(week+1)*7*24*60*60
+		expiration := fmt.Sprintf("2015%04d", week)
+		cheap := structs.Maximum{Underlying: underlying, OptionType: "c", OptionSymbol: "CHEAP", Timestamp: ts, Strike: 11000,
+			OptionAsk: 100, MaximumBid: 100, ExpirationBid: 50, MaxTimestamp: ts + int64(week)*60, MinTimestamp: ts}
+		if week%2 == 0 {
+			cheap.MaximumBid = 300
+		}
+		dear := structs.Maximum{Underlying: underlying, OptionType: "c", OptionSymbol: "DEAR", Timestamp: ts, Strike: 11000,
+			OptionAsk: 400, MaximumBid: 600, MaxTimestamp: ts + 600, MinTimestamp: ts}
+		// Gap filler from GetPastNEdges() style data.
+		gap := structs.Maximum{Underlying: underlying, OptionType: "c", Timestamp: ts}
+		// Legacy encoding, never updated, so its expiration is unknown.
+		legacy := structs.Maximum{Underlying: underlying, OptionType: "c", OptionSymbol: "LEGACY", Timestamp: ts, Strike: 11000,
+			OptionAsk: 100, MaximumBid: 100, MaxTimestamp: ts}
+		maximums[expiration] = []structs.Maximum{cheap, dear, gap, legacy}
+	}
+	return maximums
+}
+
+func Test_Distribution(t *testing.T) {
+	m := NewModel(3600, 0.005)
+	for _, maximums := range pastMaximums(1422280800, "AAPL", 10) {
+		m.Add(maximums...)
+		m.Add(maximums...) // Already seen.
+	}
+	d := m.Distribution("AAPL", structs.Option{Type: "c", Strike: 11000, Ask: 100}, 1422280800+1800)
+	if d == nil || d.Count() != 10 {
+		t.Fatalf("Expected 10 samples, Got: %+v", d)
+	}
+	if p := d.HitProbability(2.0); p != 0.5 {
+		t.Errorf("Expected 0.5, Got: %f", p)
+	}
+	// Half sell at 2x, half expire at 50/102.2.
+	expected := 0.5*2.0 + 0.5*50/102.2 - 1
+	if ev := d.ExpectedValue(2.0); math.Abs(ev-expected) > 1e-9 {
+		t.Errorf("Expected %f, Got: %f", expected, ev)
+	}
+	if d.SecondsToMax(0) != 0 || d.SecondsToMax(1) != 540 {
+		t.Errorf("Expected 0 to 540 seconds, Got: %d to %d", d.SecondsToMax(0), d.SecondsToMax(1))
+	}
+
+	// Different type or hour has nothing to go on.
+	if m.Distribution("AAPL", structs.Option{Type: "p", Strike: 11000, Ask: 100}, 1422280800) != nil {
+		t.Errorf("Expected no samples for puts.")
+	}
+	if m.Distribution("AAPL", structs.Option{Type: "c", Strike: 11000, Ask: 100}, 1422280800+3600) != nil {
+		t.Errorf("Expected no samples an hour later.")
+	}
+
+	// Only the last 4 weeks left.
+	m.Evict(1422280800 - 4*7*24*60*60)
+	d = m.Distribution("AAPL", structs.Option{Type: "c", Strike: 11000, Ask: 100}, 1422280800)
+	if d == nil || d.Count() != 4 || len(m.seen) != 8 {
+		t.Fatalf("Expected 4 samples per bucket, Got: %+v, seen: %d", d, len(m.seen))
+	}
+	// Weeks 0 and 2 of the last 4 hit.
+	if p := d.HitProbability(2.0); p != 0.5 {
+		t.Errorf("Expected 0.5, Got: %f", p)
+	}
+	m.Evict(1422280800)
+	if len(m.buckets) != 0 || len(m.seen) != 0 {
+		t.Errorf("Expected everything evicted, Got: %v, %v", m.buckets, m.seen)
+	}
+}
+
+func Test_ev_OnPulse(t *testing.T) {
+	s, err := NewStrategy("ev", Env{Underlying: "AAPL"}, Params{"weeks_back": "10", "min_samples": "10", "top": "3"})
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	e := s.(*ev)
+	e.pastMaximums = pastMaximums
+
+	decisions := []Decision{}
+	pulse := Pulse{Timestamp: 1422280800, Underlying: "AAPL", decide: func(d Decision) { decisions = append(decisions, d) }}
+	pulse.Quotes = []structs.Option{
+		{Symbol: "DEAR", Type: "c", Strike: 11000, Ask: 400},
+		{Symbol: "CHEAP", Type: "c", Strike: 11000, Ask: 100},
+		{Symbol: "UNSEEN", Type: "p", Strike: 11000, Ask: 100},
+	}
+	pos := e.OnPulse(pulse)
+	if len(pos) != 1 || pos[0].Symbol != "CHEAP" || pos[0].LimitOpen != 100 || pos[0].LimitTS != pulse.Timestamp+300 {
+		t.Errorf("Expected CHEAP, Got: %+v", pos)
+	}
+	if len(decisions) != 2 || decisions[1].Quote.Symbol != "DEAR" || decisions[1].Reason != LOW_EV {
+		t.Errorf("Expected DEAR rejected for low EV, Got: %+v", decisions)
+	}
+
+	// Pulsing again doesn't double count.
+	e.minSamples = 11
+	decisions = []Decision{}
+	if len(e.OnPulse(pulse)) != 0 || decisions[0].Reason != FEW_SAMPLES || decisions[0].Samples != 10 {
+		t.Errorf("Expected too few samples, Got: %+v", decisions)
+	}
+
+	// A week later the oldest week falls out of the window.
+	e.minSamples = 10
+	decisions = []Decision{}
+	pulse.Timestamp += 7 * 24 * 60 * 60
+	e.pastMaximums = func(timestamp int64, underlying string, n int) map[string][]structs.Maximum { return nil }
+	if len(e.OnPulse(pulse)) != 0 || decisions[0].Reason != FEW_SAMPLES || decisions[0].Samples != 9 {
+		t.Errorf("Expected 9 samples left, Got: %+v", decisions)
+	}
+
+	for _, bad := range []Params{{"weeks_back": "0"}, {"time_bucket": "0"}, {"premium_bucket": "0"}} {
+		_, err = NewStrategy("ev", Env{}, bad)
+		if err == nil {
+			t.Errorf("Expected err for: %v", bad)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package destiny
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"math"
+	"sort"
+)
+
+// Ways the edges strategy can pick which of the past weeks' edges to replay.
+// Each picks min(m, len(edges)) edges and draws only from r.
+var samplers = map[string]func(r *funcs.Rand, edges []structs.Maximum, m int) []structs.Maximum{
+	"uniform":    sampleUniform,
+	"weighted":   sampleWeighted,
+	"topk":       sampleTopK,
+	"stratified": sampleStratified,
+}
+
+func edgeMultiplier(edge structs.Maximum) float64 {
+	return funcs.Multiplier(edge.MaximumBid, edge.OptionAsk, 2.2)
+}
+
+func sampleUniform(r *funcs.Rand, edges []structs.Maximum, m int) []structs.Maximum {
+	chosen := []structs.Maximum{}
+	for _, index := range funcs.ChooseMFromN(r, m, len(edges)) {
+		chosen = append(chosen, edges[index])
+	}
+	return chosen
+}
+
+// Without replacement, more likely the bigger the historical multiplier.
+// Gives each edge the key log(u)/weight and keeps the m largest (Efraimidis-Spirakis).
+func sampleWeighted(r *funcs.Rand, edges []structs.Maximum, m int) []structs.Maximum {
+	keys := make([]float64, len(edges))
+	for idx, edge := range edges {
+		weight := edgeMultiplier(edge)
+		keys[idx] = math.Inf(-1)
+		if weight > 0 {
+			keys[idx] = math.Log(1-r.Float64()) / weight
+		}
+	}
+	order := make([]int, len(edges))
+	for idx := range order {
+		order[idx] = idx
+	}
+	sort.SliceStable(order, func(i, j int) bool { return keys[order[i]] > keys[order[j]] })
+
+	chosen := []structs.Maximum{}
+	for _, idx := range order[:min(m, len(order))] {
+		chosen = append(chosen, edges[idx])
+	}
+	return chosen
+}
+
+// Biggest historical multipliers.  Ignores r.
+func sampleTopK(r *funcs.Rand, edges []structs.Maximum, m int) []structs.Maximum {
+	sorted := append([]structs.Maximum{}, edges...)
+	sort.SliceStable(sorted, func(i, j int) bool { return edgeMultiplier(sorted[i]) > edgeMultiplier(sorted[j]) })
+	return sorted[:min(m, len(sorted))]
+}
+
+// Uniform within each day of the week, with each day getting its share of m
+// in proportion to how many edges it has.  Keeps a busy Monday from crowding out Friday.
+func sampleStratified(r *funcs.Rand, edges []structs.Maximum, m int) []structs.Maximum {
+	days := map[int64][]structs.Maximum{}
+	for _, edge := range edges {
+		day := funcs.TimestampID(edge.Timestamp) / (24 * 60 * 60)
+		days[day] = append(days[day], edge)
+	}
+	ids := []int64{}
+	for day := range days {
+		ids = append(ids, day)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	// Largest remainder so shares add up to m.
+	m = min(m, len(edges))
+	shares := map[int64]int{}
+	remainders := map[int64]float64{}
+	allotted := 0
+	for _, day := range ids {
+		exact := float64(m) * float64(len(days[day])) / float64(len(edges))
+		shares[day] = int(exact)
+		remainders[day] = exact - float64(shares[day])
+		allotted += shares[day]
+	}
+	byRemainder := append([]int64{}, ids...)
+	sort.SliceStable(byRemainder, func(i, j int) bool { return remainders[byRemainder[i]] > remainders[byRemainder[j]] })
+	for _, day := range byRemainder[:m-allotted] {
+		shares[day] += 1
+	}
+
+	chosen := []structs.Maximum{}
+	for _, day := range ids {
+		chosen = append(chosen, sampleUniform(r, days[day], shares[day])...)
+	}
+	return chosen
+}
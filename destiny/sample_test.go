@@ -0,0 +1,108 @@
+package destiny
+
+import (
+	"github.com/eliwjones/thebox/collector"
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Week of Sunday 20150125.  Ten edges a day Monday through Friday, multipliers rising with i.
+func weekOfEdges() []structs.Maximum {
+	edges := []structs.Maximum{}
+	for day := int64(1); day <= 5; day++ {
+		for i := range 10 {
+			ts := int64(1422144000) + day*24*60*60 + int64(14*60*60+i*60)
+			edges = append(edges, structs.Maximum{Underlying: "AAPL", OptionType: "c", OptionSymbol: fmt.Sprintf("AAPL_%d_%d", day, i),
+				Timestamp: ts, MaxTimestamp: ts + 3600, Strike: 11000, UnderlyingBid: 11000, OptionAsk: 100, MaximumBid: 100 + 50*i})
+		}
+	}
+	return edges
+}
+
+func Test_samplers(t *testing.T) {
+	edges := weekOfEdges()
+	for method, sampler := range samplers {
+		chosen := sampler(funcs.NewRand(3), edges, 12)
+		if len(chosen) != 12 {
+			t.Errorf("%s: Expected 12 edges, Got: %d", method, len(chosen))
+		}
+		if !reflect.DeepEqual(chosen, sampler(funcs.NewRand(3), edges, 12)) {
+			t.Errorf("%s: Expected same sample for same seed.", method)
+		}
+		if len(sampler(funcs.NewRand(3), edges, 100)) != len(edges) {
+			t.Errorf("%s: Expected all edges when asking for more than there are.", method)
+		}
+	}
+
+	// topk takes the biggest multipliers, i.e. i = 9 and 8 from each day.
+	for _, edge := range sampleTopK(nil, edges, 10) {
+		if edge.MaximumBid < 100+50*8 {
+			t.Errorf("Expected only top multipliers, Got: %+v", edge)
+		}
+	}
+
+	// weighted favours big multipliers.
+	total := 0
+	for _, edge := range sampleWeighted(funcs.NewRand(3), edges, 10) {
+		total += edge.MaximumBid
+	}
+	uniformTotal := 0
+	for _, edge := range sampleUniform(funcs.NewRand(3), edges, 10) {
+		uniformTotal += edge.MaximumBid
+	}
+	if total <= uniformTotal {
+		t.Errorf("Expected weighted sample to beat uniform, Got: %d vs %d", total, uniformTotal)
+	}
+
+	// stratified spreads over every day, even when one day dominates.
+	lopsided := append([]structs.Maximum{}, edges...)
+	for range 40 {
+		lopsided = append(lopsided, edges[0])
+	}
+	days := map[int64]int{}
+	for _, edge := range sampleStratified(funcs.NewRand(3), lopsided, 9) {
+		days[funcs.TimestampID(edge.Timestamp)/(24*60*60)] += 1
+	}
+	if len(days) != 5 || days[1] != 5 {
+		t.Errorf("Expected 5 Mondays and one of each other day, Got: %v", days)
+	}
+}
+
+func Test_edges_populateEdges(t *testing.T) {
+	dataDir := t.TempDir()
+	c := collector.New("test", t.TempDir(), int64(60))
+	week := weekOfEdges()
+	timestamp := week[len(week)-1].Timestamp + 7*24*60*60
+
+	// Cached edges so the collector isn't needed.
+	encoded, _ := c.SerializeMaximums(week)
+	filename := fmt.Sprintf("%d", funcs.WeekID(timestamp))
+	funcs.AtomicWriteFile(dataDir+"/destiny/08_week_edges", filename, []byte(encoded))
+
+	s, err := NewStrategy("edges", Env{Collector: c, DataDir: dataDir, ID: "test", Rand: funcs.NewRand(1), Underlying: "AAPL"}, Params{"method": "topk", "sample": "5"})
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	s.(*edges).populateEdges(timestamp)
+
+	data, _ := os.ReadFile(dataDir + "/test/destiny/chosen_edges/AAPL/" + filename)
+	header, _, _ := strings.Cut(string(data), "\n")
+	if header != "# method=topk sample=5 candidates=50" {
+		t.Errorf("Unexpected header: %s", header)
+	}
+	chosen, err := c.DeserializeMaximums(string(data))
+	if err != nil || len(chosen) != 5 {
+		t.Errorf("Expected 5 chosen edges, Got: %d, Err: %v", len(chosen), err)
+	}
+
+	_, err = NewStrategy("edges", Env{}, Params{"method": "psychic"})
+	if err == nil {
+		t.Errorf("Expected err for unknown method.")
+	}
+}
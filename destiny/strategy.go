@@ -0,0 +1,140 @@
+package destiny
+
+import (
+	"github.com/eliwjones/thebox/collector"
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Decides what to buy.  Destiny hands it every pulse and forwards whatever ProtoOrders come back to Trader.
+type Strategy interface {
+	Name() string
+	OnPulse(pulse Pulse) []structs.ProtoOrder
+}
+
+// What the world looks like at Timestamp.
+type Pulse struct {
+	Timestamp  int64
+	Underlying string
+	Quotes     []structs.Option            // Current option chain for Underlying.
+	Stock      structs.Stock               // Current quote for Underlying.  Zero if collector didn't have one.
+	Positions  map[string]structs.Position // Currently open.  Empty if Destiny has no adapter to ask.
+
+	decide func(Decision) // Set by Destiny.
+}
+
+// Things a Strategy needs for its whole life.  Collector doubles as history (past quotes, maximums and edges).
+type Env struct {
+	Collector  *collector.Collector
+	DataDir    string                                                 // top level dir for data.
+	ID         string                                                 // Namespace for anything the strategy saves.
+	PastEdges  func(timestamp int64, weeksBack int) []structs.Maximum // Every underlying's edges from the weeksBack weeks before timestamp.
+	Rand       *funcs.Rand                                            // Only source of randomness, so runs replay from the seed in ID.
+	Underlying string
+}
+
+// Per-strategy knobs, e.g. {"weeks_back": "8", "method": "uniform"}.
+// Values must parse the same way as the registered defaults, so Int() and Float() on a merged Params can't fail.
+type Params map[string]string
+
+func (p Params) Float(name string) float64 {
+	f, _ := strconv.ParseFloat(p[name], 64)
+	return f
+}
+
+func (p Params) Int(name string) int {
+	return int(p.Float(name))
+}
+
+func (p Params) String() string {
+	parts := []string{}
+	for _, name := range sortedNames(p) {
+		parts = append(parts, name+"="+p[name])
+	}
+	return strings.Join(parts, ",")
+}
+
+type Factory func(env Env, params Params) (Strategy, error)
+
+type registration struct {
+	defaults Params
+	factory  Factory
+}
+
+var registry = map[string]registration{}
+
+// defaults lists every param the strategy understands.
+func Register(name string, defaults Params, factory Factory) {
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("strategy %s registered twice", name))
+	}
+	registry[name] = registration{defaults: defaults, factory: factory}
+}
+
+func NewStrategy(name string, env Env, params Params) (Strategy, error) {
+	params, err := StrategyParams(name, params)
+	if err != nil {
+		return nil, err
+	}
+	return registry[name].factory(env, params)
+}
+
+// "weeks_back=8,multiplier=1.5" -> Params.
+func ParseParams(s string) (Params, error) {
+	params := Params{}
+	if s == "" {
+		return params, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		name, value, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("expected name=value, got: '%s'", pair)
+		}
+		params[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return params, nil
+}
+
+// Registered strategy names, sorted.
+func Strategies() []string {
+	return sortedNames(registry)
+}
+
+// Fills in defaults for anything not in params.  Errors on unknown strategies or params.
+func StrategyParams(name string, params Params) (Params, error) {
+	r, exists := registry[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown strategy: '%s' choose from: %s", name, strings.Join(Strategies(), ", "))
+	}
+	merged := Params{}
+	for k, v := range r.defaults {
+		merged[k] = v
+	}
+	for k, v := range params {
+		d, exists := r.defaults[k]
+		if !exists {
+			return nil, fmt.Errorf("unknown param for %s: '%s' choose from: %s", name, k, r.defaults)
+		}
+		if _, err := strconv.ParseFloat(d, 64); err == nil {
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return nil, fmt.Errorf("param %s for %s must be a number, got: '%s'", k, name, v)
+			}
+		}
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+func sortedNames[T any](m map[string]T) []string {
+	names := []string{}
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,107 @@
+package destiny
+
+import (
+	"github.com/eliwjones/thebox/util/structs"
+
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// Records what it was shown and echoes size ProtoOrders per pulse.  All for the same symbol when mode is "same".
+type echo struct {
+	env    Env
+	params Params
+	pulses []Pulse
+}
+
+func (e *echo) Name() string {
+	return "echo"
+}
+
+func (e *echo) OnPulse(pulse Pulse) []structs.ProtoOrder {
+	e.pulses = append(e.pulses, pulse)
+	pos := []structs.ProtoOrder{}
+	for i := range e.params.Int("size") {
+		symbol := fmt.Sprintf("%s_ECHO%d", pulse.Underlying, i)
+		if e.params["mode"] == "same" {
+			symbol = pulse.Underlying + "_ECHO"
+		}
+		pos = append(pos, structs.ProtoOrder{Timestamp: pulse.Timestamp, Symbol: symbol, Underlying: pulse.Underlying})
+	}
+	return pos
+}
+
+var lastEcho *echo
+
+func init() {
+	Register("echo", Params{"size": "1", "rate": "0.5", "mode": "loud"}, func(env Env, params Params) (Strategy, error) {
+		lastEcho = &echo{env: env, params: params}
+		return lastEcho, nil
+	})
+}
+
+func Test_ParseParams(t *testing.T) {
+	params, err := ParseParams("weeks_back=4, multiplier=1.5")
+	if err != nil || params.Int("weeks_back") != 4 || params.Float("multiplier") != 1.5 {
+		t.Errorf("Unexpected params: %v, Err: %v", params, err)
+	}
+	if params.String() != "multiplier=1.5,weeks_back=4" {
+		t.Errorf("Expected: multiplier=1.5,weeks_back=4, Got: %s", params)
+	}
+
+	for _, bad := range []string{"weeks_back", "weeks_back=4,"} {
+		_, err = ParseParams(bad)
+		if err == nil {
+			t.Errorf("Expected err for: %s", bad)
+		}
+	}
+}
+
+func Test_StrategyParams(t *testing.T) {
+	params, err := StrategyParams("echo", Params{"rate": "0.25", "mode": "quiet"})
+	if err != nil || params.Int("size") != 1 || params.Float("rate") != 0.25 || params["mode"] != "quiet" {
+		t.Errorf("Expected defaults merged, Got: %v, Err: %v", params, err)
+	}
+
+	_, err = StrategyParams("echo", Params{"weeks_back": "2"})
+	if err == nil {
+		t.Errorf("Expected err for unknown param.")
+	}
+	_, err = StrategyParams("echo", Params{"size": "two"})
+	if err == nil {
+		t.Errorf("Expected err for non-numeric size.")
+	}
+	_, err = StrategyParams("nope", Params{})
+	if err == nil {
+		t.Errorf("Expected err for unknown strategy.")
+	}
+
+	// Other strategies may register too, so only check for these.
+	names := Strategies()
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("Expected sorted names, Got: %v", names)
+	}
+	for _, name := range []string{"echo", "edges", "ev"} {
+		idx := sort.SearchStrings(names, name)
+		if idx == len(names) || names[idx] != name {
+			t.Errorf("Expected %s in: %v", name, names)
+		}
+	}
+}
+
+func Test_NewStrategy(t *testing.T) {
+	s, err := NewStrategy("edges", Env{Underlying: "AAPL"}, Params{"weeks_back": "4"})
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	e := s.(*edges)
+	if e.weeksBack != 4 || e.edgeMultiplier != 1.0 || e.tolerance != 0.1 {
+		t.Errorf("Unexpected edges: %+v", e)
+	}
+
+	_, err = NewStrategy("edges", Env{}, Params{"weeks_back": "0"})
+	if err == nil {
+		t.Errorf("Expected err for weeks_back=0.")
+	}
+}
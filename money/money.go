@@ -2,22 +2,26 @@ package money
 
 import (
 	"github.com/eliwjones/thebox/dispatcher"
+	"github.com/eliwjones/thebox/util/funcs"
 	"github.com/eliwjones/thebox/util/structs"
 
 	"errors"
-	"math/rand"
 )
 
 type Money struct {
 	Total       int                         // Total money in cents.
 	Available   int                         // Available money in cents.
 	Allotments  []structs.Allotment         // Currently available Allotments.
+	count       int                         // How many 1% Allotments ReAllot() makes.
 	deltaSum    int                         // Sum of Delta amounts.
 	allotmentIn chan structs.Allotment      // Deltas rolling in.
 	get         chan chan structs.Allotment // Request allotment.
 	put         chan structs.Signal         // Put allotment.
 	reallot     chan chan bool              // Re-balance Allotments.
+	settle      chan structs.Signal         // Allotments coming back with their deltas.
+	state       chan chan State             // Snapshot requests.
 	dispatcher  *dispatcher.Dispatcher      // My megaphone.
+	Rand        *funcs.Rand                 // Picks allotments.  Swap in a seeded one before sending deltas for replayable runs.
 }
 
 func (m *Money) Get() (structs.Allotment, error) {
@@ -42,12 +46,45 @@ func (m *Money) Put(allotment structs.Allotment, block bool) {
 	}
 }
 
+// What Money needs to pick up where it left off.
+type State struct {
+	Total      int                 `json:"total"`
+	Available  int                 `json:"available"`
+	Allotments []structs.Allotment `json:"allotments"`
+	Count      int                 `json:"count"` // Zero for states saved when ReAllot() always made 100.
+	DeltaSum   int                 `json:"deltaSum"`
+	Rand       []byte              `json:"rand"` // funcs.Rand state.
+}
+
 func (m *Money) ReAllot() {
 	wait := make(chan bool)
 	m.reallot <- wait
 	<-wait
 }
 
+// Takes back allotment once whatever it bought is gone, along with what that made (negative if lost).
+// Losses come out of the allotment, gains go to deltas.
+func (m *Money) Settle(allotment structs.Allotment, delta int) {
+	wait := make(chan bool)
+	m.settle <- structs.Signal{Payload: settlement{allotment: allotment, delta: delta}, Wait: wait}
+	<-wait
+}
+
+type settlement struct {
+	allotment structs.Allotment
+	delta     int
+}
+
+// Consistent snapshot, taken between Gets and Puts.
+func (m *Money) State() (State, error) {
+	reply := make(chan State)
+	m.state <- reply
+	state := <-reply
+	var err error
+	state.Rand, err = m.Rand.MarshalBinary()
+	return state, err
+}
+
 func (m *Money) getRandomAllotment() (a structs.Allotment, err error) {
 	// Insane? Recovering from panic of non-existent index.
 	// Golang Try/Catch?
@@ -61,14 +98,44 @@ func (m *Money) getRandomAllotment() (a structs.Allotment, err error) {
 			}
 		}
 	}()
-	a = m.Allotments[rand.Intn(len(m.Allotments))]
+	a = m.Allotments[m.Rand.IntN(len(m.Allotments))]
 	return a, err
 }
 
-func New(cash int) *Money {
-	m := &Money{}
+// Money as it was when state was taken.
+func Restore(state State) (*Money, error) {
+	count := state.Count
+	if count == 0 {
+		count = 100
+	}
+	m := newMoney(state.Total, count)
+	err := m.Rand.UnmarshalBinary(state.Rand)
+	if err != nil {
+		return nil, err
+	}
+	m.Available = state.Available
+	m.Allotments = append([]structs.Allotment{}, state.Allotments...)
+	m.deltaSum = state.DeltaSum
+	m.start()
+	return m, nil
+}
+
+// ReAllot() splits cash into count 1% Allotments, so count caps how much of it is out at once.
+func New(cash int, count int) *Money {
+	m := newMoney(cash, count)
+	m.start()
+
+	// Create Initial Allotments.
+	m.ReAllot()
+
+	return m
+}
+
+func newMoney(cash int, count int) *Money {
+	m := &Money{count: count}
 
 	m.Total = cash
+	m.Rand = funcs.NewRand(funcs.RandomSeed())
 	m.Available = m.Total
 
 	m.Allotments = []structs.Allotment{}
@@ -77,9 +144,14 @@ func New(cash int) *Money {
 	m.get = make(chan chan structs.Allotment, 100)
 	m.put = make(chan structs.Signal, 100)
 	m.reallot = make(chan chan bool, 10)
+	m.settle = make(chan structs.Signal, 100)
+	m.state = make(chan chan State, 10)
 
 	m.dispatcher = dispatcher.New(1000)
+	return m
+}
 
+func (m *Money) start() {
 	// Send any mod 100 remainder to Deltas.
 
 	// Process Get,Put, ReAllot calls.
@@ -111,8 +183,29 @@ func New(cash int) *Money {
 					signal.Wait <- true
 				}
 			case wait := <-m.reallot:
-				m.Allotments = reallot(m.Available)
+				m.Allotments = reallot(m.Available, m.count)
 				wait <- true
+			case signal := <-m.settle:
+				s := signal.Payload.(settlement)
+				m.Total += s.delta
+				returned := s.allotment.Amount + min(s.delta, 0)
+				if returned > 0 {
+					m.Allotments = append(m.Allotments, structs.Allotment{Amount: returned})
+					m.Available += returned
+				}
+				// Same as incoming Deltas, but done here so the next Get() sees the result.
+				if s.delta > 0 {
+					m.deltaSum += s.delta
+					a, err := m.getRandomAllotment()
+					for m.deltaSum >= a.Amount && err == nil {
+						m.deltaSum -= a.Amount
+						m.Allotments = append(m.Allotments, a)
+						m.Available += a.Amount
+					}
+				}
+				signal.Wait <- true
+			case reply := <-m.state:
+				reply <- State{Total: m.Total, Available: m.Available, Count: m.count, Allotments: append([]structs.Allotment{}, m.Allotments...), DeltaSum: m.deltaSum}
 			}
 		}
 	}()
@@ -130,22 +223,17 @@ func New(cash int) *Money {
 			}
 		}
 	}()
-
-	// Create Initial Allotments.
-	m.ReAllot()
-
-	return m
 }
 
-// Mindless allocation of 1% Allotments.
-func reallot(cash int) []structs.Allotment {
+// Mindless allocation of count 1% Allotments.
+func reallot(cash int, count int) []structs.Allotment {
 	allotments := []structs.Allotment{}
 	allotment := structs.Allotment{}
 	allotment.Amount = cash / 100
 	if allotment.Amount <= 0 {
 		return allotments
 	}
-	for range 100 {
+	for range count {
 		allotments = append(allotments, allotment)
 	}
 	return allotments
@@ -1,22 +1,24 @@
 package money
 
 import (
+	"github.com/eliwjones/thebox/util/funcs"
 	"github.com/eliwjones/thebox/util/structs"
 
+	"reflect"
 	"testing"
 	"time"
 )
 
 func Test_Money_New(t *testing.T) {
 	cash := 1000000 * 100
-	m := New(cash)
+	m := New(cash, 100)
 	if m.Total != cash || m.Available != cash {
 		t.Errorf("Expected Total: %d, Available: %b to Equal: %d!", m.Total, m.Available, cash)
 	}
 }
 
 func Test_Money_Get(t *testing.T) {
-	m := New(1000000 * 100)
+	m := New(1000000*100, 100)
 	count := len(m.Allotments)
 
 	a, err := m.Get()
@@ -41,7 +43,7 @@ func Test_Money_Get(t *testing.T) {
 }
 
 func Test_Money_Put(t *testing.T) {
-	m := New(1000000 * 100)
+	m := New(1000000*100, 100)
 	count := len(m.Allotments)
 	total := m.Total
 
@@ -66,7 +68,7 @@ func Test_Money_Put(t *testing.T) {
 }
 
 func Test_Money_ReAllot(t *testing.T) {
-	m := New(1000000 * 100)
+	m := New(1000000*100, 100)
 	count := len(m.Allotments)
 	m.Get()
 	m.Get()
@@ -80,10 +82,16 @@ func Test_Money_ReAllot(t *testing.T) {
 	if len(m.Allotments) != count {
 		t.Errorf("Expected Len: %d, Got: %d", count, len(m.Allotments))
 	}
+
+	// Still 1% each with fewer of them.
+	m = New(1000000*100, 10)
+	if len(m.Allotments) != 10 || m.Allotments[0].Amount != 1000000 {
+		t.Errorf("Expected 10 of 1000000, Got: %+v", m.Allotments)
+	}
 }
 
 func Test_Money_getRandomAllotment(t *testing.T) {
-	m := New(1000000 * 100)
+	m := New(1000000*100, 100)
 	_, err := m.getRandomAllotment()
 	if err != nil {
 		t.Errorf("Expected random allotment but got err: %s!", err)
@@ -99,7 +107,7 @@ func Test_Money_getRandomAllotment(t *testing.T) {
 	}
 
 	// Verify works with no Cash and no Allotments.
-	m = New(0)
+	m = New(0, 100)
 	a, err := m.getRandomAllotment()
 	if err == nil {
 		t.Errorf("Expected error, but got Allotment: %+v", a)
@@ -108,7 +116,7 @@ func Test_Money_getRandomAllotment(t *testing.T) {
 }
 
 func Test_Money_Processor_Delta(t *testing.T) {
-	m := New(1000000 * 100)
+	m := New(1000000*100, 100)
 	oldTotal := m.Total
 	// Send allotments to allotmentIn
 	allotment := structs.Allotment{Amount: m.Total / 1000}
@@ -139,7 +147,7 @@ func Test_Money_Processor_Delta(t *testing.T) {
 }
 
 func Test_Money_Dispatcher(t *testing.T) {
-	m := New(1000000000)
+	m := New(1000000000, 100)
 
 	ac := make(chan any, 10)
 	m.dispatcher.Subscribe("allotment", "tester", ac, true)
@@ -151,3 +159,64 @@ func Test_Money_Dispatcher(t *testing.T) {
 		t.Errorf("Expected: %+v, Got: %+v", a, gota)
 	}
 }
+
+func Test_Money_Settle(t *testing.T) {
+	m := New(1000000*100, 100)
+	a, _ := m.Get()
+	count := len(m.Allotments)
+
+	// Lost part of it.
+	m.Settle(a, -a.Amount/4)
+	if m.Total != 1000000*100-a.Amount/4 {
+		t.Errorf("Expected Total: %d, Got: %d", 1000000*100-a.Amount/4, m.Total)
+	}
+	if len(m.Allotments) != count+1 || m.Allotments[count].Amount != a.Amount-a.Amount/4 {
+		t.Errorf("Expected what was left back, Got: %+v", m.Allotments[count:])
+	}
+
+	// Made enough for the allotment back plus two more.
+	m = New(1000000*100, 100)
+	a, _ = m.Get()
+	count = len(m.Allotments)
+	total := m.Total
+	m.Settle(a, 2*a.Amount+200)
+	if len(m.Allotments) != count+3 {
+		t.Errorf("Expected Len: %d, Got: %d", count+3, len(m.Allotments))
+	}
+	if m.Total != total+2*a.Amount+200 || m.deltaSum != 200 {
+		t.Errorf("Expected Total: %d, deltaSum: 200, Got: %d, %d", total+2*a.Amount+200, m.Total, m.deltaSum)
+	}
+}
+
+func Test_Money_State_Restore(t *testing.T) {
+	m := New(1000000*100, 100)
+	m.Rand = funcs.NewRand(7)
+	a, _ := m.Get()
+	m.Settle(a, a.Amount/2)
+
+	state, err := m.State()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	m2, err := Restore(state)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	state2, _ := m2.State()
+	if !reflect.DeepEqual(state, state2) {
+		t.Errorf("Expected: %+v, Got: %+v", state, state2)
+	}
+
+	// Both should carry on the same way.
+	for range 3 {
+		a, _ = m.Get()
+		m.Settle(a, 3*a.Amount/4)
+		a, _ = m2.Get()
+		m2.Settle(a, 3*a.Amount/4)
+	}
+	state, _ = m.State()
+	state2, _ = m2.State()
+	if !reflect.DeepEqual(state, state2) {
+		t.Errorf("Expected: %+v, Got: %+v", state, state2)
+	}
+}
@@ -0,0 +1,129 @@
+package optimize
+
+import (
+	"github.com/eliwjones/thebox/destiny"
+	"github.com/eliwjones/thebox/util/funcs"
+
+	"fmt"
+	"strings"
+)
+
+const oneWeek = int64(7 * 24 * 60 * 60)
+
+// Backtests params over the week starting at weekID and returns the fraction made, e.g. 0.05 for 5%.
+// Called at most once per params and week.
+type Evaluator func(params destiny.Params, weekID int64) (float64, error)
+
+// Each week, picks whichever candidate compounded best over the InSample weeks before it,
+// then trades it that week.  Weeks before the first full in-sample window are only used for picking.
+type Config struct {
+	Candidates []destiny.Params
+	InSample   int   // Trailing weeks to pick on.
+	Start      int64 // Any time in the first in-sample week.
+	End        int64 // Any time in the last out-of-sample week.
+}
+
+// Out-of-sample result for one week.
+type Week struct {
+	WeekID   int64
+	Params   destiny.Params // Chosen on the in-sample window.
+	InSample float64        // Compounded in-sample return of Params.
+	Return   float64        // What Params made this week.
+	Equity   float64        // Growth of 1 over every out-of-sample week so far.
+}
+
+type Result struct {
+	Weeks  []Week
+	Equity float64 // Stitched out-of-sample equity at the end.
+}
+
+func WalkForward(cfg Config, evaluate Evaluator) (Result, error) {
+	result := Result{Weeks: []Week{}, Equity: 1}
+	if len(cfg.Candidates) == 0 {
+		return result, fmt.Errorf("no candidates")
+	}
+	if cfg.InSample < 1 {
+		return result, fmt.Errorf("in-sample window must be at least 1 week, got: %d", cfg.InSample)
+	}
+
+	weekIDs := []int64{}
+	for weekID := funcs.WeekID(cfg.Start); weekID <= funcs.WeekID(cfg.End); weekID += oneWeek {
+		weekIDs = append(weekIDs, weekID)
+	}
+	if len(weekIDs) <= cfg.InSample {
+		return result, fmt.Errorf("need more than %d weeks, got: %d", cfg.InSample, len(weekIDs))
+	}
+
+	// returns["<params>_<weekID>"], since in-sample windows overlap.
+	returns := map[string]float64{}
+	weekly := func(params destiny.Params, weekID int64) (float64, error) {
+		key := fmt.Sprintf("%s_%d", params, weekID)
+		if r, exists := returns[key]; exists {
+			return r, nil
+		}
+		r, err := evaluate(params, weekID)
+		if err != nil {
+			return 0, fmt.Errorf("params: %s week: %d err: %w", params, weekID, err)
+		}
+		returns[key] = r
+		return r, nil
+	}
+
+	for idx := cfg.InSample; idx < len(weekIDs); idx++ {
+		week := Week{WeekID: weekIDs[idx]}
+		for _, params := range cfg.Candidates {
+			growth := 1.0
+			for _, weekID := range weekIDs[idx-cfg.InSample : idx] {
+				r, err := weekly(params, weekID)
+				if err != nil {
+					return result, err
+				}
+				growth *= 1 + r
+			}
+			// First candidate wins ties.
+			if week.Params == nil || growth-1 > week.InSample {
+				week.Params = params
+				week.InSample = growth - 1
+			}
+		}
+		r, err := weekly(week.Params, week.WeekID)
+		if err != nil {
+			return result, err
+		}
+		week.Return = r
+		result.Equity *= 1 + r
+		week.Equity = result.Equity
+		result.Weeks = append(result.Weeks, week)
+	}
+	return result, nil
+}
+
+// Every combination of "weeks_back=4|8,multiplier=1.0|1.5", varying the last name fastest.
+func ParseGrid(s string) ([]destiny.Params, error) {
+	grid := []destiny.Params{{}}
+	if s == "" {
+		return grid, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		name, values, found := strings.Cut(pair, "=")
+		if !found || values == "" {
+			return nil, fmt.Errorf("expected name=value|value, got: '%s'", pair)
+		}
+		name = strings.TrimSpace(name)
+		expanded := []destiny.Params{}
+		for _, params := range grid {
+			if _, exists := params[name]; exists {
+				return nil, fmt.Errorf("%s given twice", name)
+			}
+			for _, value := range strings.Split(values, "|") {
+				p := destiny.Params{name: strings.TrimSpace(value)}
+				for k, v := range params {
+					p[k] = v
+				}
+				expanded = append(expanded, p)
+			}
+		}
+		grid = expanded
+	}
+	return grid, nil
+}
@@ -0,0 +1,79 @@
+package optimize
+
+import (
+	"github.com/eliwjones/thebox/destiny"
+
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func Test_ParseGrid(t *testing.T) {
+	grid, err := ParseGrid("weeks_back=4|8, multiplier=1.0|1.5|2.0")
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	if len(grid) != 6 {
+		t.Fatalf("Expected 6 candidates, Got: %v", grid)
+	}
+	if !reflect.DeepEqual(grid[1], destiny.Params{"weeks_back": "4", "multiplier": "1.5"}) {
+		t.Errorf("Expected multiplier to vary fastest, Got: %v", grid)
+	}
+	for _, bad := range []string{"weeks_back", "weeks_back=", "a=1,a=2"} {
+		_, err = ParseGrid(bad)
+		if err == nil {
+			t.Errorf("Expected err for: %s", bad)
+		}
+	}
+}
+
+func Test_WalkForward(t *testing.T) {
+	// Week of Sunday 20150125 and the five after it.
+	start := int64(1422144000)
+	a, b := destiny.Params{"name": "a"}, destiny.Params{"name": "b"}
+	// a does well early, b does well late.
+	returns := map[string][]float64{
+		"a": {0.10, 0.10, 0.10, -0.05, -0.05, -0.05},
+		"b": {0.00, 0.00, 0.05, 0.20, 0.20, 0.20},
+	}
+	calls := 0
+	evaluate := func(params destiny.Params, weekID int64) (float64, error) {
+		calls += 1
+		return returns[params["name"]][(weekID-start)/oneWeek], nil
+	}
+
+	result, err := WalkForward(Config{Candidates: []destiny.Params{a, b}, InSample: 2, Start: start + 60, End: start + 5*oneWeek + 60}, evaluate)
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	chosen := []string{}
+	for _, week := range result.Weeks {
+		chosen = append(chosen, week.Params["name"])
+	}
+	// Week 4's window is weeks 2 and 3: a 1.1*0.95 vs b 1.05*1.2.
+	if !reflect.DeepEqual(chosen, []string{"a", "a", "b", "b"}) {
+		t.Errorf("Expected [a a b b], Got: %v", chosen)
+	}
+	expected := 1.1 * 0.95 * 1.2 * 1.2
+	if math.Abs(result.Equity-expected) > 1e-9 || result.Weeks[3].Equity != result.Equity {
+		t.Errorf("Expected equity %f, Got: %+v", expected, result)
+	}
+	if result.Weeks[0].WeekID != start+2*oneWeek || math.Abs(result.Weeks[0].InSample-0.21) > 1e-9 {
+		t.Errorf("Unexpected first week: %+v", result.Weeks[0])
+	}
+	// Every params and week once, except a's last week, which no window needs.
+	if calls != 11 {
+		t.Errorf("Expected 11 evaluations, Got: %d", calls)
+	}
+
+	_, err = WalkForward(Config{Candidates: []destiny.Params{a}, InSample: 6, Start: start, End: start + 5*oneWeek}, evaluate)
+	if err == nil {
+		t.Errorf("Expected err without an out-of-sample week.")
+	}
+	_, err = WalkForward(Config{Candidates: []destiny.Params{a}, InSample: 1, Start: start, End: start + oneWeek},
+		func(destiny.Params, int64) (float64, error) { return 0, errors.New("boom") })
+	if err == nil {
+		t.Errorf("Expected evaluator err.")
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 type Pulsar struct {
 	lockstep bool                  // should wait for reply from pulsee before sending to next pulsee.
+	order    []string              // pulsees in Subscribe order.  Map order would make lockstep runs unrepeatable.
 	pulses   []int64               // tape of pulses to send out.
 	pulsees  map[string]chan int64 // who is interested in time.
 	replies  map[string]chan int64 // To synchronize, await replies.
@@ -23,10 +24,15 @@ func New(datadir string, start string, stop string, lockstep bool) *Pulsar {
 	return p
 }
 
+// Every pulse Start() will send, in order, not counting the -1 shutdown.
+func (p *Pulsar) Pulses() []int64 {
+	return append([]int64{}, p.pulses...)
+}
+
 func (p *Pulsar) Start() {
 	for _, pulse := range p.pulses {
-		for id, pulsee := range p.pulsees {
-			pulsee <- pulse
+		for _, id := range p.order {
+			p.pulsees[id] <- pulse
 			if !p.lockstep {
 				// not in lockstop mode, so consume all replies at the end.
 				continue
@@ -43,8 +49,8 @@ func (p *Pulsar) Start() {
 
 	}
 	// Send -1 as shutdown signal.
-	for id, pulsee := range p.pulsees {
-		pulsee <- int64(-1)
+	for _, id := range p.order {
+		p.pulsees[id] <- int64(-1)
 		if !p.lockstep {
 			// not in lockstop mode, so consume all replies at the end.
 			continue
@@ -61,6 +67,9 @@ func (p *Pulsar) Start() {
 }
 
 func (p *Pulsar) Subscribe(whoami string, subscriber chan int64, reply chan int64) {
+	if _, exists := p.pulsees[whoami]; !exists {
+		p.order = append(p.order, whoami)
+	}
 	p.pulsees[whoami] = subscriber
 	p.replies[whoami] = reply
 }
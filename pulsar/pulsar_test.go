@@ -53,3 +53,37 @@ func Test_Pulsar_Pulsing(t *testing.T) {
 	fmt.Printf("MS: %d\n", finish-start)
 
 }
+
+func Test_Pulsar_lockstepOrder(t *testing.T) {
+	p := New("data_dir/all", "2222222222", "5555555555", true)
+
+	seen := make(chan string, 100)
+	names := []string{"destiny", "trader", "money", "alpha"}
+	for _, name := range names {
+		tc := make(chan int64, 10)
+		reply := make(chan int64, 10)
+		p.Subscribe(name, tc, reply)
+		go func() {
+			for pulse := range tc {
+				seen <- name
+				reply <- pulse
+				if pulse == -1 {
+					return
+				}
+			}
+		}()
+	}
+	p.Start()
+	close(seen)
+
+	idx := 0
+	for name := range seen {
+		if name != names[idx%len(names)] {
+			t.Fatalf("Expected: %s, Got: %s", names[idx%len(names)], name)
+		}
+		idx += 1
+	}
+	if idx != 5*len(names) {
+		t.Errorf("Expected %d pulses, Got: %d", 5*len(names), idx)
+	}
+}
@@ -0,0 +1,300 @@
+package trader
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What an ExitPolicy gets to look at for one open position on one pulse.
+type Exit struct {
+	Timestamp   int64
+	Position    structs.Position
+	Quote       structs.Option // Current quote for Position.
+	Observation bool           // Timestamp is one of Tracker's observation times.
+	Tracker     *Tracker       // Saved with Trader state, so policies may keep what they need in it.
+}
+
+// Decides when to close a position.  Check returns the rule that fired, or "" to keep holding.
+type ExitPolicy interface {
+	Name() string
+	Check(x Exit) string
+}
+
+// Closes on whichever policy fires first, in order.
+type AnyExit []ExitPolicy
+
+func (a AnyExit) Check(x Exit) string {
+	for _, policy := range a {
+		if rule := policy.Check(x); rule != "" {
+			return rule
+		}
+	}
+	return ""
+}
+
+func (a AnyExit) Name() string {
+	names := []string{}
+	for _, policy := range a {
+		names = append(names, policy.Name())
+	}
+	return strings.Join(names, ";")
+}
+
+type exitRegistration struct {
+	defaults map[string]float64
+	factory  func(name string, params map[string]float64) (ExitPolicy, error)
+}
+
+var exitRegistry = map[string]exitRegistration{}
+
+func init() {
+	RegisterExit("secretary", map[string]float64{"cutoff": 0.3679}, newSecretaryExit) // 1/e
+	RegisterExit("secretary_backoff", map[string]float64{"cutoff": 0.6667, "rate": 0.5}, newSecretaryExit)
+	RegisterExit("target", map[string]float64{"multiple": 5}, newTargetExit)
+	RegisterExit("trailing", map[string]float64{"pct": 0.25, "activate": 1.0}, newTrailingExit)
+	RegisterExit("time", map[string]float64{"seconds": 3600}, newTimeExit)
+	RegisterExit("stoploss", map[string]float64{"pct": 0.5}, newStopLossExit)
+}
+
+// defaults lists every param the policy understands.
+func RegisterExit(name string, defaults map[string]float64, factory func(name string, params map[string]float64) (ExitPolicy, error)) {
+	if _, exists := exitRegistry[name]; exists {
+		panic(fmt.Sprintf("exit policy %s registered twice", name))
+	}
+	exitRegistry[name] = exitRegistration{defaults: defaults, factory: factory}
+}
+
+// Registered exit policy names, sorted.
+func ExitPolicies() []string {
+	names := []string{}
+	for name := range exitRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Fills in defaults for anything not in params.  Name() is "<name>:<param>=<value>,..." with every param.
+func NewExitPolicy(name string, params map[string]float64) (ExitPolicy, error) {
+	r, exists := exitRegistry[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown exit policy: '%s' choose from: %s", name, strings.Join(ExitPolicies(), ", "))
+	}
+	merged := map[string]float64{}
+	for k, v := range r.defaults {
+		merged[k] = v
+	}
+	for k, v := range params {
+		if _, exists := r.defaults[k]; !exists {
+			return nil, fmt.Errorf("unknown param for exit policy %s: '%s'", name, k)
+		}
+		merged[k] = v
+	}
+	keys := []string{}
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%g", k, merged[k]))
+	}
+	if len(parts) > 0 {
+		name += ":" + strings.Join(parts, ",")
+	}
+	return r.factory(name, merged)
+}
+
+// "secretary;target:multiple=3;stoploss:pct=0.5" -> AnyExit.
+func ParseExits(s string) (AnyExit, error) {
+	exits := AnyExit{}
+	for _, spec := range strings.Split(s, ";") {
+		name, paramString, _ := strings.Cut(strings.TrimSpace(spec), ":")
+		if name == "" {
+			return nil, fmt.Errorf("empty exit policy in: '%s'", s)
+		}
+		params := map[string]float64{}
+		if paramString != "" {
+			for _, pair := range strings.Split(paramString, ",") {
+				k, v, found := strings.Cut(pair, "=")
+				f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+				if !found || err != nil {
+					return nil, fmt.Errorf("expected name=number for exit policy %s, got: '%s'", name, pair)
+				}
+				params[strings.TrimSpace(k)] = f
+			}
+		}
+		policy, err := NewExitPolicy(name, params)
+		if err != nil {
+			return nil, err
+		}
+		exits = append(exits, policy)
+	}
+	return exits, nil
+}
+
+// Secretary problem over the tracker's observations.  Lets the first cutoff fraction go by, then takes
+// the first bid that beats every earlier one, or the last observation if none does.
+// With rate, some earlier bids may beat it too, more as observations run out:
+// len(Samples) - rate*remaining of them (Poor Man's Backoff).
+type secretaryExit struct {
+	name   string
+	cutoff float64
+	rate   float64
+}
+
+func newSecretaryExit(name string, params map[string]float64) (ExitPolicy, error) {
+	if params["cutoff"] < 0 || params["cutoff"] >= 1 {
+		return nil, fmt.Errorf("cutoff must be in [0, 1), got: %g", params["cutoff"])
+	}
+	if params["rate"] < 0 {
+		return nil, fmt.Errorf("rate must not be negative, got: %g", params["rate"])
+	}
+	return secretaryExit{name: name, cutoff: params["cutoff"], rate: params["rate"]}, nil
+}
+
+func (s secretaryExit) Check(x Exit) string {
+	if !x.Observation {
+		return ""
+	}
+	tracker := x.Tracker
+	remaining := len(tracker.Observations)
+	total := tracker.Observed + remaining
+	if tracker.Observed <= int(s.cutoff*float64(total)) {
+		return ""
+	}
+	if remaining == 0 {
+		return s.name
+	}
+
+	beatenBy := 0
+	for _, bid := range tracker.Samples {
+		if bid >= x.Quote.Bid {
+			beatenBy += 1
+		}
+	}
+	allowed := 0
+	if s.rate > 0 {
+		allowed = max(len(tracker.Samples)-int(s.rate*float64(remaining)), 0)
+	}
+	if beatenBy <= allowed {
+		return s.name
+	}
+	return ""
+}
+
+func (s secretaryExit) Name() string {
+	return s.name
+}
+
+// Bid reached multiple times fill price.
+type targetExit struct {
+	name     string
+	multiple float64
+}
+
+func newTargetExit(name string, params map[string]float64) (ExitPolicy, error) {
+	if params["multiple"] <= 0 {
+		return nil, fmt.Errorf("multiple must be positive, got: %g", params["multiple"])
+	}
+	return targetExit{name: name, multiple: params["multiple"]}, nil
+}
+
+func (te targetExit) Check(x Exit) string {
+	if float64(x.Quote.Bid) >= te.multiple*float64(x.Position.Fillprice) {
+		return te.name
+	}
+	return ""
+}
+
+func (te targetExit) Name() string {
+	return te.name
+}
+
+// Bid fell pct from its high, once the high reached activate times fill price.
+type trailingExit struct {
+	name     string
+	activate float64
+	pct      float64
+}
+
+func newTrailingExit(name string, params map[string]float64) (ExitPolicy, error) {
+	if params["pct"] <= 0 || params["pct"] >= 1 {
+		return nil, fmt.Errorf("pct must be between 0 and 1, got: %g", params["pct"])
+	}
+	return trailingExit{name: name, activate: params["activate"], pct: params["pct"]}, nil
+}
+
+func (te trailingExit) Check(x Exit) string {
+	high := float64(x.Tracker.MaxBid)
+	if high < te.activate*float64(x.Position.Fillprice) {
+		return ""
+	}
+	if float64(x.Quote.Bid) <= high*(1-te.pct) {
+		return te.name
+	}
+	return ""
+}
+
+func (te trailingExit) Name() string {
+	return te.name
+}
+
+// Within seconds of the close on expiration day.
+type timeExit struct {
+	name    string
+	seconds int64
+}
+
+func newTimeExit(name string, params map[string]float64) (ExitPolicy, error) {
+	if params["seconds"] < 0 {
+		return nil, fmt.Errorf("seconds must not be negative, got: %g", params["seconds"])
+	}
+	return timeExit{name: name, seconds: int64(params["seconds"])}, nil
+}
+
+func (te timeExit) Check(x Exit) string {
+	// Weeklies, so assume this week if Expiration is missing.
+	close := funcs.ExpirationClose(x.Timestamp)
+	if day, err := time.Parse("20060102", x.Quote.Expiration); err == nil {
+		close = funcs.LastClose(day)
+	}
+	if close-x.Timestamp <= te.seconds {
+		return te.name
+	}
+	return ""
+}
+
+func (te timeExit) Name() string {
+	return te.name
+}
+
+// Bid fell pct below fill price.
+type stopLossExit struct {
+	name string
+	pct  float64
+}
+
+func newStopLossExit(name string, params map[string]float64) (ExitPolicy, error) {
+	if params["pct"] <= 0 || params["pct"] > 1 {
+		return nil, fmt.Errorf("pct must be in (0, 1], got: %g", params["pct"])
+	}
+	return stopLossExit{name: name, pct: params["pct"]}, nil
+}
+
+func (s stopLossExit) Check(x Exit) string {
+	if float64(x.Quote.Bid) <= float64(x.Position.Fillprice)*(1-s.pct) {
+		return s.name
+	}
+	return ""
+}
+
+func (s stopLossExit) Name() string {
+	return s.name
+}
@@ -0,0 +1,140 @@
+package trader
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"math"
+	"testing"
+)
+
+func Test_ParseExits(t *testing.T) {
+	exits, err := ParseExits("secretary; target:multiple=3;trailing:pct=0.1")
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	expected := "secretary:cutoff=0.3679;target:multiple=3;trailing:activate=1,pct=0.1"
+	if exits.Name() != expected {
+		t.Errorf("Expected: %s, Got: %s", expected, exits.Name())
+	}
+	for _, bad := range []string{"", "nope", "target:multiple", "target:multiple=x", "target:pct=0.5", "stoploss:pct=2", "secretary;"} {
+		_, err = ParseExits(bad)
+		if err == nil {
+			t.Errorf("Expected err for: '%s'", bad)
+		}
+	}
+}
+
+func Test_ExitPolicy_Check(t *testing.T) {
+	// Tuesday 20150127 14:00 UTC.
+	timestamp := int64(1422367200)
+	position := structs.Position{Fillprice: 100}
+	check := func(spec string, bid int, tracker Tracker, quote structs.Option) string {
+		exits, err := ParseExits(spec)
+		if err != nil {
+			t.Fatalf("Did not expect err: %s", err)
+		}
+		quote.Bid = bid
+		return exits.Check(Exit{Timestamp: timestamp, Position: position, Quote: quote, Tracker: &tracker})
+	}
+
+	tests := []struct {
+		spec     string
+		bid      int
+		tracker  Tracker
+		quote    structs.Option
+		expected string
+	}{
+		{"target:multiple=2", 199, Tracker{}, structs.Option{}, ""},
+		{"target:multiple=2", 200, Tracker{}, structs.Option{}, "target:multiple=2"},
+		{"stoploss:pct=0.5", 51, Tracker{}, structs.Option{}, ""},
+		{"stoploss:pct=0.5", 50, Tracker{}, structs.Option{}, "stoploss:pct=0.5"},
+		// High of 300 is past activation, so 225 is 25% off the high.
+		{"trailing", 226, Tracker{MaxBid: 300}, structs.Option{}, ""},
+		{"trailing", 225, Tracker{MaxBid: 300}, structs.Option{}, "trailing:activate=1,pct=0.25"},
+		{"trailing:activate=4", 100, Tracker{MaxBid: 300}, structs.Option{}, ""},
+		// Friday 21:00 UTC is 3 days and 7 hours away.
+		{"time:seconds=284400", 100, Tracker{}, structs.Option{}, "time:seconds=284400"},
+		{"time:seconds=284399", 100, Tracker{}, structs.Option{}, ""},
+		{"time:seconds=3600", 100, Tracker{}, structs.Option{Expiration: "20150127"}, ""},
+		{"time:seconds=25200", 100, Tracker{}, structs.Option{Expiration: "20150127"}, "time:seconds=25200"},
+		// First to fire wins.
+		{"stoploss;target:multiple=1", 40, Tracker{}, structs.Option{}, "stoploss:pct=0.5"},
+		{"target:multiple=3;stoploss", 40, Tracker{}, structs.Option{}, "stoploss:pct=0.5"},
+	}
+	for _, test := range tests {
+		got := check(test.spec, test.bid, test.tracker, test.quote)
+		if got != test.expected {
+			t.Errorf("%s at %d: Expected: '%s', Got: '%s'", test.spec, test.bid, test.expected, got)
+		}
+	}
+}
+
+// Runs bids through exits one observation at a time, like Trader does.  Returns the index it closed on, or -1.
+func runExits(exits ExitPolicy, bids []int) int {
+	tracker := Tracker{}
+	for idx := range bids {
+		tracker.Observations = append(tracker.Observations, int64(idx+1))
+	}
+	for idx, bid := range bids {
+		if checkExit(exits, int64(idx+1), structs.Position{Fillprice: 100}, structs.Option{Bid: bid}, &tracker) != "" {
+			return idx
+		}
+	}
+	return -1
+}
+
+func Test_secretaryExit(t *testing.T) {
+	classic, _ := ParseExits("secretary")
+	backoff, _ := ParseExits("secretary_backoff:cutoff=0.3,rate=0.5")
+	tests := []struct {
+		exits    ExitPolicy
+		bids     []int
+		expected int
+	}{
+		// 10 observations, so skip int(10/e) = 3 then take the first to beat them.
+		{classic, []int{5, 8, 3, 6, 9, 2, 10, 1, 4, 7}, 4},
+		{classic, []int{5, 8, 3, 8, 7, 2, 1, 1, 4, 9}, 9},
+		// Best came first, so stuck with the last one.
+		{classic, []int{10, 8, 3, 6, 9, 2, 5, 1, 4, 7}, 9},
+		// Ties don't beat.
+		{classic, []int{5, 8, 3, 8, 8, 2, 1, 1, 4, 3}, 9},
+		// Backoff: at idx 6, 6 samples and 3 remaining, so 6 - 1 = 5 may beat it.  7 is only beaten by 10.
+		{backoff, []int{10, 8, 3, 6, 5, 2, 7, 1, 4, 9}, 6},
+		// Classic waits it out.
+		{classic, []int{10, 8, 3, 6, 5, 2, 7, 1, 4, 9}, 9},
+	}
+	for idx, test := range tests {
+		got := runExits(test.exits, test.bids)
+		if got != test.expected {
+			t.Errorf("%d: %s on %v: Expected: %d, Got: %d", idx, test.exits.(AnyExit).Name(), test.bids, test.expected, got)
+		}
+	}
+
+	// Between pulses observations don't count, and gaps use up observations.
+	tracker := Tracker{Observations: []int64{60, 120, 180, 240}}
+	if checkExit(classic, 30, structs.Position{}, structs.Option{Bid: 5}, &tracker) != "" || len(tracker.Samples) != 0 {
+		t.Errorf("Expected no sample between observations, Got: %+v", tracker)
+	}
+	checkExit(classic, 200, structs.Position{}, structs.Option{Bid: 5}, &tracker)
+	if tracker.Observed != 3 || len(tracker.Samples) != 1 || len(tracker.Observations) != 1 {
+		t.Errorf("Expected 3 observed and 1 sample, Got: %+v", tracker)
+	}
+}
+
+// Picks the best of n with probability approaching 1/e.
+func Test_secretaryExit_probability(t *testing.T) {
+	classic, _ := ParseExits("secretary")
+	r := funcs.NewRand(7)
+	trials, n, best := 5000, 100, 0
+	for range trials {
+		bids := r.Perm(n)
+		if idx := runExits(classic, bids); idx >= 0 && bids[idx] == n-1 {
+			best += 1
+		}
+	}
+	p := float64(best) / float64(trials)
+	if math.Abs(p-1/math.E) > 0.03 {
+		t.Errorf("Expected about %.3f, Got: %.3f", 1/math.E, p)
+	}
+}
@@ -0,0 +1,159 @@
+package trader
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"encoding/json"
+	"fmt"
+)
+
+// Kinds of Event.
+const (
+	EVENT_PROTOORDER = "protoorder" // ProtoOrder came in.
+	EVENT_ORDER      = "order"      // Order built from it, with its Allotment.
+	EVENT_REJECTED   = "rejected"   // Order refused by constructOrder, Risk (Rule) or the adapter.  Allotment went back.  With PositionID, the adapter refused to close it.
+	EVENT_SUBMITTED  = "submitted"  // Adapter took Order.
+	EVENT_FILLED     = "filled"     // Position showed up, with its starting Tracker.
+	EVENT_SAMPLE     = "sample"     // Tracker changed after a quote.
+	EVENT_CLOSE      = "close"      // Exit policy Rule fired at Bid.
+	EVENT_CLOSED     = "closed"     // Position went away and its allotment settled.
+	EVENT_EXPIRY     = "expiry"     // New week.  Whatever was still open expired, Histories got finalized.
+)
+
+// One line of the journal.  Only the fields Kind needs are set.
+type Event struct {
+	Kind       string                    `json:"kind"`
+	Timestamp  int64                     `json:"timestamp"`
+	PositionID string                    `json:"positionId,omitempty"`
+	ProtoOrder *structs.ProtoOrder       `json:"protoOrder,omitempty"`
+	Order      *structs.Order            `json:"order,omitempty"`
+	Allotment  int                       `json:"allotment,omitempty"`
+	Position   *structs.Position         `json:"position,omitempty"`
+	Tracker    *Tracker                  `json:"tracker,omitempty"`
+	Bid        int                       `json:"bid,omitempty"`
+	Rule       string                    `json:"rule,omitempty"`
+	Error      string                    `json:"error,omitempty"`
+	WeekID     int64                     `json:"weekId,omitempty"`
+	Histories  map[string]PostionHistory `json:"histories,omitempty"`
+}
+
+// Every journal entry, oldest first.  Lines torn by a crash mid-append are skipped.
+func ReadJournal(path string) ([]Event, error) {
+	records, _, err := funcs.ReadRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	events := []Event{}
+	for idx, record := range records {
+		e := Event{}
+		err = json.Unmarshal([]byte(record), &e)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", idx+1, err)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// Rebuilds Positions, PositionHistory, Trackers, Allotted, pending orders, counts and week from events alone.
+// Money and Balances belong to Money and the adapter, so are left empty.
+func Replay(events []Event) (*Trader, error) {
+	t := &Trader{}
+	t.Allotted = map[string]int{}
+	t.orders = map[string]structs.Order{}
+	t.PositionHistory = map[string]PostionHistory{}
+	t.Positions = map[string]structs.Position{}
+	t.Trackers = map[string]Tracker{}
+	for idx, e := range events {
+		err := t.apply(e)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %s", idx, err)
+		}
+	}
+	return t, nil
+}
+
+// Journals e, then applies it.  Failing to apply means Trader's own bookkeeping is broken.
+func (t *Trader) record(e Event) {
+	line, err := json.Marshal(e)
+	if err == nil {
+		err = funcs.AppendRecord(t.traderDir, "journal", string(line))
+	}
+	if err != nil {
+		fmt.Printf("[Trader] Failed to journal %s: %s\n", e.Kind, err)
+	}
+	err = t.apply(e)
+	if err != nil {
+		panic(fmt.Sprintf("[Trader] %s", err))
+	}
+}
+
+// All Trader bookkeeping changes go through here, so live trading and Replay can't drift apart.
+// Only sync() writes outside it, copying in whatever the adapter reports.
+func (t *Trader) apply(e Event) error {
+	switch e.Kind {
+	case EVENT_PROTOORDER, EVENT_ORDER, EVENT_REJECTED:
+		// Nothing held on to.
+	case EVENT_SUBMITTED:
+		if e.Order == nil {
+			return fmt.Errorf("%s without order", e.Kind)
+		}
+		// Counts against Risk until it fills.
+		t.orders[e.Order.Id] = *e.Order
+		t.Allotted[e.Order.Id] = e.Allotment
+		t.submitted = append(t.submitted, e.Timestamp)
+	case EVENT_FILLED:
+		if e.Position == nil || e.Tracker == nil {
+			return fmt.Errorf("%s without position and tracker", e.Kind)
+		}
+		p := *e.Position
+		if _, exists := t.Trackers[p.Id]; exists {
+			return fmt.Errorf("position %s filled twice.  Either duplicate Order/Position IDs or a Position disappeared and re-appeared", p.Id)
+		}
+		delete(t.orders, p.Order.Id)
+		t.Trackers[p.Id] = *e.Tracker
+		t.initHistory(p, e.Timestamp)
+		t.Positions[p.Id] = p
+		t.PositionCount += 1
+	case EVENT_SAMPLE:
+		if _, exists := t.Positions[e.PositionID]; !exists || e.Tracker == nil {
+			return fmt.Errorf("%s for unknown position %s", e.Kind, e.PositionID)
+		}
+		t.Trackers[e.PositionID] = *e.Tracker
+	case EVENT_CLOSE:
+		history, exists := t.PositionHistory[e.PositionID]
+		if !exists {
+			return fmt.Errorf("%s for unknown position %s", e.Kind, e.PositionID)
+		}
+		history.ExitRule = e.Rule
+		history.LimitClose = e.Bid
+		history.Timestamp = e.Timestamp
+		t.PositionHistory[e.PositionID] = history
+	case EVENT_CLOSED:
+		p, exists := t.Positions[e.PositionID]
+		if !exists {
+			return fmt.Errorf("%s for unknown position %s", e.Kind, e.PositionID)
+		}
+		delete(t.Allotted, p.Order.Id)
+		delete(t.Positions, e.PositionID)
+		delete(t.Trackers, e.PositionID)
+		history := t.PositionHistory[e.PositionID]
+		history.Closed = true
+		t.PositionHistory[e.PositionID] = history
+	case EVENT_EXPIRY:
+		t.Allotted = map[string]int{}
+		t.orders = map[string]structs.Order{}
+		t.Positions = map[string]structs.Position{}
+		t.submitted = []int64{}
+		t.Trackers = map[string]Tracker{}
+		for id, history := range e.Histories {
+			t.PositionHistory[id] = history
+		}
+		t.CurrentWeekId = e.WeekID
+		t.WeekCount += 1
+	default:
+		return fmt.Errorf("unknown event kind: '%s'", e.Kind)
+	}
+	return nil
+}
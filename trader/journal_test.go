@@ -0,0 +1,154 @@
+package trader
+
+import (
+	"github.com/eliwjones/thebox/adapter/simulate"
+	"github.com/eliwjones/thebox/collector"
+	"github.com/eliwjones/thebox/util"
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_Replay(t *testing.T) {
+	td := testTrader()
+	testMoney(td, 5000000, 5000000, 5000000)
+	start := int64(1422014400)
+	td.CurrentWeekId = funcs.WeekID(start)
+	td.Exits, _ = ParseExits("target:multiple=1.02")
+	td.Interval = 60
+	td.Schedule = PulseSchedule{start, start + 600}
+
+	po := structs.ProtoOrder{Symbol: "AAPL_012315C120", Type: util.OPTION, Underlying: "AAPL", Expiration: "20150123"}
+	// Closes on the next quote.
+	po.LimitOpen = 15000
+	td.PoIn <- po
+	// Still open when the week ends.
+	po.LimitOpen = 15400
+	td.PoIn <- po
+	// Can't afford it.
+	po.LimitOpen = 1000000
+	td.PoIn <- po
+
+	for _, timestamp := range []int64{start, start + 600, start + 660, start + 7*24*60*60, -1} {
+		td.Pulses <- timestamp
+		<-td.PulsarReply
+	}
+
+	events, err := ReadJournal(td.traderDir + "/journal")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	counts := map[string]int{}
+	for _, e := range events {
+		counts[e.Kind] += 1
+	}
+	expectedCounts := map[string]int{EVENT_PROTOORDER: 3, EVENT_ORDER: 2, EVENT_REJECTED: 1, EVENT_SUBMITTED: 2, EVENT_FILLED: 2,
+		EVENT_SAMPLE: 2, EVENT_CLOSE: 1, EVENT_CLOSED: 1, EVENT_EXPIRY: 1}
+	if !reflect.DeepEqual(counts, expectedCounts) {
+		t.Errorf("\nExpected: %v\nGot: %v", expectedCounts, counts)
+	}
+
+	replayed, err := Replay(events)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	pairs := map[string][2]any{
+		"Allotted":        {td.Allotted, replayed.Allotted},
+		"CurrentWeekId":   {td.CurrentWeekId, replayed.CurrentWeekId},
+		"orders":          {td.orders, replayed.orders},
+		"PositionCount":   {td.PositionCount, replayed.PositionCount},
+		"PositionHistory": {td.PositionHistory, replayed.PositionHistory},
+		"Positions":       {td.Positions, replayed.Positions},
+		"submitted":       {td.submitted, replayed.submitted},
+		"Trackers":        {td.Trackers, replayed.Trackers},
+		"WeekCount":       {td.WeekCount, replayed.WeekCount},
+	}
+	for name, pair := range pairs {
+		if !reflect.DeepEqual(pair[0], pair[1]) {
+			t.Errorf("%s\nExpected: %+v\nGot: %+v", name, pair[0], pair[1])
+		}
+	}
+	// One closed on target, one expired.
+	rules := map[string]int{}
+	for _, h := range replayed.PositionHistory {
+		rules[h.ExitRule] += 1
+	}
+	if len(replayed.PositionHistory) != 2 || rules["target:multiple=1.02"] != 1 || rules[""] != 1 {
+		t.Errorf("Expected one target exit and one expiry, Got: %+v", replayed.PositionHistory)
+	}
+
+	// Submitted but not yet filled counts as pending.
+	if events[2].Kind != EVENT_SUBMITTED {
+		t.Fatalf("Expected: %s, Got: %s", EVENT_SUBMITTED, events[2].Kind)
+	}
+	pending, _ := Replay(events[:3])
+	if _, exists := pending.orders[events[2].Order.Id]; !exists {
+		t.Errorf("Expected pending order, Got: %v", pending.orders)
+	}
+
+	// Events that don't fit are errors, not silently skipped.
+	_, err = Replay([]Event{{Kind: EVENT_CLOSED, PositionID: "order-1"}})
+	if err == nil {
+		t.Errorf("Expected error for closing an unknown position.")
+	}
+	_, err = Replay([]Event{{Kind: "bogus"}})
+	if err == nil {
+		t.Errorf("Expected error for unknown kind.")
+	}
+}
+
+// Adapter that won't close anything.
+type closeFails struct {
+	*simulate.Simulate
+}
+
+func (a closeFails) ClosePosition(id string, limit int) error {
+	return errors.New("market closed")
+}
+
+func Test_Trader_closeFailed(t *testing.T) {
+	os.RemoveAll("testDir")
+	c := collector.New("test", "../testdata", int64(60))
+	td := New("test-id", "testDir", closeFails{simulate.New("simulate", "simulation", 300000*100)}, c)
+	testMoney(td, 5000000)
+	start := int64(1422014400)
+	td.CurrentWeekId = funcs.WeekID(start)
+	td.Exits, _ = ParseExits("target:multiple=1.02")
+	td.Interval = 60
+	td.Schedule = PulseSchedule{start, start + 600}
+
+	td.PoIn <- structs.ProtoOrder{Symbol: "AAPL_012315C120", Type: util.OPTION, Underlying: "AAPL", Expiration: "20150123", LimitOpen: 15000}
+	for _, timestamp := range []int64{start, start + 600, start + 660, -1} {
+		td.Pulses <- timestamp
+		<-td.PulsarReply
+	}
+
+	events, err := ReadJournal(td.traderDir + "/journal")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	rejected := 0
+	for _, e := range events {
+		if e.Kind == EVENT_CLOSE {
+			t.Errorf("Did not expect %s when the close failed, Got: %+v", EVENT_CLOSE, e)
+		}
+		if e.Kind == EVENT_REJECTED && e.PositionID != "" && e.Error == "market closed" && e.Rule == "target:multiple=1.02" {
+			rejected += 1
+		}
+	}
+	if rejected == 0 {
+		t.Errorf("Expected failed close journaled, Got: %+v", events)
+	}
+	if len(td.Positions) != 1 {
+		t.Fatalf("Expected position still open, Got: %v", td.Positions)
+	}
+	for id, history := range td.PositionHistory {
+		if history.LimitClose != 0 || history.ExitRule != "" || history.Timestamp != 0 {
+			t.Errorf("Expected %s history untouched, Got: %+v", id, history)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package trader
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rules checked between ProtoOrder and SubmitOrder.  Zero means no limit.  Money is in cents.
+type RiskLimits struct {
+	MaxOpenPositions int   // Positions plus open orders.
+	MaxPerUnderlying int   // Cost of positions and open orders in one underlying.
+	MaxPerExpiration int   // Cost of positions and open orders expiring on one day.
+	MaxDailyLoss     int   // Realized loss since midnight Eastern.
+	MaxWeeklyLoss    int   // Realized loss since WeekID.
+	MaxOrders        int   // Orders submitted per OrderInterval.
+	OrderInterval    int64 // Seconds.
+	NoDuplicates     bool  // Reject symbols already held or on order.
+}
+
+// Names for ParseRiskLimits.
+const (
+	MAX_OPEN         = "max_open"
+	MAX_UNDERLYING   = "max_underlying"
+	MAX_EXPIRATION   = "max_expiration"
+	MAX_DAILY_LOSS   = "max_daily_loss"
+	MAX_WEEKLY_LOSS  = "max_weekly_loss"
+	MAX_ORDERS       = "max_orders"
+	DUPLICATE_SYMBOL = "duplicate_symbol"
+)
+
+// "max_open=10,max_orders=5/3600,duplicate_symbol=1" -> RiskLimits.
+func ParseRiskLimits(s string) (RiskLimits, error) {
+	limits := RiskLimits{}
+	if s == "" {
+		return limits, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		name, value, found := strings.Cut(pair, "=")
+		if !found {
+			return limits, fmt.Errorf("expected name=value, got: '%s'", pair)
+		}
+		name = strings.TrimSpace(name)
+		value, interval, _ := strings.Cut(strings.TrimSpace(value), "/")
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return limits, fmt.Errorf("%s must be a non-negative integer, got: '%s'", name, value)
+		}
+		switch name {
+		case MAX_OPEN:
+			limits.MaxOpenPositions = n
+		case MAX_UNDERLYING:
+			limits.MaxPerUnderlying = n
+		case MAX_EXPIRATION:
+			limits.MaxPerExpiration = n
+		case MAX_DAILY_LOSS:
+			limits.MaxDailyLoss = n
+		case MAX_WEEKLY_LOSS:
+			limits.MaxWeeklyLoss = n
+		case MAX_ORDERS:
+			limits.MaxOrders = n
+			limits.OrderInterval, err = strconv.ParseInt(interval, 10, 64)
+			if err != nil || limits.OrderInterval <= 0 {
+				return limits, fmt.Errorf("%s must look like <orders>/<seconds>, got: '%s'", name, pair)
+			}
+		case DUPLICATE_SYMBOL:
+			limits.NoDuplicates = n != 0
+		default:
+			return limits, fmt.Errorf("unknown risk limit: '%s' choose from: %s", name,
+				strings.Join([]string{MAX_OPEN, MAX_UNDERLYING, MAX_EXPIRATION, MAX_DAILY_LOSS, MAX_WEEKLY_LOSS, MAX_ORDERS, DUPLICATE_SYMBOL}, ", "))
+		}
+	}
+	return limits, nil
+}
+
+// Rules for rejections that are not limits.
+const (
+	CONSTRUCT_FAILED = "construct" // No Order could be made from the ProtoOrder and its allotment.
+	SUBMIT_FAILED    = "submit"    // Adapter refused the Order.
+)
+
+// Sent on ProtoOrder.Reply for every rejection, whether a limit stopped the order or not.
+type RiskError struct {
+	Rule   string // One of the ParseRiskLimits names, CONSTRUCT_FAILED or SUBMIT_FAILED.
+	Detail string
+}
+
+func (e *RiskError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Rule, e.Detail)
+}
+
+// nil if o can be submitted at timestamp.
+func (t *Trader) checkRisk(o structs.Order, timestamp int64) *RiskError {
+	limits := t.Risk
+
+	// Everything open, whether or not it has filled.
+	open := []structs.Order{}
+	for _, p := range t.Positions {
+		open = append(open, p.Order)
+	}
+	for _, order := range t.orders {
+		open = append(open, order)
+	}
+
+	if limits.NoDuplicates {
+		for _, order := range open {
+			if order.Symbol == o.Symbol {
+				return &RiskError{Rule: DUPLICATE_SYMBOL, Detail: o.Symbol}
+			}
+		}
+	}
+	if limits.MaxOpenPositions > 0 && len(open) >= limits.MaxOpenPositions {
+		return &RiskError{Rule: MAX_OPEN, Detail: fmt.Sprintf("%d open", len(open))}
+	}
+	if limits.MaxOrders > 0 {
+		recent := 0
+		for _, ts := range t.submitted {
+			if ts > timestamp-limits.OrderInterval {
+				recent += 1
+			}
+		}
+		if recent >= limits.MaxOrders {
+			return &RiskError{Rule: MAX_ORDERS, Detail: fmt.Sprintf("%d in the last %d seconds", recent, limits.OrderInterval)}
+		}
+	}
+	if limits.MaxDailyLoss > 0 {
+		offset := int64(funcs.EasternOffset(time.Unix(timestamp, 0)).Seconds())
+		local := timestamp + offset
+		midnight := local - local%(24*60*60) - offset
+		if loss := t.realizedLoss(midnight); loss >= limits.MaxDailyLoss {
+			return &RiskError{Rule: MAX_DAILY_LOSS, Detail: fmt.Sprintf("lost %d today", loss)}
+		}
+	}
+	if limits.MaxWeeklyLoss > 0 {
+		if loss := t.realizedLoss(funcs.WeekID(timestamp)); loss >= limits.MaxWeeklyLoss {
+			return &RiskError{Rule: MAX_WEEKLY_LOSS, Detail: fmt.Sprintf("lost %d this week", loss)}
+		}
+	}
+	if limits.MaxPerUnderlying > 0 {
+		cost := o.Maxcost
+		for _, order := range open {
+			if order.ProtoOrder.Underlying == o.ProtoOrder.Underlying {
+				cost += order.Maxcost
+			}
+		}
+		if cost > limits.MaxPerUnderlying {
+			return &RiskError{Rule: MAX_UNDERLYING, Detail: fmt.Sprintf("%s would have %d", o.ProtoOrder.Underlying, cost)}
+		}
+	}
+	if limits.MaxPerExpiration > 0 {
+		cost := o.Maxcost
+		for _, order := range open {
+			if order.ProtoOrder.Expiration == o.ProtoOrder.Expiration {
+				cost += order.Maxcost
+			}
+		}
+		if cost > limits.MaxPerExpiration {
+			return &RiskError{Rule: MAX_EXPIRATION, Detail: fmt.Sprintf("%s would have %d", o.ProtoOrder.Expiration, cost)}
+		}
+	}
+	return nil
+}
+
+// Net of commissions, for positions closed since timestamp.  Negative if they made money.
+func (t *Trader) realizedLoss(since int64) int {
+	loss := 0
+	for _, h := range t.PositionHistory {
+		// Timestamp is only set by closing.
+		if h.Timestamp == 0 || h.Timestamp < since {
+			continue
+		}
+		loss -= h.delta()
+	}
+	return loss
+}
@@ -0,0 +1,146 @@
+package trader
+
+import (
+	"github.com/eliwjones/thebox/util/structs"
+
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_ParseRiskLimits(t *testing.T) {
+	limits, err := ParseRiskLimits("max_open=10, max_orders=5/3600,duplicate_symbol=1,max_underlying=500000")
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	expected := RiskLimits{MaxOpenPositions: 10, MaxOrders: 5, OrderInterval: 3600, NoDuplicates: true, MaxPerUnderlying: 500000}
+	if limits != expected {
+		t.Errorf("Expected: %+v, Got: %+v", expected, limits)
+	}
+	for _, bad := range []string{"max_open", "max_open=-1", "max_open=x", "max_orders=5", "max_orders=5/0", "nope=1"} {
+		_, err = ParseRiskLimits(bad)
+		if err == nil {
+			t.Errorf("Expected err for: %s", bad)
+		}
+	}
+}
+
+func Test_Trader_checkRisk(t *testing.T) {
+	// Tuesday 20150127 15:00 UTC, 10am Eastern.
+	timestamp := int64(1422370800)
+	order := func(symbol string, underlying string, expiration string, cost int) structs.Order {
+		return structs.Order{Symbol: symbol, Maxcost: cost, ProtoOrder: structs.ProtoOrder{Symbol: symbol, Underlying: underlying, Expiration: expiration}}
+	}
+	td := testTrader()
+	td.Positions = map[string]structs.Position{"p1": {Order: order("AAPL1", "AAPL", "20150130", 1000)}}
+	td.orders = map[string]structs.Order{"o1": order("GOOG1", "GOOG", "20150206", 2000)}
+	td.submitted = []int64{timestamp - 7200, timestamp - 60}
+	// Closed yesterday at a 500 loss, and this morning at a 300 loss and a 100 loss on stock.
+	td.PositionHistory = map[string]PostionHistory{
+		"h1": {Open: 10, LimitClose: 5, Volume: 1, Timestamp: timestamp - 24*60*60},
+		"h2": {Open: 10, LimitClose: 7, Volume: 1, Timestamp: timestamp - 60*60},
+		"h3": {Open: 10, Volume: 1}, // Still open.
+		"h4": {Multiplier: 1, Open: 100, LimitClose: 90, Volume: 10, Timestamp: timestamp - 30*60},
+	}
+
+	tests := []struct {
+		limits   RiskLimits
+		order    structs.Order
+		expected string
+	}{
+		{RiskLimits{}, order("AAPL1", "AAPL", "20150130", 1000000), ""},
+		{RiskLimits{NoDuplicates: true}, order("AAPL1", "AAPL", "20150130", 1), DUPLICATE_SYMBOL},
+		{RiskLimits{NoDuplicates: true}, order("GOOG1", "GOOG", "20150130", 1), DUPLICATE_SYMBOL},
+		{RiskLimits{NoDuplicates: true}, order("AAPL2", "AAPL", "20150130", 1), ""},
+		{RiskLimits{MaxOpenPositions: 2}, order("AAPL2", "AAPL", "20150130", 1), MAX_OPEN},
+		{RiskLimits{MaxOpenPositions: 3}, order("AAPL2", "AAPL", "20150130", 1), ""},
+		{RiskLimits{MaxOrders: 1, OrderInterval: 3600}, order("AAPL2", "AAPL", "20150130", 1), MAX_ORDERS},
+		{RiskLimits{MaxOrders: 1, OrderInterval: 30}, order("AAPL2", "AAPL", "20150130", 1), ""},
+		{RiskLimits{MaxDailyLoss: 400}, order("AAPL2", "AAPL", "20150130", 1), MAX_DAILY_LOSS},
+		{RiskLimits{MaxDailyLoss: 401}, order("AAPL2", "AAPL", "20150130", 1), ""},
+		{RiskLimits{MaxWeeklyLoss: 900}, order("AAPL2", "AAPL", "20150130", 1), MAX_WEEKLY_LOSS},
+		{RiskLimits{MaxWeeklyLoss: 901}, order("AAPL2", "AAPL", "20150130", 1), ""},
+		{RiskLimits{MaxPerUnderlying: 1500}, order("AAPL2", "AAPL", "20150206", 501), MAX_UNDERLYING},
+		{RiskLimits{MaxPerUnderlying: 1500}, order("AAPL2", "AAPL", "20150206", 500), ""},
+		{RiskLimits{MaxPerExpiration: 2500}, order("AAPL2", "AAPL", "20150206", 501), MAX_EXPIRATION},
+		{RiskLimits{MaxPerExpiration: 2500}, order("AAPL2", "AAPL", "20150130", 1500), ""},
+	}
+	for idx, test := range tests {
+		td.Risk = test.limits
+		rule := ""
+		if rerr := td.checkRisk(test.order, timestamp); rerr != nil {
+			rule = rerr.Rule
+		}
+		if rule != test.expected {
+			t.Errorf("%d: %+v: Expected: '%s', Got: '%s'", idx, test.limits, test.expected, rule)
+		}
+	}
+}
+
+func Test_Trader_consumePoIn_risk(t *testing.T) {
+	td := testTrader()
+	td.Risk = RiskLimits{NoDuplicates: true}
+	testMoney(td, 100000, 100000)
+
+	po := constructValidOptionProtoOrder(td)
+	po.LimitOpen = 100
+	reply := make(chan any, 2)
+	po.Reply = reply
+	td.PoIn <- po
+	td.PoIn <- po
+	td.consumePoIn(1)
+
+	oid, ok := (<-reply).(string)
+	if !ok || !strings.HasPrefix(oid, "order-") {
+		t.Errorf("Expected first order to go through, Got: %v", oid)
+	}
+	if td.Allotted[oid] != 100000 {
+		t.Errorf("Expected order to hold its allotment, Got: %v", td.Allotted)
+	}
+	rerr, ok := (<-reply).(*RiskError)
+	if !ok || rerr.Rule != DUPLICATE_SYMBOL {
+		t.Fatalf("Expected %s, Got: %v", DUPLICATE_SYMBOL, rerr)
+	}
+	if state, _ := td.Money.State(); len(state.Allotments) != 1 {
+		t.Errorf("Expected rejected order's allotment back, Got: %v", state.Allotments)
+	}
+	events, err := ReadJournal(td.traderDir + "/journal")
+	kinds := []string{}
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+	}
+	expected := []string{EVENT_PROTOORDER, EVENT_ORDER, EVENT_SUBMITTED, EVENT_PROTOORDER, EVENT_ORDER, EVENT_REJECTED}
+	if err != nil || !reflect.DeepEqual(kinds, expected) || events[5].Rule != DUPLICATE_SYMBOL || events[5].Allotment != 100000 {
+		t.Errorf("Expected rejection journaled, Got: %v, Err: %v", events, err)
+	}
+}
+
+func Test_Trader_consumePoIn_risk_filled(t *testing.T) {
+	td := testTrader()
+	td.Risk = RiskLimits{MaxOpenPositions: 2}
+	testMoney(td, 100000, 100000, 100000)
+
+	po := constructValidOptionProtoOrder(td)
+	po.LimitOpen = 100
+	reply := make(chan any, 3)
+	po.Reply = reply
+	td.PoIn <- po
+	td.consumePoIn(1)
+	<-reply
+	td.sync(1)
+	if len(td.Positions) != 1 || len(td.orders) != 0 {
+		t.Fatalf("Expected order filled, Got positions: %v, orders: %v", td.Positions, td.orders)
+	}
+
+	// One position open, so room for one more and no further.
+	td.PoIn <- po
+	td.PoIn <- po
+	td.consumePoIn(2)
+	if oid, ok := (<-reply).(string); !ok {
+		t.Errorf("Expected second order to go through, Got: %v", oid)
+	}
+	rerr, ok := (<-reply).(*RiskError)
+	if !ok || rerr.Rule != MAX_OPEN {
+		t.Errorf("Expected %s, Got: %v", MAX_OPEN, rerr)
+	}
+}
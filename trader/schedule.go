@@ -0,0 +1,60 @@
+package trader
+
+import (
+	"github.com/eliwjones/thebox/util/funcs"
+
+	"sort"
+	"time"
+)
+
+// When Trader will get a look at its positions.
+type Schedule interface {
+	Between(after int64, until int64) []int64 // Times in (after, until], sorted.
+}
+
+// Backtests know every pulse up front.  Must be sorted.
+type PulseSchedule []int64
+
+func (ps PulseSchedule) Between(after int64, until int64) []int64 {
+	start := sort.Search(len(ps), func(i int) bool { return ps[i] > after })
+	end := sort.Search(len(ps), func(i int) bool { return ps[i] > until })
+	if start >= end {
+		return []int64{}
+	}
+	return append([]int64{}, ps[start:end]...)
+}
+
+// Every Period seconds from the open to the close of each trading day, close included.
+type MarketSchedule struct {
+	Period int64
+}
+
+func (ms MarketSchedule) Between(after int64, until int64) []int64 {
+	times := []int64{}
+	for day := time.Unix(after, 0).UTC().Truncate(24 * time.Hour); day.Unix() <= until; day = day.AddDate(0, 0, 1) {
+		open, close, ok := funcs.MarketHours(day)
+		if !ok {
+			continue
+		}
+		for ts := open; ts <= close && ts <= until; ts += ms.Period {
+			if ts > after {
+				times = append(times, ts)
+			}
+		}
+	}
+	return times
+}
+
+// Thins schedule down to times at least interval apart, starting from after.
+func observations(schedule Schedule, after int64, until int64, interval int64) []int64 {
+	thinned := []int64{}
+	last := after
+	for _, ts := range schedule.Between(after, until) {
+		if ts-last < interval {
+			continue
+		}
+		thinned = append(thinned, ts)
+		last = ts
+	}
+	return thinned
+}
@@ -0,0 +1,35 @@
+package trader
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_PulseSchedule_Between(t *testing.T) {
+	ps := PulseSchedule{60, 120, 180, 240, 300}
+	if got := ps.Between(60, 240); !reflect.DeepEqual(got, []int64{120, 180, 240}) {
+		t.Errorf("Expected [120 180 240], Got: %v", got)
+	}
+	if got := ps.Between(300, 600); len(got) != 0 {
+		t.Errorf("Expected nothing, Got: %v", got)
+	}
+	if got := observations(ps, 0, 300, 100); !reflect.DeepEqual(got, []int64{120, 240}) {
+		t.Errorf("Expected [120 240], Got: %v", got)
+	}
+}
+
+func Test_MarketSchedule_Between(t *testing.T) {
+	// Thursday 20150402 20:00 UTC is the close before Good Friday.  Monday opens at 13:30 UTC.
+	ms := MarketSchedule{Period: 30 * 60}
+	got := ms.Between(1428004800-60, 1428327000+30*60)
+	expected := []int64{1428004800, 1428327000, 1428328800}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %v, Got: %v", expected, got)
+	}
+
+	// Monday 14:00 UTC until Friday's close, every 50 minutes: 8 a day, starting at each open after Monday.
+	o := observations(MarketSchedule{Period: 60}, 1422280800, 1422651600, 50*60)
+	if len(o) != 40 || o[8] != 1422369000 || o[len(o)-1] != 1422649200 {
+		t.Errorf("Expected 40 observations, Got: %d starting Tuesday at %d ending %d", len(o), o[8], o[len(o)-1])
+	}
+}
@@ -0,0 +1,194 @@
+package trader
+
+import (
+	"github.com/eliwjones/thebox/money"
+	"github.com/eliwjones/thebox/util/funcs"
+	"github.com/eliwjones/thebox/util/structs"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Bump whenever traderState changes shape, and add a migration from the previous version.
+const stateVersion = 2
+
+// Everything Trader needs to carry on after a restart.
+type traderState struct {
+	Version         int                         `json:"version"`
+	Allotted        map[string]int              `json:"allotted"`
+	Balances        map[string]int              `json:"balances"`
+	CurrentWeekId   int64                       `json:"currentWeekId"`
+	Historae        Historae                    `json:"historae"`
+	LastTimestamp   int64                       `json:"lastTimestamp"`
+	Money           *money.State                `json:"money"`
+	Orders          map[string]structs.Order    `json:"orders"`
+	PositionCount   int                         `json:"positionCount"`
+	PositionHistory map[string]PostionHistory   `json:"positionHistory"`
+	Positions       map[string]structs.Position `json:"positions"`
+	Submitted       []int64                     `json:"submitted"`
+	Trackers        map[string]Tracker          `json:"trackers"`
+	WeekCount       int                         `json:"weekCount"`
+}
+
+// migrations[v] turns a version v state into version v+1.
+var migrations = map[int]func(state map[string]json.RawMessage) error{
+	1: migrateV1,
+}
+
+// Version 1 was json.Marshal(Trader) without a version.  Some keys were cased differently,
+// before Money it held ten fixed allotments and before observation schedules trackers counted down timestamps.
+func migrateV1(state map[string]json.RawMessage) error {
+	renames := map[string]string{"positioncount": "positionCount", "weekcount": "weekCount", "PositionHistory": "positionHistory"}
+	for from, to := range renames {
+		if v, exists := state[from]; exists {
+			state[to] = v
+			delete(state, from)
+		}
+	}
+	// Money starts over from cash without it.
+	delete(state, "allotments")
+
+	if _, exists := state["trackers"]; !exists {
+		return nil
+	}
+	oldTrackers := map[string]trackerV1{}
+	err := json.Unmarshal(state["trackers"], &oldTrackers)
+	if err != nil {
+		return fmt.Errorf("trackers: %s", err)
+	}
+	histories := map[string]PostionHistory{}
+	if v, exists := state["positionHistory"]; exists {
+		err = json.Unmarshal(v, &histories)
+		if err != nil {
+			return fmt.Errorf("positionHistory: %s", err)
+		}
+	}
+	trackers := map[string]Tracker{}
+	for id, old := range oldTrackers {
+		trackers[id] = old.migrate(histories[id].OpenTimestamp)
+	}
+	state["trackers"], err = json.Marshal(trackers)
+	return err
+}
+
+// Tracker before observation schedules.  Distance apart, sampled up to LastSample.
+type trackerV1 struct {
+	Distance      int64
+	LastTimestamp int64
+	LastSample    int64
+	MaxBid        int
+	RemainingTS   int
+	SamplesNeeded int
+	Samples       []int
+}
+
+// Observes every Distance seconds the market is open from opened until expiration, as Trader's defaults would,
+// with whatever came before LastSample already observed.
+func (old trackerV1) migrate(opened int64) Tracker {
+	if opened == 0 {
+		opened = old.LastSample
+	}
+	interval := old.Distance
+	if interval <= 0 {
+		interval = 50 * 60
+	}
+	tracker := Tracker{MaxBid: old.MaxBid, Samples: old.Samples}
+	tracker.Observations = observations(MarketSchedule{Period: 60}, opened, funcs.ExpirationClose(opened), interval)
+	tracker.advance(old.LastSample)
+	return tracker
+}
+
+// Brings state up to stateVersion.  Unknown fields are an error rather than silently dropped.
+func migrateState(data []byte) (traderState, error) {
+	ts := traderState{}
+	raw := map[string]json.RawMessage{}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return ts, err
+	}
+	version := 1
+	if v, exists := raw["version"]; exists {
+		err = json.Unmarshal(v, &version)
+		if err != nil {
+			return ts, fmt.Errorf("bad version: %s", err)
+		}
+	}
+	if version > stateVersion {
+		return ts, fmt.Errorf("state version %d is newer than %d", version, stateVersion)
+	}
+	for ; version < stateVersion; version++ {
+		migrate, exists := migrations[version]
+		if !exists {
+			return ts, fmt.Errorf("no migration from state version %d", version)
+		}
+		err = migrate(raw)
+		if err != nil {
+			return ts, fmt.Errorf("migrating from version %d: %s", version, err)
+		}
+	}
+	raw["version"], _ = json.Marshal(version)
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return ts, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(migrated))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&ts)
+	return ts, err
+}
+
+func (t *Trader) serializeState() ([]byte, error) {
+	ts := traderState{Version: stateVersion, Allotted: t.Allotted, Balances: t.Balances, CurrentWeekId: t.CurrentWeekId,
+		Historae: t.Historae, LastTimestamp: t.lastTimestamp, Orders: t.orders, PositionCount: t.PositionCount,
+		PositionHistory: t.PositionHistory, Positions: t.Positions, Submitted: t.submitted, Trackers: t.Trackers, WeekCount: t.WeekCount}
+	if t.Money != nil {
+		state, err := t.Money.State()
+		if err != nil {
+			return nil, err
+		}
+		ts.Money = &state
+	}
+	return json.Marshal(ts)
+}
+
+// Restores Money too, when the state has it.  Leaves t alone on error.
+func (t *Trader) deserializeState(state []byte) error {
+	ts, err := migrateState(state)
+	if err != nil {
+		return err
+	}
+	if ts.Money != nil {
+		m, err := money.Restore(*ts.Money)
+		if err != nil {
+			return err
+		}
+		t.Money = m
+	}
+	// Maps stay non-nil however old the state.
+	if ts.Allotted != nil {
+		t.Allotted = ts.Allotted
+	}
+	if ts.Orders != nil {
+		t.orders = ts.Orders
+	}
+	if ts.PositionHistory != nil {
+		t.PositionHistory = ts.PositionHistory
+	}
+	if ts.Positions != nil {
+		t.Positions = ts.Positions
+	}
+	if ts.Trackers != nil {
+		t.Trackers = ts.Trackers
+	}
+	t.Balances = ts.Balances
+	t.CurrentWeekId = ts.CurrentWeekId
+	t.Historae = ts.Historae
+	t.lastTimestamp = ts.LastTimestamp
+	t.PositionCount = ts.PositionCount
+	t.submitted = ts.Submitted
+	t.WeekCount = ts.WeekCount
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package trader
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Trader_deserializeState_migrate(t *testing.T) {
+	// As json.Marshal(Trader) wrote it before versions.
+	v1 := `{"allotments":[300000,300000],"balances":{"cash":30000000,"value":30000000},"currentWeekId":1422144000,` +
+		`"historae":{"Histories":null,"Commission":0,"MaxPositionReturns":null,"MaxReturn":0,"PositionCount":0,"PositionReturns":null,"Return":0,"TSdiffs":null},` +
+		`"positions":{},"positioncount":4,"PositionHistory":{"order-1":{"Commission":100,"Open":100,"Symbol":"GOOG_201501_p","Volume":2}},` +
+		`"trackers":{},"weekcount":2}`
+
+	td := testTrader()
+	err := td.deserializeState([]byte(v1))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if td.CurrentWeekId != 1422144000 || td.PositionCount != 4 || td.WeekCount != 2 {
+		t.Errorf("Expected: 1422144000, 4, 2, Got: %d, %d, %d", td.CurrentWeekId, td.PositionCount, td.WeekCount)
+	}
+	expected := map[string]PostionHistory{"order-1": {Commission: 100, Open: 100, Symbol: "GOOG_201501_p", Volume: 2}}
+	if !reflect.DeepEqual(td.PositionHistory, expected) {
+		t.Errorf("Expected: %+v, Got: %+v", expected, td.PositionHistory)
+	}
+	if td.Allotted == nil || td.orders == nil {
+		t.Errorf("Expected maps left usable, Got: %v, %v", td.Allotted, td.orders)
+	}
+
+	// Mid-week with an open position, tracked the old way.
+	v1 = `{"balances":{"cash":30000000},"currentWeekId":1422144000,"positions":{"order-1":{"Id":"order-1","Fillprice":100}},` +
+		`"PositionHistory":{"order-1":{"Open":100,"OpenTimestamp":1422540000,"Symbol":"GOOG_201501_p","Volume":2}},` +
+		`"trackers":{"order-1":{"Distance":3000,"LastTimestamp":1422651600,"LastSample":1422547800,"MaxBid":150,"RemainingTS":30,"SamplesNeeded":20,"Samples":[120,150]}},` +
+		`"positioncount":1,"weekcount":2}`
+	td = testTrader()
+	err = td.deserializeState([]byte(v1))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	tracker := td.Trackers["order-1"]
+	if tracker.MaxBid != 150 || !reflect.DeepEqual(tracker.Samples, []int{120, 150}) || tracker.Observed != 2 {
+		t.Errorf("Expected MaxBid: 150, Samples: [120 150], Observed: 2, Got: %+v", tracker)
+	}
+	// Every 3000 seconds the market is open, through Friday's close.
+	if len(tracker.Observations) == 0 || tracker.Observations[0] != 1422549000 || tracker.Observations[len(tracker.Observations)-1] > 1422651600 {
+		t.Errorf("Expected observations from 1422549000 until 1422651600, Got: %v", tracker.Observations)
+	}
+	if len(td.Positions) != 1 || td.PositionHistory["order-1"].OpenTimestamp != 1422540000 {
+		t.Errorf("Expected position and history kept, Got: %v, %v", td.Positions, td.PositionHistory)
+	}
+
+	tests := []struct {
+		state    string
+		expected string
+	}{
+		{`{"version":3}`, "newer than"},
+		{`{"version":2,"allotments":[1]}`, "unknown field"},
+		{`{"version":0}`, "no migration"},
+	}
+	for idx, test := range tests {
+		td := testTrader()
+		err := td.deserializeState([]byte(test.state))
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%d: Expected error containing: '%s', Got: %v", idx, test.expected, err)
+		}
+	}
+}
@@ -2,23 +2,25 @@ package trader
 
 import (
 	"github.com/eliwjones/thebox/collector"
+	"github.com/eliwjones/thebox/money"
 	"github.com/eliwjones/thebox/util"
 	"github.com/eliwjones/thebox/util/funcs"
 	"github.com/eliwjones/thebox/util/interfaces"
 	"github.com/eliwjones/thebox/util/structs"
 
-	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
+	"maps"
 	"os"
-	"time"
+	"sort"
 )
 
 type PostionHistory struct {
 	Commission    int    // How much is commission to open trade (presumably would be same to close.)
 	Closed        bool   // Did position successfully close?
+	ExitRule      string // Exit policy that closed it, e.g. "target:multiple=5".  Empty if it expired.
 	LimitClose    int    // Limit for closing position.
+	Multiplier    int    // Contract multiplier.  Zero in histories from before it was kept, which were all options.
 	MaxClose      int    // What does GetMax(timestamp, underlying, symbol) show was MaxBid.
 	MaxTimestamp  int64  // When did MaxBid occur.
 	Open          int    // Open price for position.
@@ -174,48 +176,73 @@ func (t *Trader) FinalizeHistorae(startCash int) {
 	t.Historae.TSdiffs = tsdiffs
 }
 
+// Per open position, for exit policies.
 type Tracker struct {
-	Distance      int64 // How far apart should timestamps be?
-	LastTimestamp int64 // Need to know when to look for max.
-	LastSample    int64 // What is timestamp when we last sampled?
-	RemainingTS   int   // How many left so can calculate backoff.
-	SamplesNeeded int   // How many should we sample before seeking Max?
-	Samples       []int // History of sampled Bids.
+	MaxBid       int     // Highest Bid seen so far.
+	Observations []int64 // Times still to look at the Bid, until expiration.
+	Observed     int     // Observation times passed, including any that fell in a gap between pulses.
+	Samples      []int   // Bids at past observations.
+}
+
+// Moves past observation times up to timestamp.  True if timestamp is an observation.
+func (tr *Tracker) advance(timestamp int64) bool {
+	passed := 0
+	for passed < len(tr.Observations) && tr.Observations[passed] <= timestamp {
+		passed += 1
+	}
+	tr.Observations = tr.Observations[passed:]
+	tr.Observed += passed
+	return passed > 0
 }
 
 type Trader struct {
-	adapter         interfaces.Adapter                   `json:"-"`                  // Adapter already connected to "Broker".
-	Allotments      []int                                `json:"allotments"`         // Placeholder .. not sure how will handle allotments.
-	Balances        map[string]int                       `json:"balances"`           // Not sure on wisdom of rolling Money into Trader, but we shall see.
-	c               *collector.Collector                 `json:"-"`                  // For collector.GetQuote()
-	commission      map[util.ContractType]map[string]int `json:"-"`                  // commission fees per type for base, unit.
-	CurrentWeekId   int64                                `json:"currentWeekId"`      // When am I?
-	dataDir         string                               `json:"-"`                  // Where am I?
-	Historae        Historae                             `json:"historae,omitempty"` // Struct with all my History info.
-	id              string                               `json:"-"`                  // Who am I?
-	multiplier      map[util.ContractType]int            `json:"-"`                  // Stocks trade in units of 1, Options in units of 100.
-	orders          map[string]structs.Order             `json:"-"`                  // Open (Closed?) orders.
-	PoIn            chan structs.ProtoOrder              `json:"-"`                  // Generally, ProtoOrders coming in.
-	Positions       map[string]structs.Position          `json:"positions"`          // Current outstanding positions.
-	PositionCount   int                                  `json:"positioncount"`      // How many Positions have I opened?
+	adapter         interfaces.Adapter                   // Adapter already connected to "Broker".
+	Allotted        map[string]int                       // Allotment behind each order id, until its position settles.
+	Balances        map[string]int                       // Not sure on wisdom of rolling Money into Trader, but we shall see.
+	c               *collector.Collector                 // For collector.GetQuote()
+	commission      map[util.ContractType]map[string]int // commission fees per type for base, unit.
+	CurrentWeekId   int64                                // When am I?
+	dataDir         string                               // Where am I?
+	Exits           ExitPolicy                           // When to close positions.  Defaults to secretary_backoff.
+	Historae        Historae                             // Struct with all my History info.
+	id              string                               // Who am I?
+	Interval        int64                                // Exit policies observe positions at most this often, in seconds.
+	lastTimestamp   int64                                // Last pulse seen, for finalizing histories at the week's end.
+	Money           *money.Money                         // Where allotments come from and go back to.
+	multiplier      map[util.ContractType]int            // Stocks trade in units of 1, Options in units of 100.
+	orders          map[string]structs.Order             // Open (Closed?) orders.
+	PoIn            chan structs.ProtoOrder              // Generally, ProtoOrders coming in.
+	Positions       map[string]structs.Position          // Current outstanding positions.
+	PositionCount   int                                  // How many Positions have I opened?
 	PositionHistory map[string]PostionHistory            // Information pertaining to open, close, commission, max.
-	Pulses          chan int64                           `json:"-"`         // timestamps from pulsar come here.
-	PulsarReply     chan int64                           `json:"-"`         // Reply back to Pulsar when done doing work.
-	Trackers        map[string]Tracker                   `json:"trackers"`  // Sampled bids for currently open positions.  Used for Optimal Stopping.
-	traderDir       string                               `json:"-"`         // Where to save information pertaining to this instance of trader.
-	WeekCount       int                                  `json:"weekcount"` // Count weeks I have seen.
+	Pulses          chan int64                           // timestamps from pulsar come here.
+	PulsarReply     chan int64                           // Reply back to Pulsar when done doing work.
+	Risk            RiskLimits                           // Checked before every order.  Zero value checks nothing.
+	Schedule        Schedule                             // When pulses will come.  Defaults to every minute the market is open.
+	submitted       []int64                              // When orders went out, for Risk.MaxOrders.
+	Trackers        map[string]Tracker                   // Sampled bids for currently open positions.  Used for Optimal Stopping.
+	traderDir       string                               // Where to save information pertaining to this instance of trader.
+	WeekCount       int                                  // Count weeks I have seen.
 }
 
 func New(id string, dataDir string, adapter interfaces.Adapter, c *collector.Collector) *Trader {
 	t := &Trader{id: id, dataDir: dataDir}
 
+	t.Allotted = map[string]int{}
+
 	t.adapter = adapter
 	t.c = c
 	t.commission = adapter.Commission()
+	t.Exits, _ = ParseExits("secretary_backoff")
+	t.Interval = 50 * 60
+	t.Schedule = MarketSchedule{Period: 60}
 	t.multiplier = adapter.ContractMultiplier()
 	t.PositionHistory = map[string]PostionHistory{}
 	t.Positions = map[string]structs.Position{}
-	t.orders, _ = t.adapter.GetOrders("")
+	// Copies, since orders submitted here get added before the adapter reports them.
+	currentorders, _ := t.adapter.GetOrders("")
+	t.orders = map[string]structs.Order{}
+	maps.Copy(t.orders, currentorders)
 
 	t.PoIn = make(chan structs.ProtoOrder, 1000)
 	t.Pulses = make(chan int64, 1000)
@@ -223,55 +250,73 @@ func New(id string, dataDir string, adapter interfaces.Adapter, c *collector.Col
 	t.Trackers = map[string]Tracker{}
 	t.traderDir = fmt.Sprintf("%s/%s/trader", t.dataDir, t.id)
 
-	// Ambivalent about need for big, official SerializeAndSaveState() functions..
-	serializedState, _ := os.ReadFile(t.traderDir + "/state")
-	t.deserializeState(serializedState)
+	// Restores Money before sync() so positions that closed while down can settle.
+	serializedState, err := os.ReadFile(t.traderDir + "/state")
+	if err == nil {
+		err = t.deserializeState(serializedState)
+		if err != nil {
+			fmt.Printf("[Trader] Ignoring saved state: %s\n", err)
+		}
+	}
 
 	// Sync may overwrite saved state since adapter is source of truth.
 	t.sync(int64(-1))
 	// If trade comes in on first timestamp.. need to already have Allotments initialized..
-	t.Allotments = allotments(t.Balances["cash"], t.Balances["value"])
+	if t.Money == nil {
+		// Ten 1% allotments a week, same as before Money.
+		t.Money = money.New(t.Balances["cash"], 10)
+	}
 
 	// Sync Orders, Positions and reap Deltas from t.adapter?
 	go func() {
-		lastTimestamp := int64(0)
 		for timestamp := range t.Pulses {
 			weekID := funcs.WeekID(timestamp)
 			if t.CurrentWeekId != weekID && timestamp != -1 {
 				// Reset any open Positions as they have expired worthless.
-				t.Positions = map[string]structs.Position{}
+				filled := map[string]bool{}
+				for _, id := range sortedKeys(t.Positions) {
+					t.settle(id)
+					filled[t.Positions[id].Order.Id] = true
+				}
 				t.adapter.Reset()
 
-				t.WeekCount += 1
-
-				// init or get allotments.
-				t.Allotments = allotments(t.Balances["cash"], t.Balances["value"])
-				// Anything to log if Tracker is non-empty?
-				// Generally would mean at least one position expired worthless.
-				t.Trackers = map[string]Tracker{}
+				// Orders that never filled spent nothing.
+				for _, oid := range sortedKeys(t.Allotted) {
+					if filled[oid] {
+						continue
+					}
+					t.Money.Settle(structs.Allotment{Amount: t.Allotted[oid]}, 0)
+				}
+				t.Money.ReAllot()
 
 				// Finalize Histories.
+				histories := map[string]PostionHistory{}
 				for id, history := range t.PositionHistory {
 					if history.UltimateTS != 0 {
 						continue
 					}
-					history.UltimateTS = lastTimestamp
+					history.UltimateTS = t.lastTimestamp
 					maximum, err := t.c.GetMaximum(history.OpenTimestamp, history.Symbol)
 					if err == nil {
 						history.MaxClose = maximum.MaximumBid
 						history.MaxTimestamp = maximum.MaxTimestamp
 					}
-					t.PositionHistory[id] = history
+					histories[id] = history
 				}
 
-				t.CurrentWeekId = weekID
+				t.record(Event{Kind: EVENT_EXPIRY, Timestamp: timestamp, WeekID: weekID, Histories: histories})
 			}
 			t.consumePoIn(timestamp)
 
 			if timestamp == -1 {
 				// Save State.
-				serializedState, _ := json.Marshal(t)
-				funcs.LazyWriteFile(t.traderDir, "state", serializedState)
+				serializedState, err := t.serializeState()
+				if err == nil {
+					err = funcs.AtomicWriteFile(t.traderDir, "state", serializedState)
+				}
+				if err != nil {
+					fmt.Printf("[Trader] Failed to save state: %s\n", err)
+				}
 
 				t.PulsarReply <- timestamp
 				return
@@ -279,7 +324,9 @@ func New(id string, dataDir string, adapter interfaces.Adapter, c *collector.Col
 
 			t.sync(timestamp)
 
-			for positionId, tracker := range t.Trackers {
+			// Sorted so the journal comes out the same every run.
+			for _, positionId := range sortedKeys(t.Trackers) {
+				tracker := t.Trackers[positionId]
 				// Get quote for option symbol for current timestamp from collector.
 				// Will need to fix collector.GetQuotes(underlying, timestamp) and add GetQuote(symbol, underlying, timestamp)
 				p := t.Positions[positionId]
@@ -290,23 +337,24 @@ func New(id string, dataDir string, adapter interfaces.Adapter, c *collector.Col
 					//panic("What broke?")
 					continue
 				}
-				stopv1 := t.optimalStopV1(timestamp, tracker, positionId, q)
-				stopv2 := false // t.optimalStopV2(timestamp, tracker, positionId, q)
-
-				if stopv1 || stopv2 {
-					t.adapter.ClosePosition(positionId, q.Bid)
-
-					// Add new info to Histories.
-					history := t.PositionHistory[positionId]
-					history.LimitClose = q.Bid
-					history.Timestamp = timestamp
-					t.PositionHistory[positionId] = history
+				before := tracker
+				rule := checkExit(t.Exits, timestamp, p, q, &tracker)
+				// Only observations and new highs change anything.
+				if tracker.Observed != before.Observed || tracker.MaxBid != before.MaxBid {
+					t.record(Event{Kind: EVENT_SAMPLE, Timestamp: timestamp, PositionID: positionId, Bid: q.Bid, Tracker: &tracker})
+				}
 
-					logLine := fmt.Sprintf("%d,order-close,%s,%d", timestamp, positionId, q.Bid)
-					funcs.LazyAppendFile(t.traderDir, "log", logLine)
+				if rule != "" {
+					err = t.adapter.ClosePosition(positionId, q.Bid)
+					if err != nil {
+						// Still open, so tries again next pulse.
+						t.record(Event{Kind: EVENT_REJECTED, Timestamp: timestamp, PositionID: positionId, Bid: q.Bid, Rule: rule, Error: err.Error()})
+						continue
+					}
+					t.record(Event{Kind: EVENT_CLOSE, Timestamp: timestamp, PositionID: positionId, Bid: q.Bid, Rule: rule})
 				}
 			}
-			lastTimestamp = timestamp
+			t.lastTimestamp = timestamp
 			t.PulsarReply <- timestamp
 		}
 	}()
@@ -314,6 +362,17 @@ func New(id string, dataDir string, adapter interfaces.Adapter, c *collector.Col
 	return t
 }
 
+// Advances tracker to timestamp and asks exits whether to close.
+func checkExit(exits ExitPolicy, timestamp int64, p structs.Position, q structs.Option, tracker *Tracker) string {
+	tracker.MaxBid = max(tracker.MaxBid, q.Bid)
+	observation := tracker.advance(timestamp)
+	rule := exits.Check(Exit{Timestamp: timestamp, Position: p, Quote: q, Observation: observation, Tracker: tracker})
+	if observation {
+		tracker.Samples = append(tracker.Samples, q.Bid)
+	}
+	return rule
+}
+
 func (t *Trader) constructOrder(po structs.ProtoOrder, allotment int) (structs.Order, error) {
 	o := structs.Order{Symbol: po.Symbol, Type: po.Type}
 	o.ProtoOrder = po
@@ -334,63 +393,53 @@ func (t *Trader) constructOrder(po structs.ProtoOrder, allotment int) (structs.O
 func (t *Trader) consumePoIn(timestamp int64) {
 	for len(t.PoIn) > 0 {
 		po := <-t.PoIn
-		allotment := 0
-		if len(t.Allotments) > 0 {
-			allotment, t.Allotments = t.Allotments[0], t.Allotments[1:]
-		}
-		o, err := t.constructOrder(po, allotment)
+		t.record(Event{Kind: EVENT_PROTOORDER, Timestamp: timestamp, ProtoOrder: &po})
+		// Zero if none are left, which constructOrder refuses.
+		allotment, _ := t.Money.Get()
+		o, err := t.constructOrder(po, allotment.Amount)
 		if err != nil {
+			t.Money.Put(allotment, true)
+			rerr := &RiskError{Rule: CONSTRUCT_FAILED, Detail: err.Error()}
+			t.record(Event{Kind: EVENT_REJECTED, Timestamp: timestamp, Allotment: allotment.Amount, Rule: rerr.Rule, Error: rerr.Error()})
+			if po.Reply != nil {
+				po.Reply <- rerr
+			}
+			continue
+		}
+		t.record(Event{Kind: EVENT_ORDER, Timestamp: timestamp, Order: &o, Allotment: allotment.Amount})
+		if rerr := t.checkRisk(o, timestamp); rerr != nil {
+			// Nothing spent, so allotment goes back.
+			t.Money.Put(allotment, true)
+			t.record(Event{Kind: EVENT_REJECTED, Timestamp: timestamp, Order: &o, Allotment: allotment.Amount, Rule: rerr.Rule, Error: rerr.Error()})
 			if po.Reply != nil {
-				po.Reply <- po
+				po.Reply <- rerr
 			}
 			continue
 		}
 
 		// Submit order for execution.
 		oid, err := t.adapter.SubmitOrder(o)
-		// Log order submission.
 		o.Id = oid
-		encodedOrder, _ := funcs.Encode(&o, funcs.OrderEncodingOrder)
 		if err != nil {
-			encodedOrder = fmt.Sprintf("%d,error,%s,%s", timestamp, err, encodedOrder)
-		} else {
-			encodedOrder = fmt.Sprintf("%d,order,%s", timestamp, encodedOrder)
+			t.Money.Put(allotment, true)
+			rerr := &RiskError{Rule: SUBMIT_FAILED, Detail: err.Error()}
+			t.record(Event{Kind: EVENT_REJECTED, Timestamp: timestamp, Order: &o, Allotment: allotment.Amount, Rule: rerr.Rule, Error: rerr.Error()})
+			if po.Reply != nil {
+				po.Reply <- rerr
+			}
+			continue
 		}
-		funcs.LazyAppendFile(t.traderDir, "log", encodedOrder)
-
+		t.record(Event{Kind: EVENT_SUBMITTED, Timestamp: timestamp, Order: &o, Allotment: allotment.Amount})
 		if po.Reply != nil {
-			if err != nil {
-				po.Reply <- false
-			} else {
-				po.Reply <- oid
-			}
+			po.Reply <- oid
 		}
 	}
 }
 
-func (t *Trader) deserializeState(state []byte) error {
-	// Load allotments, currentWeekId, positions
-	dt := &Trader{}
-	err := json.Unmarshal(state, &dt)
-
-	if err != nil {
-		return err
-	}
-	t.Allotments = dt.Allotments
-	t.Balances = dt.Balances
-	t.CurrentWeekId = dt.CurrentWeekId
-	t.Historae = dt.Historae
-	t.Positions = dt.Positions
-	t.PositionCount = dt.PositionCount
-	t.Trackers = dt.Trackers
-	t.WeekCount = dt.WeekCount
-
-	return nil
-}
-
 func (t *Trader) initHistory(p structs.Position, timestamp int64) {
 	history := PostionHistory{}
 	history.Commission = p.Commission
+	history.Multiplier = t.multiplier[p.Order.Type]
 	history.Open = p.Fillprice
 	history.OpenTimestamp = timestamp
 	history.Symbol = p.Order.Symbol
@@ -400,87 +449,10 @@ func (t *Trader) initHistory(p structs.Position, timestamp int64) {
 	t.PositionHistory[p.Id] = history
 }
 
-func (t *Trader) initTracking(p structs.Position, timestamp int64) {
-	// Fake it by estimating.
-	// 40 per day. (assuming 10 min interval.)
-	distance := int(time.Friday) - int(time.Unix(timestamp, 0).Weekday())
-	timestamps := distance * 40
-
-	// Plus however many seconds it is until 16:00:00 "today".
-	// Again, all assuming 10 minute intervals.
-	hour, min, _ := time.Unix(timestamp, 0).Clock()
-	if hour < 16 {
-		timestamps += (16-(hour+1))*6 + (60-min)/10
-	}
-
-	timestamps -= 2             // Feels like this is necessary.
-	timestamps = timestamps / 5 // Really just want 50 minute intervals.
-	interval := int64(50 * 60)  // 50 minutes in seconds.
-
-	tracker := Tracker{Distance: interval, RemainingTS: timestamps}
-	tracker.SamplesNeeded = int(float64(timestamps) / math.Exp(1))
-	tracker.SamplesNeeded = int(float64(timestamps) / 1.5)
-
-	tracker.Samples = []int{p.Fillprice}
-	tracker.LastSample = timestamp
-	tracker.LastTimestamp = timestamp
-	_, exists := t.Trackers[p.Id]
-	if exists {
-		panic("Someone fucked something up. Either am getting duplicate Order/Position IDs or a Position has disappeared and re-appeared.")
-	}
-	t.Trackers[p.Id] = tracker
-}
-
-func (t *Trader) optimalStopV1(timestamp int64, tracker Tracker, positionId string, q structs.Option) bool {
-	if timestamp-tracker.LastTimestamp < tracker.Distance {
-		// Not enough time has passed, so move along.
-		return false
-	}
-	tracker.LastTimestamp = timestamp
-	tracker.RemainingTS -= 1
-
-	//p := t.Positions[positionId]
-
-	if tracker.SamplesNeeded <= 0 { // || timestamp > p.Order.ProtoOrder.LimitTS {
-		// Check if current bid is greater than max(tracker.Samples)
-		isMax := true
-
-		// Poor Man's Backoff.
-		canBeLessThan := len(tracker.Samples) - tracker.RemainingTS/2
-
-		for _, bid := range tracker.Samples {
-			if bid >= q.Bid {
-				if canBeLessThan > 0 {
-					canBeLessThan -= 1
-					continue
-				}
-				// Can't be max since sampled item is bigger.
-				// Could use a labeled break, but feels wrong.
-				isMax = false
-				break
-			}
-		}
-		t.Trackers[positionId] = tracker
-		if isMax {
-			return true
-		}
-	}
-	if tracker.SamplesNeeded > 0 {
-		tracker.Samples = append(tracker.Samples, q.Bid)
-
-		tracker.SamplesNeeded -= 1 // Not correcting for holidays or gaps, so be warned.
-		tracker.LastSample = timestamp
-
-		t.Trackers[positionId] = tracker
-	}
-	return false
-}
-
-func (t *Trader) optimalStopV2(timestamp int64, tracker Tracker, positionId string, q structs.Option) bool {
-	if (q.Bid / t.Positions[positionId].Fillprice) >= 5 {
-		return true
-	}
-	return false
+func (t *Trader) newTracker(p structs.Position, timestamp int64) Tracker {
+	tracker := Tracker{MaxBid: p.Fillprice}
+	tracker.Observations = observations(t.Schedule, timestamp, funcs.ExpirationClose(timestamp), t.Interval)
+	return tracker
 }
 
 func (t *Trader) sync(timestamp int64) {
@@ -492,12 +464,14 @@ func (t *Trader) sync(timestamp int64) {
 	// Reconcile Orders, Positions.
 	currentorders, err := t.adapter.GetOrders("")
 	if err == nil {
-		t.orders = currentorders
+		t.orders = map[string]structs.Order{}
+		maps.Copy(t.orders, currentorders)
 	}
 	currentpositions, err := t.adapter.GetPositions()
 	if err == nil {
 		// Add new positions.
-		for id, p := range currentpositions {
+		for _, id := range sortedKeys(currentpositions) {
+			p := currentpositions[id]
 			_, found := t.Positions[id]
 			if found {
 				continue
@@ -505,35 +479,53 @@ func (t *Trader) sync(timestamp int64) {
 			// This is a new position.
 			// Initialize tracker with counter so can start watching Bids.
 			fmt.Printf("\n[Trader] New Positions: %v\n", p)
-			t.initTracking(p, timestamp)
-			t.initHistory(p, timestamp)
-			t.Positions[id] = p
-			t.PositionCount += 1
+			tracker := t.newTracker(p, timestamp)
+			t.record(Event{Kind: EVENT_FILLED, Timestamp: timestamp, Position: &p, Tracker: &tracker})
 		}
 		// Delete old positions and trackers
-		for id := range t.Positions {
+		// Sorted since settling may draw from Money's Rand.
+		for _, id := range sortedKeys(t.Positions) {
 			_, found := currentpositions[id]
 			if found {
 				continue
 			}
-			delete(t.Positions, id)
-			delete(t.Trackers, id)
-
-			// Is it really necessary to always do this dance with a map[string]struct{} ?
-			history := t.PositionHistory[id]
-			history.Closed = true
-			t.PositionHistory[id] = history
+			t.settle(id)
+			t.record(Event{Kind: EVENT_CLOSED, Timestamp: timestamp, PositionID: id})
 		}
 	}
 }
 
-func allotments(cash int, value int) []int {
-	a := cash / 100
-	// 10 1% allotments
-	allotments := []int{}
-	count := min(cash/a, 10)
-	for range count {
-		allotments = append(allotments, a)
+// Hands position id's allotment back to Money along with what it made.
+// Allotted is left to EVENT_CLOSED or EVENT_EXPIRY, so call once just before recording one of those.
+func (t *Trader) settle(id string) {
+	p := t.Positions[id]
+	allotment, exists := t.Allotted[p.Order.Id]
+	if !exists || t.Money == nil {
+		return
+	}
+
+	t.Money.Settle(structs.Allotment{Amount: allotment}, t.PositionHistory[id].delta())
+}
+
+// What the position made, net of commissions.  Negative if it lost.
+func (h PostionHistory) delta() int {
+	multiplier := h.Multiplier
+	if multiplier == 0 {
+		multiplier = 100
+	}
+	delta := h.Volume*multiplier*(h.LimitClose-h.Open) - h.Commission
+	if h.LimitClose > 0 {
+		// Paid again to close.
+		delta -= h.Commission
+	}
+	return delta
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
 	}
-	return allotments
+	sort.Strings(keys)
+	return keys
 }
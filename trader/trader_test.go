@@ -3,10 +3,13 @@ package trader
 import (
 	"github.com/eliwjones/thebox/adapter/simulate"
 	"github.com/eliwjones/thebox/collector"
+	"github.com/eliwjones/thebox/money"
 	"github.com/eliwjones/thebox/util"
+	"github.com/eliwjones/thebox/util/funcs"
 	"github.com/eliwjones/thebox/util/structs"
 
-	"encoding/json"
+	"bytes"
+	"errors"
 	"os"
 	"reflect"
 	"strings"
@@ -40,6 +43,14 @@ func testTrader() *Trader {
 	return New("test-id", "testDir", simulate.New("simulate", "simulation", 300000*100), c)
 }
 
+// Swaps td.Money for one holding exactly amounts.
+func testMoney(td *Trader, amounts ...int) {
+	td.Money = money.New(0, 10)
+	for _, amount := range amounts {
+		td.Money.Put(structs.Allotment{Amount: amount}, true)
+	}
+}
+
 func Test_Trader_New(t *testing.T) {
 	td := testTrader()
 	if td == nil {
@@ -56,7 +67,7 @@ func Test_Trader_constructOrder_Option(t *testing.T) {
 
 	o, err := td.constructOrder(po, allotment)
 	if err != nil {
-		t.Errorf("Should be able to fill this order: %+v, Allotment: %d", o, td.Allotments[0])
+		t.Errorf("Should be able to fill this order: %+v, Allotment: %d", o, allotment)
 	}
 
 	po.LimitOpen++
@@ -97,7 +108,9 @@ func Test_Trader_Processor_ProtoOrder(t *testing.T) {
 
 	po := constructValidStockProtoOrder(td)
 	minCommission := td.commission[util.STOCK]["base"] + td.commission[util.STOCK]["unit"]
-	td.Allotments = []int{po.LimitOpen*td.multiplier[util.STOCK] + minCommission}
+	testMoney(td, po.LimitOpen*td.multiplier[util.STOCK]+minCommission)
+	// Else the new week re-allots.
+	td.CurrentWeekId = funcs.WeekID(1)
 
 	reply := make(chan any)
 
@@ -112,14 +125,26 @@ func Test_Trader_Processor_ProtoOrder(t *testing.T) {
 		t.Errorf("Expected: order-*, Got: %s!", response.(string))
 	}
 
-	// Invalid ProtoOrder should be sent back.
-	td.Allotments = []int{po.LimitOpen*td.multiplier[util.STOCK] + minCommission}
+	// Every rejection replies with a RiskError.
 	po.LimitOpen++
 	td.PoIn <- po
 	td.Pulses <- int64(1)
-	response = <-reply
-	if response != po {
-		t.Errorf("Expected: %+v, Got: %+v!", po, response)
+	rerr, ok := (<-reply).(*RiskError)
+	if !ok || rerr.Rule != CONSTRUCT_FAILED {
+		t.Errorf("Expected %s, Got: %v", CONSTRUCT_FAILED, rerr)
+	}
+
+	po.LimitOpen--
+	// Let both pulses finish before changing things underneath them.
+	<-td.PulsarReply
+	<-td.PulsarReply
+	testMoney(td, po.LimitOpen*td.multiplier[util.STOCK]+minCommission)
+	td.adapter.(*simulate.Simulate).Token = "expired"
+	td.PoIn <- po
+	td.Pulses <- int64(1)
+	rerr, ok = (<-reply).(*RiskError)
+	if !ok || rerr.Rule != SUBMIT_FAILED {
+		t.Errorf("Expected %s, Got: %v", SUBMIT_FAILED, rerr)
 	}
 }
 
@@ -128,33 +153,39 @@ func Test_Trader_serializeState_deserializeState(t *testing.T) {
 
 	td := testTrader()
 
-	td.CurrentWeekId = int64(9999)
-	td.Allotments = allotments(td.Balances["cash"], td.Balances["value"])
-
-	po := structs.ProtoOrder{Symbol: "GOOG_201501_p", Type: util.OPTION, LimitOpen: 100, Timestamp: int64(1)}
-	o, err := td.constructOrder(po, td.Allotments[0])
+	// Mid-week, with one position open and one order waiting.
+	td.CurrentWeekId = int64(1422144000)
+	allotment, _ := td.Money.Get()
+	po := structs.ProtoOrder{Symbol: "GOOG_201501_p", Type: util.OPTION, LimitOpen: 100, Timestamp: int64(1422540000), Underlying: "GOOG"}
+	o, err := td.constructOrder(po, allotment.Amount)
 	if err != nil {
 		t.Errorf("Expected Order.  Err: %s", err)
 	}
-	td.Positions = map[string]structs.Position{}
+	o.Id = "order-1"
+	td.Allotted["order-1"] = allotment.Amount
 	td.Positions["order-1"] = structs.Position{Id: "order-1", Fillprice: o.Limitprice, Order: o}
-
-	st, err := json.Marshal(td)
+	td.PositionHistory["order-1"] = PostionHistory{Commission: 100, Open: 100, OpenTimestamp: 1422540000, Symbol: po.Symbol, Underlying: "GOOG", Volume: o.Volume}
+	td.Trackers["order-1"] = Tracker{MaxBid: 150, Observations: []int64{1422543000, 1422546000}, Observed: 2, Samples: []int{120, 150}}
+	td.orders["order-2"] = structs.Order{Id: "order-2", Symbol: "GOOG_201501_c", Type: util.OPTION}
+	td.submitted = []int64{1422540000, 1422540060}
+	td.lastTimestamp = 1422540060
+	td.PositionCount = 1
+	td.WeekCount = 3
+
+	st, err := td.serializeState()
 	if err != nil {
 		t.Errorf("%s", err)
 	}
 	td2 := testTrader()
-	td2.deserializeState(st)
-
-	// Verify td2 received state.
-	if td2.CurrentWeekId != td.CurrentWeekId {
-		t.Errorf("Expected: %d, Got: %d", td.CurrentWeekId, td2.CurrentWeekId)
-	}
-	if !reflect.DeepEqual(td2.Allotments, td.Allotments) {
-		t.Errorf("Expected: %v, Got: %v", td.Allotments, td2.Allotments)
+	err = td2.deserializeState(st)
+	if err != nil {
+		t.Fatalf("%s", err)
 	}
-	if !reflect.DeepEqual(td2.Positions, td.Positions) {
-		t.Errorf("\nExpected: %v\nGot: %v", td.Positions, td2.Positions)
+
+	// Verify td2 received all of it.
+	st2, _ := td2.serializeState()
+	if !bytes.Equal(st2, st) {
+		t.Errorf("\nExpected: %s\nGot: %s", st, st2)
 	}
 
 	// Initiate built-in state saving.
@@ -163,18 +194,77 @@ func Test_Trader_serializeState_deserializeState(t *testing.T) {
 	<-td2.PulsarReply
 
 	a := simulate.New("simulate", "simulation", 300000*100)
-	// Adapter is source of truth for Positions so add them.
+	// Adapter is source of truth for Orders and Positions so add them.
+	a.Orders = td2.orders
 	a.Positions = td2.Positions
 	c := collector.New("test", "../testdata", int64(60))
 	td3 := New("test-id", "testDir", a, c)
 	// Verify td3 received state.
-	if td3.CurrentWeekId != td.CurrentWeekId {
-		t.Errorf("Expected: %d, Got: %d", td.CurrentWeekId, td3.CurrentWeekId)
+	st3, _ := td3.serializeState()
+	if !bytes.Equal(st3, st) {
+		t.Errorf("\nExpected: %s\nGot: %s", st, st3)
+	}
+}
+
+func Test_Trader_settle(t *testing.T) {
+	tests := []struct {
+		limitClose int
+		expected   money.State
+	}{
+		// Closed at 300: 1*100*(300-100) less 100 commission each way.
+		{300, money.State{Total: 19800, Available: 20000, Allotments: []structs.Allotment{{Amount: 20000}}, Count: 10, DeltaSum: 19800}},
+		// Expired: 1*100*(0-100) less 100 commission to open.
+		{0, money.State{Total: -10100, Available: 9900, Allotments: []structs.Allotment{{Amount: 9900}}, Count: 10}},
 	}
-	if !reflect.DeepEqual(td3.Allotments, td.Allotments) {
-		t.Errorf("Expected: %v, Got: %v", td.Allotments, td3.Allotments)
+	for idx, test := range tests {
+		td := testTrader()
+		testMoney(td)
+		td.Positions["order-1"] = structs.Position{Id: "order-1", Order: structs.Order{Id: "order-1", Type: util.OPTION}}
+		td.PositionHistory["order-1"] = PostionHistory{Commission: 100, LimitClose: test.limitClose, Open: 100, Volume: 1}
+		td.Allotted["order-1"] = 20000
+
+		td.settle("order-1")
+		state, _ := td.Money.State()
+		state.Rand = nil
+		if !reflect.DeepEqual(state, test.expected) {
+			t.Errorf("%d: Expected: %+v, Got: %+v", idx, test.expected, state)
+		}
+		// Bookkeeping is left to apply().
+		if td.Allotted["order-1"] != 20000 {
+			t.Errorf("%d: Expected allotment left for EVENT_CLOSED, Got: %v", idx, td.Allotted)
+		}
+		td.record(Event{Kind: EVENT_CLOSED, PositionID: "order-1"})
+		if len(td.Allotted) != 0 {
+			t.Errorf("%d: Expected allotment settled, Got: %v", idx, td.Allotted)
+		}
+
+		// Only settles once.
+		td.settle("order-1")
+		state2, _ := td.Money.State()
+		state2.Rand = nil
+		if !reflect.DeepEqual(state2, state) {
+			t.Errorf("%d: Expected: %+v, Got: %+v", idx, state, state2)
+		}
 	}
-	if !reflect.DeepEqual(td3.Positions, td.Positions) {
-		t.Errorf("\nExpected: %v\nGot: %v", td.Positions, td3.Positions)
+}
+
+func Test_Trader_saveState_interrupted(t *testing.T) {
+	td := testTrader()
+	td.CurrentWeekId = int64(1111)
+	td.Pulses <- int64(-1)
+	<-td.PulsarReply
+
+	// Crash while saving newer state.
+	c := collector.New("test", "../testdata", int64(60))
+	td2 := New("test-id", "testDir", simulate.New("simulate", "simulation", 300000*100), c)
+	td2.CurrentWeekId = int64(2222)
+	funcs.Rename = func(string, string) error { return errors.New("crashed") }
+	td2.Pulses <- int64(-1)
+	<-td2.PulsarReply
+	funcs.Rename = os.Rename
+
+	td3 := New("test-id", "testDir", simulate.New("simulate", "simulation", 300000*100), c)
+	if td3.CurrentWeekId != 1111 {
+		t.Errorf("Expected last complete state with: 1111, Got: %d", td3.CurrentWeekId)
 	}
 }
@@ -0,0 +1,150 @@
+package funcs
+
+import (
+	"time"
+)
+
+// NYSE calendar, computed rather than loaded so it needs no tzdata or holiday files.
+// Rules are the ones in force since 2007 (DST) and 2022 (Juneteenth).  Days are UTC midnights.
+
+// Offset of US Eastern time from UTC at t.  DST runs from 2am local on the second Sunday in March
+// until 2am local on the first Sunday in November.
+func EasternOffset(t time.Time) time.Duration {
+	t = t.UTC()
+	year := t.Year()
+	dstStart := nthWeekday(year, time.March, time.Sunday, 2).Add(7 * time.Hour)
+	dstEnd := nthWeekday(year, time.November, time.Sunday, 1).Add(6 * time.Hour)
+	if !t.Before(dstStart) && t.Before(dstEnd) {
+		return -4 * time.Hour
+	}
+	return -5 * time.Hour
+}
+
+// Close of the last trading day on or before the Friday of timestamp's week.  Weeklies expire then.
+func ExpirationClose(timestamp int64) int64 {
+	return LastClose(NextFriday(time.Unix(timestamp, 0).UTC()))
+}
+
+func IsMarketHoliday(day time.Time) bool {
+	day = day.UTC().Truncate(24 * time.Hour)
+	year := day.Year()
+	holidays := []time.Time{
+		// New Year's Day is not observed on Friday the 31st.
+		observedSundayOnly(date(year, time.January, 1)),
+		nthWeekday(year, time.January, time.Monday, 3),  // Martin Luther King Jr. Day
+		nthWeekday(year, time.February, time.Monday, 3), // Washington's Birthday
+		easter(year).AddDate(0, 0, -2),                  // Good Friday
+		lastWeekday(year, time.May, time.Monday),        // Memorial Day
+		observed(date(year, time.July, 4)),
+		nthWeekday(year, time.September, time.Monday, 1),  // Labor Day
+		nthWeekday(year, time.November, time.Thursday, 4), // Thanksgiving
+		observed(date(year, time.December, 25)),
+	}
+	if year >= 2022 {
+		holidays = append(holidays, observed(date(year, time.June, 19)))
+	}
+	for _, holiday := range holidays {
+		if day.Equal(holiday) {
+			return true
+		}
+	}
+	return false
+}
+
+func IsTradingDay(day time.Time) bool {
+	weekday := day.UTC().Weekday()
+	return weekday != time.Saturday && weekday != time.Sunday && !IsMarketHoliday(day)
+}
+
+// Close of the last trading day on or before day.
+func LastClose(day time.Time) int64 {
+	day = day.UTC().Truncate(24 * time.Hour)
+	for !IsTradingDay(day) {
+		day = day.AddDate(0, 0, -1)
+	}
+	_, close, _ := MarketHours(day)
+	return close
+}
+
+// 9:30am to 4pm Eastern, or 1pm on early close days, as unix timestamps.  ok is false if the market is closed all day.
+func MarketHours(day time.Time) (open int64, close int64, ok bool) {
+	day = day.UTC().Truncate(24 * time.Hour)
+	if !IsTradingDay(day) {
+		return 0, 0, false
+	}
+	// Noon UTC is well clear of the 2am DST switch.
+	offset := EasternOffset(day.Add(12 * time.Hour))
+	open = day.Add(9*time.Hour + 30*time.Minute - offset).Unix()
+	close = day.Add(16*time.Hour - offset).Unix()
+	if isEarlyClose(day) {
+		close = day.Add(13*time.Hour - offset).Unix()
+	}
+	return open, close, true
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// Western Easter Sunday (Anonymous Gregorian algorithm).
+func easter(year int) time.Time {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return date(year, time.Month(month), day)
+}
+
+// Day after Thanksgiving, Christmas Eve and the day before Independence Day, when they are trading days.
+func isEarlyClose(day time.Time) bool {
+	year := day.Year()
+	if day.Equal(nthWeekday(year, time.November, time.Thursday, 4).AddDate(0, 0, 1)) {
+		return true
+	}
+	if day.Equal(date(year, time.December, 24)) {
+		return true
+	}
+	// Only when the 4th itself is the holiday.
+	return day.Equal(date(year, time.July, 3)) && observed(date(year, time.July, 4)).Equal(date(year, time.July, 4))
+}
+
+func lastWeekday(year int, month time.Month, weekday time.Weekday) time.Time {
+	t := date(year, month+1, 1).AddDate(0, 0, -1)
+	for t.Weekday() != weekday {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) time.Time {
+	t := date(year, month, 1)
+	for t.Weekday() != weekday {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t.AddDate(0, 0, 7*(n-1))
+}
+
+// Saturday holidays move to Friday, Sunday holidays to Monday.
+func observed(t time.Time) time.Time {
+	switch t.Weekday() {
+	case time.Saturday:
+		return t.AddDate(0, 0, -1)
+	case time.Sunday:
+		return t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+func observedSundayOnly(t time.Time) time.Time {
+	if t.Weekday() == time.Sunday {
+		return t.AddDate(0, 0, 1)
+	}
+	return t
+}
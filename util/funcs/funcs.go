@@ -1,9 +1,11 @@
 package funcs
 
 import (
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io/fs"
-	"math/rand"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -12,7 +14,8 @@ import (
 	"time"
 )
 
-var MaximumEncodingOrder = []string{"Timestamp", "OptionType", "Strike", "Underlying", "OptionSymbol", "UnderlyingBid", "OptionAsk", "MaximumBid", "MaxTimestamp"}
+var MaximumEncodingOrder = []string{"Timestamp", "OptionType", "Strike", "Underlying", "OptionSymbol", "UnderlyingBid", "OptionAsk", "MaximumBid", "MaxTimestamp", "MinimumBid", "MinTimestamp", "ExpirationBid"}
+var MaximumLegacyEncodingOrder = []string{"Timestamp", "OptionType", "Strike", "Underlying", "OptionSymbol", "UnderlyingBid", "OptionAsk", "MaximumBid", "MaxTimestamp"}
 var OptionEncodingOrder = []string{"Underlying", "Symbol", "Expiration", "Time", "Strike", "Bid", "Ask", "Last", "Volume", "OpenInterest", "IV", "Type"}
 var OrderEncodingOrder = []string{"Id", "Symbol", "Type", "Limitprice", "Volume"}
 var StockEncodingOrder = []string{"Symbol", "Time", "Bid", "Ask", "Last", "High", "Low", "Volume"}
@@ -23,9 +26,61 @@ var MS = func(time time.Time) int64 {
 
 var Now = func() time.Time { return time.Now() }
 
-func ChooseMFromN(m int, n int) []int {
-	rand.Seed(time.Now().UnixNano())
+// Swapped out in tests to simulate crashing before AtomicWriteFile() finishes.
+var Rename = os.Rename
 
+// Appends data as a record that ReadRecords() can tell apart from a torn write.
+// Records are "<data>\t<crc32 hex>" lines.
+func AppendRecord(folderName string, fileName string, data string) error {
+	return LazyAppendFile(folderName, fileName, fmt.Sprintf("%s\t%08x", data, crc32.ChecksumIEEE([]byte(data))))
+}
+
+// Writes to a temp file in the same folder, fsyncs and renames over fileName so readers
+// see either the old contents or the new ones and never half of either.
+func AtomicWriteFile(folderName string, fileName string, data []byte) error {
+	err := os.MkdirAll(folderName, 0777)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(folderName, "."+fileName+".tmp*")
+	if err != nil {
+		return err
+	}
+	// Harmless once renamed.
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Printf("[AtomicWriteFile] Could not write: %s\nErr: %s\n", folderName+"/"+fileName, err)
+		return err
+	}
+
+	err = Rename(tmp.Name(), folderName+"/"+fileName)
+	if err != nil {
+		fmt.Printf("[AtomicWriteFile] Could not rename: %s\nErr: %s\n", folderName+"/"+fileName, err)
+		return err
+	}
+
+	// Make the rename itself durable.  Not all platforms can fsync a directory, so best effort.
+	dir, err := os.Open(folderName)
+	if err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+func ChooseMFromN(r *Rand, m int, n int) []int {
 	bag := []int{}
 	chosen := []int{}
 	for i := range n {
@@ -37,7 +92,7 @@ func ChooseMFromN(m int, n int) []int {
 		return bag
 	}
 	for range m {
-		c := rand.Intn(len(bag))
+		c := r.IntN(len(bag))
 		chosen = append(chosen, bag[c])
 		bag = append(bag[:c], bag[c+1:]...)
 	}
@@ -136,19 +191,68 @@ func GetConfig(path string) ([]string, error) {
 	return lines, nil
 }
 
-func ID(underlying string, weeksBack int, multiplier float64, realTime bool) string {
-	rand.Seed(time.Now().UnixNano())
-
+// seed goes last so SeedFromID() can recover it and the run can be replayed.
+func ID(underlying string, weeksBack int, multiplier float64, realTime bool, seed uint64) string {
 	id := fmt.Sprintf("%s_%02d_%.2f", underlying, weeksBack, multiplier)
-	randomN := rand.Intn(100000)
 	if realTime {
-		id += fmt.Sprintf("_realtime_%04d", randomN)
+		id += fmt.Sprintf("_realtime_%d", seed)
 	} else {
-		id += fmt.Sprintf("_%d_%04d", time.Now().Unix(), randomN)
+		id += fmt.Sprintf("_%d_%d", time.Now().Unix(), seed)
 	}
 	return id
 }
 
+// Every random choice in a run should come from one of these so the run can be replayed from Seed.
+// Not safe for concurrent use.  Fork() one off for each goroutine instead.
+type Rand struct {
+	*rand.Rand
+	pcg  *rand.PCG
+	Seed uint64
+}
+
+func NewRand(seed uint64) *Rand {
+	pcg := rand.NewPCG(seed, seed)
+	return &Rand{Rand: rand.New(pcg), pcg: pcg, Seed: seed}
+}
+
+// New seed for when nobody asked for one.
+func RandomSeed() uint64 {
+	return rand.Uint64()
+}
+
+// Child Rand seeded from r.  Deterministic as long as forks happen in the same order.
+func (r *Rand) Fork() *Rand {
+	return NewRand(r.Uint64())
+}
+
+// Current position in the stream, so a restored Rand picks up where this one left off.
+func (r *Rand) MarshalBinary() ([]byte, error) {
+	state, err := r.pcg.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(binary.BigEndian.AppendUint64(nil, r.Seed), state...), nil
+}
+
+func (r *Rand) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return fmt.Errorf("rand state too short: %d bytes", len(data))
+	}
+	pcg := &rand.PCG{}
+	err := pcg.UnmarshalBinary(data[8:])
+	if err != nil {
+		return err
+	}
+	r.Seed = binary.BigEndian.Uint64(data[:8])
+	r.pcg = pcg
+	r.Rand = rand.New(pcg)
+	return nil
+}
+
+func SeedFromID(id string) (uint64, error) {
+	return strconv.ParseUint(id[strings.LastIndex(id, "_")+1:], 10, 64)
+}
+
 func LastSunday(t time.Time) time.Time {
 	distance := int(time.Sunday) - int(t.Weekday())
 
@@ -167,6 +271,16 @@ func LazyAppendFile(folderName string, fileName string, data string) error {
 	}
 	defer f.Close()
 
+	// A crash mid append leaves a line with no "\n".  Finish it off so it doesn't swallow this one.
+	info, err := f.Stat()
+	if err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		_, err = f.ReadAt(last, info.Size()-1)
+		if err == nil && last[0] != '\n' {
+			data = "\n" + data
+		}
+	}
+
 	_, err = f.WriteString(data + "\n")
 	if err != nil {
 		fmt.Printf("[lazyAppendFile] Could not AppendFile: %s\nErr: %s\n", folderName+"/"+fileName, err)
@@ -217,6 +331,27 @@ func PremiumPct(ask int, strike int, commission float64) float64 {
 	return (float64(ask) + commission) / float64(strike)
 }
 
+// Reads records written by AppendRecord().  torn counts records that failed their checksum,
+// generally the tail end of an append that was interrupted.
+func ReadRecords(path string) (records []string, torn int, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		idx := strings.LastIndex(line, "\t")
+		if idx == -1 || fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(line[:idx]))) != line[idx+1:] {
+			torn += 1
+			continue
+		}
+		records = append(records, line[:idx])
+	}
+	return records, torn, nil
+}
+
 func TimestampID(timestamp int64) int64 {
 	// How many seconds into the week are we?
 	return timestamp - WeekID(timestamp)
@@ -224,7 +359,7 @@ func TimestampID(timestamp int64) int64 {
 
 func UpdateConfig(path string, lines []string) error {
 	f := []byte(strings.Join(lines, "\n"))
-	err := os.WriteFile(path, f, 0777)
+	err := os.WriteFile(path, f, 0600)
 	return err
 }
 
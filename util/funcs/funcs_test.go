@@ -3,21 +3,86 @@ package funcs
 import (
 	"github.com/eliwjones/thebox/util/structs"
 
+	"errors"
 	"math"
+	"os"
 	"reflect"
 	"testing"
 	"time"
 )
 
+func Test_AppendRecord_ReadRecords(t *testing.T) {
+	dir := t.TempDir()
+	AppendRecord(dir, "log", "1,order,one")
+	AppendRecord(dir, "log", "2,order,two")
+
+	// Crash part way through appending a third.
+	f, _ := os.OpenFile(dir+"/log", os.O_WRONLY|os.O_APPEND, 0777)
+	f.WriteString("3,order,th")
+	f.Close()
+
+	// Next append must not run into the torn one.
+	AppendRecord(dir, "log", "4,order,four")
+
+	records, torn, err := ReadRecords(dir + "/log")
+	if err != nil {
+		t.Errorf("Did not expect err: %s", err)
+	}
+	expected := []string{"1,order,one", "2,order,two", "4,order,four"}
+	if !reflect.DeepEqual(records, expected) || torn != 1 {
+		t.Errorf("Expected: %v with 1 torn, Got: %v with %d torn", expected, records, torn)
+	}
+
+	// Torn in the middle of a checksum.
+	data, _ := os.ReadFile(dir + "/log")
+	os.WriteFile(dir+"/log", data[:len(data)-3], 0777)
+	records, torn, _ = ReadRecords(dir + "/log")
+	if len(records) != 2 || torn != 2 {
+		t.Errorf("Expected 2 records with 2 torn, Got: %v with %d torn", records, torn)
+	}
+}
+
+func Test_AtomicWriteFile(t *testing.T) {
+	dir := t.TempDir() + "/state"
+	err := AtomicWriteFile(dir, "state", []byte("old"))
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+
+	// Crash before rename leaves old contents and no temp files behind.
+	Rename = func(string, string) error { return errors.New("crashed") }
+	err = AtomicWriteFile(dir, "state", []byte("new"))
+	Rename = os.Rename
+	if err == nil {
+		t.Errorf("Expected err from interrupted write.")
+	}
+	data, _ := os.ReadFile(dir + "/state")
+	if string(data) != "old" {
+		t.Errorf("Expected: old, Got: %s", data)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only state file, Got: %d entries", len(entries))
+	}
+
+	err = AtomicWriteFile(dir, "state", []byte("new"))
+	data, _ = os.ReadFile(dir + "/state")
+	if err != nil || string(data) != "new" {
+		t.Errorf("Expected: new, Got: %s, Err: %v", data, err)
+	}
+}
+
 func Test_ChooseMFromN(t *testing.T) {
 	j := 5
 	for i := 0; i < j+2; i++ {
-		bag := ChooseMFromN(i, j)
+		bag := ChooseMFromN(NewRand(uint64(i)), i, j)
 		l := int(math.Min(float64(i), float64(j)))
 		if len(bag) != l {
 			t.Errorf("Expected bag of length %d! Got %d!", l, len(bag))
 		}
-
+		if !reflect.DeepEqual(bag, ChooseMFromN(NewRand(uint64(i)), i, j)) {
+			t.Errorf("Expected same bag for same seed!")
+		}
 	}
 }
 
@@ -25,6 +90,9 @@ func Test_EncodeDecodeMaximum(t *testing.T) {
 	m := structs.Maximum{Expiration: "20150101", OptionSymbol: "GOOG_013015C610", Timestamp: int64(1000000001),
 		Underlying: "GOOG", MaximumBid: 100, OptionAsk: 50, OptionBid: 40, OptionType: "c", Strike: 610, UnderlyingBid: 50000, Volume: 100}
 	m.MaxTimestamp = m.Timestamp + int64(24*60*60)
+	m.MinimumBid = 20
+	m.MinTimestamp = m.Timestamp + int64(60*60)
+	m.ExpirationBid = 30
 
 	em, err := Encode(&m, MaximumEncodingOrder)
 	if err != nil {
@@ -62,6 +130,26 @@ func Test_EncodeDecodeMaximum(t *testing.T) {
 	if m2.MaxTimestamp != m.MaxTimestamp {
 		t.Errorf("Expected: %d, Got: %d", m.MaxTimestamp, m2.MaxTimestamp)
 	}
+	if m2.MinimumBid != m.MinimumBid {
+		t.Errorf("Expected: %d, Got: %d", m.MinimumBid, m2.MinimumBid)
+	}
+	if m2.MinTimestamp != m.MinTimestamp {
+		t.Errorf("Expected: %d, Got: %d", m.MinTimestamp, m2.MinTimestamp)
+	}
+	if m2.ExpirationBid != m.ExpirationBid {
+		t.Errorf("Expected: %d, Got: %d", m.ExpirationBid, m2.ExpirationBid)
+	}
+
+	// Legacy encoding should decode without drawdown fields.
+	lm, _ := Encode(&m, MaximumLegacyEncodingOrder)
+	m3 := structs.Maximum{}
+	err = Decode(lm, &m3, MaximumLegacyEncodingOrder)
+	if err != nil {
+		t.Errorf("Decode legacy Maximum err: %s!", err)
+	}
+	if m3.MaxTimestamp != m.MaxTimestamp || m3.MinimumBid != 0 {
+		t.Errorf("Expected MaxTimestamp: %d and zero MinimumBid, Got: %d, %d", m.MaxTimestamp, m3.MaxTimestamp, m3.MinimumBid)
+	}
 }
 
 func Test_EncodeDecodeOption(t *testing.T) {
@@ -139,19 +227,65 @@ func Test_getConfig(t *testing.T) {
 }
 
 func Test_ID(t *testing.T) {
-	id1 := ID("GOOG", 8, 1.5, false)
-	id2 := ID("GOOG", 8, 1.5, false)
+	id1 := ID("GOOG", 8, 1.5, false, 1)
+	id2 := ID("GOOG", 8, 1.5, false, 2)
 
 	if id1 == id2 {
 		t.Errorf("IDs should not be equal!\n%s\n%s", id1, id2)
 	}
 
-	id1 = ID("GOOG", 8, 1.5, true)
-	id2 = ID("GOOG", 8, 1.5, true)
+	id1 = ID("GOOG", 8, 1.5, true, 1)
+	id2 = ID("GOOG", 8, 1.5, true, 2)
 
 	if id1 == id2 {
 		t.Errorf("IDs should not be equal!\n%s\n%s", id1, id2)
 	}
+
+	for _, realTime := range []bool{false, true} {
+		seed := RandomSeed()
+		id := ID("GOOG", 8, 1.5, realTime, seed)
+		got, err := SeedFromID(id)
+		if err != nil || got != seed {
+			t.Errorf("Expected seed: %d from %s, Got: %d, Err: %v", seed, id, got, err)
+		}
+	}
+}
+
+func Test_Rand(t *testing.T) {
+	r1 := NewRand(7)
+	r2 := NewRand(7)
+	for range 10 {
+		if r1.Uint64() != r2.Uint64() {
+			t.Fatalf("Expected same sequence for same seed.")
+		}
+	}
+
+	// Restored Rand continues the stream.
+	state, err := r1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	expected := []int{r1.IntN(1000), r1.IntN(1000), r1.IntN(1000)}
+	restored := &Rand{}
+	err = restored.UnmarshalBinary(state)
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	got := []int{restored.IntN(1000), restored.IntN(1000), restored.IntN(1000)}
+	if !reflect.DeepEqual(expected, got) || restored.Seed != 7 {
+		t.Errorf("Expected: %v, Got: %v, Seed: %d", expected, got, restored.Seed)
+	}
+
+	// Forks are deterministic and independent of the parent.
+	f1, f2 := NewRand(7).Fork(), NewRand(7).Fork()
+	if f1.Seed != f2.Seed || f1.Seed == 7 || f1.Uint64() != f2.Uint64() {
+		t.Errorf("Expected matching forks, Got seeds: %d, %d", f1.Seed, f2.Seed)
+	}
+
+	err = restored.UnmarshalBinary([]byte("short"))
+	if err == nil {
+		t.Errorf("Expected err for short state.")
+	}
 }
 
 func Test_LastSunday(t *testing.T) {
@@ -239,3 +373,47 @@ func Test_WeekID(t *testing.T) {
 		}
 	}
 }
+
+func Test_MarketHours(t *testing.T) {
+	tests := []struct {
+		day   string
+		open  string
+		close string
+	}{
+		{"20150127", "20150127 14:30", "20150127 21:00"}, // EST
+		{"20150706", "20150706 13:30", "20150706 20:00"}, // EDT
+		{"20150403", "", ""},                             // Good Friday
+		{"20150119", "", ""},                             // MLK
+		{"20150703", "", ""},                             // Independence Day observed
+		{"20150704", "", ""},                             // Saturday
+		{"20151127", "20151127 14:30", "20151127 18:00"}, // Day after Thanksgiving
+		{"20241224", "20241224 14:30", "20241224 18:00"}, // Christmas Eve
+		{"20230619", "", ""},                             // Juneteenth
+		{"20150619", "20150619 13:30", "20150619 20:00"}, // Not yet Juneteenth
+		{"20160101", "", ""},                             // New Year's
+		{"20211231", "20211231 14:30", "20211231 21:00"}, // Saturday New Year's is not observed
+		{"20170102", "", ""},                             // Sunday New Year's is
+	}
+	for _, test := range tests {
+		day, _ := time.Parse("20060102", test.day)
+		open, close, ok := MarketHours(day)
+		if test.open == "" {
+			if ok {
+				t.Errorf("%s: Expected closed.", test.day)
+			}
+			continue
+		}
+		expectedOpen, _ := time.Parse("20060102 15:04", test.open)
+		expectedClose, _ := time.Parse("20060102 15:04", test.close)
+		if !ok || open != expectedOpen.Unix() || close != expectedClose.Unix() {
+			t.Errorf("%s: Expected %s to %s, Got: %s to %s", test.day, test.open, test.close,
+				time.Unix(open, 0).UTC().Format("20060102 15:04"), time.Unix(close, 0).UTC().Format("20060102 15:04"))
+		}
+	}
+
+	// Week of Good Friday 2015 expires Thursday.
+	expected, _ := time.Parse("20060102 15:04", "20150402 20:00")
+	if ExpirationClose(1427724000) != expected.Unix() {
+		t.Errorf("Expected: %d, Got: %d", expected.Unix(), ExpirationClose(1427724000))
+	}
+}
@@ -17,3 +17,14 @@ type Adapter interface {
 	Reset()                                                                           // Reset all orders, positions, and value.
 	SubmitOrder(order structs.Order) (string, error)
 }
+
+type Secrets interface {
+	Credentials(adapter string) (structs.Credentials, error) // Login info for adapter.
+	Token(adapter string) string                             // Last session token for adapter.  "" if none.
+	SaveToken(adapter string, token string) error            // Persist refreshed session token.
+}
+
+type Streamer interface {
+	Stream(symbols []string, pipe chan structs.Message) error // Push Stock and Option updates for symbols into pipe.  Message with nil Data when done.
+	Close() error                                             // Stop streaming.
+}
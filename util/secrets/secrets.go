@@ -0,0 +1,284 @@
+package secrets
+
+import (
+	"github.com/eliwjones/thebox/util/structs"
+
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	ITERATIONS   = 200000 // PBKDF2 rounds for passphrases.
+	KEY_FILE_LEN = 32     // Minimum bytes in a key file.
+	VERSION      = 1
+)
+
+// Credentials and session tokens encrypted at rest with AES-256-GCM.
+// Tokens may live in their own file since they get rewritten far more often than credentials.
+type Store struct {
+	key       Key
+	path      string
+	tokenPath string
+
+	mu          sync.Mutex
+	credentials map[string]structs.Credentials
+	tokens      map[string]string
+}
+
+// Turns a per-file salt into an AES-256 key.
+type Key interface {
+	derive(salt []byte) []byte
+}
+
+type keyFile []byte
+type passphrase string
+
+// What actually hits the disk.
+type envelope struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+type contents struct {
+	Credentials map[string]structs.Credentials `json:"credentials,omitempty"`
+	Tokens      map[string]string              `json:"tokens,omitempty"`
+}
+
+func (k keyFile) derive(salt []byte) []byte {
+	mac := hmac.New(sha256.New, k)
+	mac.Write(salt)
+	return mac.Sum(nil)
+}
+
+func (p passphrase) derive(salt []byte) []byte {
+	return pbkdf2([]byte(p), salt, ITERATIONS, 32)
+}
+
+// Writes KEY_FILE_LEN random bytes to path for use with KeyFile().
+func GenerateKeyFile(path string) error {
+	k := make([]byte, KEY_FILE_LEN)
+	_, err := rand.Read(k)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, k)
+}
+
+func KeyFile(path string) (Key, error) {
+	err := checkPermissions(path)
+	if err != nil {
+		return nil, err
+	}
+	k, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(k) < KEY_FILE_LEN {
+		return nil, fmt.Errorf("%s: key file must be at least %d bytes", path, KEY_FILE_LEN)
+	}
+	return keyFile(k), nil
+}
+
+func Passphrase(p string) Key {
+	return passphrase(p)
+}
+
+// Missing files are fine and make for an empty Store.  tokenPath may be "" to keep tokens in path.
+func Open(path string, tokenPath string, key Key) (*Store, error) {
+	s := &Store{key: key, path: path, tokenPath: tokenPath}
+	s.credentials = map[string]structs.Credentials{}
+	s.tokens = map[string]string{}
+
+	c, err := s.read(path)
+	if err != nil {
+		return nil, err
+	}
+	for name, creds := range c.Credentials {
+		s.credentials[name] = creds
+	}
+	for name, token := range c.Tokens {
+		s.tokens[name] = token
+	}
+	if tokenPath != "" {
+		c, err = s.read(tokenPath)
+		if err != nil {
+			return nil, err
+		}
+		for name, token := range c.Tokens {
+			s.tokens[name] = token
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Store) Credentials(adapter string) (structs.Credentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	creds, exists := s.credentials[adapter]
+	if !exists {
+		return creds, fmt.Errorf("no credentials for adapter: %s", adapter)
+	}
+	return creds, nil
+}
+
+func (s *Store) SaveToken(adapter string, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[adapter] = token
+	if s.tokenPath == "" {
+		return s.write(s.path, contents{Credentials: s.credentials, Tokens: s.tokens})
+	}
+	return s.write(s.tokenPath, contents{Tokens: s.tokens})
+}
+
+func (s *Store) SetCredentials(adapter string, creds structs.Credentials) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.credentials[adapter] = creds
+	c := contents{Credentials: s.credentials}
+	if s.tokenPath == "" {
+		c.Tokens = s.tokens
+	}
+	return s.write(s.path, c)
+}
+
+func (s *Store) Token(adapter string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.tokens[adapter]
+}
+
+func (s *Store) read(path string) (contents, error) {
+	c := contents{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	err = checkPermissions(path)
+	if err != nil {
+		return c, err
+	}
+
+	e := envelope{}
+	err = json.Unmarshal(data, &e)
+	if err != nil {
+		return c, fmt.Errorf("%s: %s", path, err)
+	}
+	if e.Version != VERSION {
+		return c, fmt.Errorf("%s: unknown version: %d", path, e.Version)
+	}
+	gcm, err := newGCM(s.key.derive(e.Salt))
+	if err != nil {
+		return c, err
+	}
+	plaintext, err := gcm.Open(nil, e.Nonce, e.Data, nil)
+	if err != nil {
+		return c, fmt.Errorf("%s: wrong key or corrupted file", path)
+	}
+	err = json.Unmarshal(plaintext, &c)
+	return c, err
+}
+
+func (s *Store) write(path string, c contents) error {
+	plaintext, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	// Fresh salt and nonce every write.
+	e := envelope{Version: VERSION, Salt: make([]byte, 16)}
+	_, err = rand.Read(e.Salt)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(s.key.derive(e.Salt))
+	if err != nil {
+		return err
+	}
+	e.Nonce = make([]byte, gcm.NonceSize())
+	_, err = rand.Read(e.Nonce)
+	if err != nil {
+		return err
+	}
+	e.Data = gcm.Seal(nil, e.Nonce, plaintext, nil)
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data)
+}
+
+// Refuse to use secrets anyone else can read.
+func checkPermissions(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s: permissions %o are too open. chmod 600", path, info.Mode().Perm())
+	}
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// RFC 8018 PBKDF2 with HMAC-SHA256.
+func pbkdf2(password []byte, salt []byte, iterations int, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	dk := []byte{}
+	for block := 1; len(dk) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		dk = append(dk, t...)
+	}
+	return dk[:keyLen]
+}
+
+func writeFile(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		return err
+	}
+	// WriteFile leaves permissions alone on existing files.
+	return os.Chmod(path, 0600)
+}
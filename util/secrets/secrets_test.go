@@ -0,0 +1,128 @@
+package secrets
+
+import (
+	"github.com/eliwjones/thebox/util/structs"
+
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_pbkdf2(t *testing.T) {
+	// Known PBKDF2-HMAC-SHA256 vectors.
+	expected := map[int]string{
+		1: "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b",
+		2: "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43",
+	}
+	for iterations, e := range expected {
+		dk := hex.EncodeToString(pbkdf2([]byte("password"), []byte("salt"), iterations, 32))
+		if dk != e {
+			t.Errorf("Expected: %s, Got: %s", e, dk)
+		}
+	}
+}
+
+func Test_Store_Open(t *testing.T) {
+	dir := t.TempDir()
+	err := GenerateKeyFile(dir + "/key")
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	key, err := KeyFile(dir + "/key")
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+
+	s, err := Open(dir+"/secrets", dir+"/token", key)
+	if err != nil {
+		t.Fatalf("Expected empty store for missing files. Got: %s", err)
+	}
+	creds := structs.Credentials{Id: "user", Auth: "password", Source: "source"}
+	s.SetCredentials("tdameritrade", creds)
+	s.SaveToken("tdameritrade", "session")
+
+	data, _ := os.ReadFile(dir + "/secrets")
+	if strings.Contains(string(data), "password") || strings.Contains(string(data), "user") {
+		t.Errorf("Expected encrypted credentials, Got: %s", data)
+	}
+	data, _ = os.ReadFile(dir + "/token")
+	if strings.Contains(string(data), "session") {
+		t.Errorf("Expected encrypted token, Got: %s", data)
+	}
+
+	s, err = Open(dir+"/secrets", dir+"/token", key)
+	if err != nil {
+		t.Fatalf("Did not expect err: %s", err)
+	}
+	c, err := s.Credentials("tdameritrade")
+	if err != nil || c != creds {
+		t.Errorf("Expected: %v, Got: %v, Err: %v", creds, c, err)
+	}
+	if s.Token("tdameritrade") != "session" {
+		t.Errorf("Expected: session, Got: %s", s.Token("tdameritrade"))
+	}
+	_, err = s.Credentials("simulate")
+	if err == nil {
+		t.Errorf("Expected err for missing adapter.")
+	}
+
+	// Tokens are only written to the token file.
+	before, _ := os.ReadFile(dir + "/secrets")
+	s.SaveToken("tdameritrade", "newsession")
+	after, _ := os.ReadFile(dir + "/secrets")
+	if string(before) != string(after) {
+		t.Errorf("Expected credentials file to be left alone.")
+	}
+
+	// Wrong key.
+	GenerateKeyFile(dir + "/otherkey")
+	other, _ := KeyFile(dir + "/otherkey")
+	_, err = Open(dir+"/secrets", "", other)
+	if err == nil {
+		t.Errorf("Expected err for wrong key.")
+	}
+	_, err = Open(dir+"/secrets", "", Passphrase("password"))
+	if err == nil {
+		t.Errorf("Expected err for wrong key.")
+	}
+
+	// Readable by others.
+	os.Chmod(dir+"/secrets", 0644)
+	_, err = Open(dir+"/secrets", "", key)
+	if err == nil {
+		t.Errorf("Expected err for 0644 secrets.")
+	}
+	os.Chmod(dir+"/key", 0644)
+	_, err = KeyFile(dir + "/key")
+	if err == nil {
+		t.Errorf("Expected err for 0644 key file.")
+	}
+}
+
+func Test_Store_Passphrase(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir+"/secrets", "", Passphrase("hunter2"))
+	s.SaveToken("simulate", "session")
+
+	info, _ := os.Stat(dir + "/secrets")
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected 0600, Got: %o", info.Mode().Perm())
+	}
+
+	s, err := Open(dir+"/secrets", "", Passphrase("hunter2"))
+	if err != nil || s.Token("simulate") != "session" {
+		t.Errorf("Expected: session, Got: %s, Err: %v", s.Token("simulate"), err)
+	}
+	_, err = Open(dir+"/secrets", "", Passphrase("hunter3"))
+	if err == nil {
+		t.Errorf("Expected err for wrong passphrase.")
+	}
+
+	short := dir + "/short"
+	os.WriteFile(short, []byte("tooshort"), 0600)
+	_, err = KeyFile(short)
+	if err == nil {
+		t.Errorf("Expected err for short key file.")
+	}
+}
@@ -28,6 +28,17 @@ type Maximum struct {
 	UnderlyingBid int
 	Volume        int
 	MaxTimestamp  int64
+
+	// Drawdown tracking.  Zero for maximums decoded from legacy encodings.
+	MinimumBid    int   // Lowest Bid seen after entry.
+	MinTimestamp  int64 // When did MinimumBid occur.
+	ExpirationBid int   // Last Bid seen before expiration.
+}
+
+type Credentials struct {
+	Id     string // username
+	Auth   string // password or whatnot.
+	Source string // App Source ID, if adapter needs one.
 }
 
 // For now, intuitively setting all prices to cents.
@@ -66,6 +77,7 @@ type Position struct {
 }
 
 type ProtoOrder struct {
+	Expiration string            // Of the option, "20150130".  Empty for stocks.
 	LimitOpen  int               // Set by Destiny from chosen edge.
 	LimitTS    int64             // Given edge used to construct this, when might we expect a maximum by?
 	Symbol     string            // "GOOG", "GOOG_030615C620"
@@ -73,6 +85,7 @@ type ProtoOrder struct {
 	Type       util.ContractType // util.OPTION, util.STOCK
 	Underlying string            // Tacking this in here to facilitate Trader GetQuote() lookups.
 
+	// Optional.  Trader replies once: the Order id string if submitted, else a *trader.RiskError saying why not.
 	Reply chan any `json:"-"`
 }
 
@@ -99,6 +112,7 @@ type Stock struct {
 }
 
 type Message struct {
-	Data  any      // Shall this be an interface?
-	Reply chan any // Reply if needed..
+	Data      any      // Shall this be an interface?
+	Reply     chan any // Reply if needed..
+	Timestamp int64    // Seconds since epoch Data was seen.  0 means now.
 }
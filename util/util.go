@@ -1,8 +1,75 @@
 package util
 
+import (
+	"errors"
+	"fmt"
+)
+
 type ContractType int
 
 const (
 	OPTION ContractType = iota
 	STOCK
 )
+
+// What sort of thing went wrong talking to an Adapter.
+type ErrorKind int
+
+const (
+	UNKNOWN   ErrorKind = iota
+	AUTH                // Bad credentials or expired session.
+	RATELIMIT           // Broker wants us to back off.
+	BADDATA             // Got a response, but it makes no sense.
+	NETWORK             // Never got a response.
+)
+
+var errorKindNames = []string{"UNKNOWN", "AUTH", "RATELIMIT", "BADDATA", "NETWORK"}
+
+// Adapters return these so callers can tell failures apart without parsing strings.
+type AdapterError struct {
+	Adapter string
+	Kind    ErrorKind
+	Err     error
+}
+
+func NewAdapterError(adapter string, kind ErrorKind, format string, a ...any) *AdapterError {
+	return &AdapterError{Adapter: adapter, Kind: kind, Err: fmt.Errorf(format, a...)}
+}
+
+func (e *AdapterError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Adapter, e.Kind, e.Err)
+}
+
+func (e *AdapterError) Unwrap() error {
+	return e.Err
+}
+
+// UNKNOWN for anything that isn't, or doesn't wrap, an AdapterError.
+func Kind(err error) ErrorKind {
+	var ae *AdapterError
+	if errors.As(err, &ae) {
+		return ae.Kind
+	}
+	return UNKNOWN
+}
+
+func (k ErrorKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k ErrorKind) String() string {
+	if k < 0 || int(k) >= len(errorKindNames) {
+		return fmt.Sprintf("ErrorKind(%d)", int(k))
+	}
+	return errorKindNames[k]
+}
+
+func (k *ErrorKind) UnmarshalText(text []byte) error {
+	for idx, name := range errorKindNames {
+		if name == string(text) {
+			*k = ErrorKind(idx)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown ErrorKind: '%s'", text)
+}